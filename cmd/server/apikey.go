@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/Chamistery/Test_task/internal/auth"
+	"github.com/Chamistery/Test_task/internal/models"
+)
+
+const apikeyUsage = "usage: reviewer-service apikey create -name NAME -role admin|team-lead|bot|read-only [-team TEAM] | list | revoke ID"
+
+// runAPIKey manages keys directly in the database, which is how the first
+// admin key is bootstrapped before anyone can call /apikey/create.
+func runAPIKey(args []string) error {
+	if len(args) == 0 {
+		return errors.New(apikeyUsage)
+	}
+
+	driver := getEnv("STORAGE_DRIVER", "postgres")
+	if driver == "memory" {
+		return errors.New("api keys cannot be managed for the memory storage driver")
+	}
+
+	store, err := openStorage(driver)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	switch args[0] {
+	case "create":
+		flags := flag.NewFlagSet("apikey create", flag.ContinueOnError)
+		var req models.CreateAPIKeyRequest
+		flags.StringVar(&req.Name, "name", "", "key name recorded as the audit actor")
+		flags.StringVar(&req.Role, "role", models.RoleAdmin, "key role")
+		flags.StringVar(&req.TeamName, "team", "", "team led by a team-lead key")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		if req.TeamName != "" {
+			exists, err := store.TeamExists(ctx, req.TeamName)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				return fmt.Errorf("team %s does not exist", req.TeamName)
+			}
+		}
+
+		created, err := auth.NewAPIKeys(store).Issue(ctx, req)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("created api key %d (%s, %s); it is shown only once:\n%s\n", created.APIKey.ID, created.APIKey.Name, created.APIKey.Role, created.Key)
+		return nil
+
+	case "list":
+		keys, err := store.GetAPIKeys(ctx)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			state := "active"
+			if key.RevokedAt != nil {
+				state = "revoked " + key.RevokedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%4d %-20s %-10s %-20s %s\n", key.ID, key.Name, key.Role, key.TeamName, state)
+		}
+		return nil
+
+	case "revoke":
+		if len(args) != 2 {
+			return errors.New(apikeyUsage)
+		}
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("id must be an integer, got %q", args[1])
+		}
+		if err := store.RevokeAPIKey(ctx, id); err != nil {
+			return fmt.Errorf("revoke api key %d: %w", id, err)
+		}
+		fmt.Printf("revoked api key %d\n", id)
+		return nil
+	}
+
+	return errors.New(apikeyUsage)
+}
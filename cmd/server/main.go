@@ -1,44 +1,126 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 
+	"github.com/Chamistery/Test_task/internal/auth"
+	"github.com/Chamistery/Test_task/internal/events"
 	"github.com/Chamistery/Test_task/internal/handlers"
+	"github.com/Chamistery/Test_task/internal/router"
 	"github.com/Chamistery/Test_task/internal/storage"
+	"github.com/Chamistery/Test_task/internal/webhooks"
 )
 
 func main() {
-	dbConfig := storage.DBConfig{
-		Host:     getEnv("DB_HOST", "postgres"),
-		Port:     getEnv("DB_PORT", "5432"),
-		User:     getEnv("DB_USER", "postgres"),
-		Password: getEnv("DB_PASSWORD", "postgres"),
-		DBName:   getEnv("DB_NAME", "reviewer_service"),
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(os.Args[2:]); err != nil {
+			log.Fatal("Migration failed:", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "apikey" {
+		if err := runAPIKey(os.Args[2:]); err != nil {
+			log.Fatal("API key command failed:", err)
+		}
+		return
 	}
 
-	store, err := storage.NewPostgresStorage(dbConfig)
+	store, err := openStorage(getEnv("STORAGE_DRIVER", "postgres"))
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
 	defer store.Close()
 
-	h := handlers.NewHandlers(store)
+	dispatcher := events.NewDispatcher(store, events.DispatcherConfig{
+		PollInterval: getDurationEnv("OUTBOX_POLL_INTERVAL", time.Second),
+		MaxAttempts:  int(getInt64Env("OUTBOX_MAX_ATTEMPTS", 8)),
+		BaseBackoff:  getDurationEnv("OUTBOX_RETRY_BACKOFF", time.Second),
+	})
+	if getEnv("OUTBOX_LOG_EVENTS", "false") == "true" {
+		dispatcher.Register(events.LogSink{})
+	}
+	dispatcher.Register(webhooks.NewSink(store))
+	go dispatcher.Run(context.Background())
+
+	deliverer := webhooks.NewDeliverer(store, webhooks.Config{
+		PollInterval: getDurationEnv("WEBHOOK_POLL_INTERVAL", time.Second),
+		BaseBackoff:  getDurationEnv("WEBHOOK_RETRY_BACKOFF", 10*time.Second),
+	})
+	go deliverer.Run(context.Background())
+
+	authenticator, err := newAuthenticator(getEnv("AUTH_MODE", "none"), store)
+	if err != nil {
+		log.Fatal("Failed to configure authentication:", err)
+	}
 
-	http.HandleFunc("/team/add", h.HandleTeamAdd)
-	http.HandleFunc("/team/get", h.HandleTeamGet)
-	http.HandleFunc("/users/setIsActive", h.HandleUserSetIsActive)
-	http.HandleFunc("/pullRequest/create", h.HandlePullRequestCreate)
-	http.HandleFunc("/pullRequest/merge", h.HandlePullRequestMerge)
-	http.HandleFunc("/pullRequest/reassign", h.HandlePullRequestReassign)
-	http.HandleFunc("/users/getReview", h.HandleUsersGetReview)
+	h := handlers.NewHandlers(store,
+		handlers.WithGitHubSecret(os.Getenv("GITHUB_WEBHOOK_SECRET")),
+		handlers.WithGitLabToken(os.Getenv("GITLAB_WEBHOOK_TOKEN")),
+		handlers.WithAuthenticator(authenticator),
+	)
 
-	http.HandleFunc("/statistics", h.HandleStatistics)
-	http.HandleFunc("/team/deactivate", h.HandleTeamDeactivate)
+	server := router.New(h, router.Config{
+		RequestTimeout: getDurationEnv("REQUEST_TIMEOUT", 10*time.Second),
+		MaxBodyBytes:   getInt64Env("MAX_BODY_BYTES", 1<<20),
+		AllowedOrigins: router.ParseOrigins(os.Getenv("CORS_ALLOWED_ORIGINS")),
+	})
 
 	log.Println("Server starting on :8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	log.Fatal(http.ListenAndServe(":8080", server))
+}
+
+func openStorage(driver string) (storage.Storage, error) {
+	switch driver {
+	case "postgres":
+		return storage.NewPostgresStorage(postgresConfig())
+	case "sqlite":
+		return storage.NewSQLiteStorage(storage.SQLiteConfig{
+			Path:         getEnv("SQLITE_PATH", "reviewer_service.db"),
+			QueryTimeout: getDurationEnv("DB_QUERY_TIMEOUT", 5*time.Second),
+		})
+	case "memory":
+		return storage.NewMemoryStorage(), nil
+	}
+
+	return nil, fmt.Errorf("unknown STORAGE_DRIVER %q (expected postgres, sqlite or memory)", driver)
+}
+
+func newAuthenticator(mode string, store storage.Storage) (auth.Authenticator, error) {
+	switch mode {
+	case "none":
+		log.Println("AUTH_MODE=none: every endpoint is served without authentication")
+		return nil, nil
+	case "apikey":
+		return auth.NewAPIKeys(store), nil
+	case "jwt":
+		return auth.NewJWT(store, auth.JWTConfig{
+			JWKS:      os.Getenv("JWT_JWKS"),
+			Issuer:    os.Getenv("JWT_ISSUER"),
+			Audience:  os.Getenv("JWT_AUDIENCE"),
+			UserClaim: getEnv("JWT_USER_CLAIM", "sub"),
+			RoleClaim: getEnv("JWT_ROLE_CLAIM", "role"),
+			CacheTTL:  getDurationEnv("JWT_JWKS_CACHE_TTL", 5*time.Minute),
+		})
+	}
+
+	return nil, fmt.Errorf("unknown AUTH_MODE %q (expected none, apikey or jwt)", mode)
+}
+
+func postgresConfig() storage.DBConfig {
+	return storage.DBConfig{
+		Host:         getEnv("DB_HOST", "postgres"),
+		Port:         getEnv("DB_PORT", "5432"),
+		User:         getEnv("DB_USER", "postgres"),
+		Password:     getEnv("DB_PASSWORD", "postgres"),
+		DBName:       getEnv("DB_NAME", "reviewer_service"),
+		QueryTimeout: getDurationEnv("DB_QUERY_TIMEOUT", 5*time.Second),
+	}
 }
 
 func getEnv(key, defaultValue string) string {
@@ -47,3 +129,29 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("Invalid %s %q: %v", key, value, err)
+	}
+	return duration
+}
+
+func getInt64Env(key string, defaultValue int64) int64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	number, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		log.Fatalf("Invalid %s %q: %v", key, value, err)
+	}
+	return number
+}
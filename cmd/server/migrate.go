@@ -0,0 +1,87 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/Chamistery/Test_task/internal/storage"
+	"github.com/Chamistery/Test_task/internal/storage/migrations"
+)
+
+const migrateUsage = "usage: reviewer-service migrate up | down [steps] | status"
+
+func runMigrate(args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	driver := getEnv("STORAGE_DRIVER", "postgres")
+
+	var db *sql.DB
+	var err error
+	switch driver {
+	case "postgres":
+		db, err = storage.ConnectPostgres(postgresConfig())
+	case "sqlite":
+		db, err = storage.ConnectSQLite(getEnv("SQLITE_PATH", "reviewer_service.db"))
+	default:
+		return fmt.Errorf("STORAGE_DRIVER %q has no migrations", driver)
+	}
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrator, err := migrations.New(db, driver)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up()
+		for _, migration := range applied {
+			fmt.Printf("applied  %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+		return err
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("steps must be a positive integer, got %q", args[1])
+			}
+		}
+
+		reverted, err := migrator.Down(steps)
+		for _, migration := range reverted {
+			fmt.Printf("reverted %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(reverted) == 0 {
+			fmt.Println("nothing to revert")
+		}
+		return err
+
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			state := "pending"
+			if status.AppliedAt != nil {
+				state = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%-30s %s\n", status.Version, status.Name, state)
+		}
+		return nil
+	}
+
+	return errors.New(migrateUsage)
+}
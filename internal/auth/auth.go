@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/Chamistery/Test_task/internal/models"
+	"github.com/Chamistery/Test_task/internal/storage"
+)
+
+var ErrUnauthenticated = errors.New("missing or invalid credentials")
+
+// Principal is the authenticated caller. Subject is recorded as the audit
+// actor; TeamName scopes a team lead. UserID is set when the caller is a user
+// of this service rather than an API key.
+type Principal struct {
+	Subject  string
+	Role     string
+	TeamName string
+	UserID   string
+}
+
+// Access is the least privilege a route requires.
+type Access int
+
+const (
+	AccessPublic Access = iota
+	AccessRead
+	AccessWrite
+	AccessTeam
+	AccessAdmin
+)
+
+func IsKnownRole(role string) bool {
+	switch role {
+	case models.RoleAdmin, models.RoleTeamLead, models.RoleBot, models.RoleReadOnly:
+		return true
+	}
+	return false
+}
+
+// Allows reports whether the principal's role reaches the access level.
+// AccessTeam is further narrowed to the lead's own team by ManagesTeam.
+func (p *Principal) Allows(access Access) bool {
+	switch access {
+	case AccessPublic, AccessRead:
+		return IsKnownRole(p.Role) || p.Role == models.RoleMember
+	case AccessWrite:
+		return p.Role == models.RoleAdmin || p.Role == models.RoleTeamLead || p.Role == models.RoleBot || p.Role == models.RoleMember
+	case AccessTeam:
+		return p.Role == models.RoleAdmin || p.Role == models.RoleTeamLead
+	}
+	return p.Role == models.RoleAdmin
+}
+
+func (p *Principal) ManagesTeam(teamName string) bool {
+	return p.Role == models.RoleAdmin || (p.Role == models.RoleTeamLead && teamName != "" && p.TeamName == teamName)
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// FromContext returns the authenticated caller, or nil when authentication is
+// disabled.
+func FromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalKey{}).(*Principal)
+	return principal
+}
+
+// An Authenticator turns a bearer token into a principal, returning
+// ErrUnauthenticated for tokens it does not accept.
+type Authenticator interface {
+	Authenticate(ctx context.Context, token string) (*Principal, error)
+}
+
+const keyPrefix = "rsk_"
+
+// HashKey is what gets stored and looked up. Keys carry 256 bits of entropy,
+// so a plain SHA-256 is enough to make a leaked table useless.
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+type APIKeys struct {
+	store storage.Storage
+}
+
+func NewAPIKeys(store storage.Storage) *APIKeys {
+	return &APIKeys{store: store}
+}
+
+func (a *APIKeys) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	key, err := a.store.GetAPIKeyByHash(ctx, HashKey(token))
+	if err != nil {
+		return nil, err
+	}
+	if key == nil || key.RevokedAt != nil {
+		return nil, ErrUnauthenticated
+	}
+
+	return &Principal{
+		Subject:  "apikey:" + key.Name,
+		Role:     key.Role,
+		TeamName: key.TeamName,
+	}, nil
+}
+
+func ValidateKeyRequest(req models.CreateAPIKeyRequest) error {
+	switch {
+	case req.Name == "":
+		return errors.New("name is required")
+	case !IsKnownRole(req.Role):
+		return fmt.Errorf("unknown role %q", req.Role)
+	case req.Role == models.RoleTeamLead && req.TeamName == "":
+		return errors.New("team_name is required for a team-lead key")
+	case req.Role != models.RoleTeamLead && req.TeamName != "":
+		return errors.New("team_name only applies to team-lead keys")
+	}
+	return nil
+}
+
+// Issue generates and stores a new key. The plaintext is only in the result.
+func (a *APIKeys) Issue(ctx context.Context, req models.CreateAPIKeyRequest) (*models.CreateAPIKeyResponse, error) {
+	if err := ValidateKeyRequest(req); err != nil {
+		return nil, err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	plaintext := keyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	key := models.APIKey{Name: req.Name, Role: req.Role, TeamName: req.TeamName}
+	if err := a.store.CreateAPIKey(ctx, &key, HashKey(plaintext)); err != nil {
+		return nil, err
+	}
+
+	return &models.CreateAPIKeyResponse{APIKey: key, Key: plaintext}, nil
+}
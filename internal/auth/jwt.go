@@ -0,0 +1,387 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Chamistery/Test_task/internal/models"
+	"github.com/Chamistery/Test_task/internal/storage"
+)
+
+type JWTConfig struct {
+	// JWKS is an http(s) URL or a path to a local JWKS file.
+	JWKS      string
+	Issuer    string
+	Audience  string
+	UserClaim string
+	RoleClaim string
+	CacheTTL  time.Duration
+	// MinRefresh is the least time between two fetches of the key set, which
+	// bounds both fetches forced by unknown key IDs and retries after a
+	// failed refresh.
+	MinRefresh time.Duration
+	Leeway     time.Duration
+	Client     *http.Client
+}
+
+// JWT authenticates RS256 and ES256 tokens issued by an SSO provider. The key
+// set is cached for CacheTTL and refetched early when a token names a key ID
+// it has not seen, which is how rotated keys are picked up.
+type JWT struct {
+	store  storage.Storage
+	config JWTConfig
+
+	mu          sync.Mutex
+	keys        map[string]crypto.PublicKey
+	fetchedAt   time.Time
+	attemptedAt time.Time
+	lastErr     error
+	// refreshing is closed when the fetch in flight completes.
+	refreshing chan struct{}
+}
+
+func NewJWT(store storage.Storage, config JWTConfig) (*JWT, error) {
+	if config.JWKS == "" {
+		return nil, errors.New("a JWKS URL or file is required")
+	}
+	if config.UserClaim == "" {
+		config.UserClaim = "sub"
+	}
+	if config.RoleClaim == "" {
+		config.RoleClaim = "role"
+	}
+	if config.CacheTTL <= 0 {
+		config.CacheTTL = 5 * time.Minute
+	}
+	if config.MinRefresh <= 0 {
+		config.MinRefresh = 10 * time.Second
+	}
+	if config.Leeway <= 0 {
+		config.Leeway = 30 * time.Second
+	}
+	if config.Client == nil {
+		config.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return &JWT{store: store, config: config}, nil
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+func (j *JWT) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	claims, err := j.verify(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	userID, _ := claims[j.config.UserClaim].(string)
+	if userID == "" {
+		return nil, fmt.Errorf("%w: token has no %s claim", ErrUnauthenticated, j.config.UserClaim)
+	}
+
+	user, err := j.store.GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, fmt.Errorf("%w: unknown user %s", ErrUnauthenticated, userID)
+	}
+
+	principal := &Principal{Subject: "user:" + userID, Role: models.RoleMember, UserID: userID}
+	switch role, _ := claims[j.config.RoleClaim].(string); role {
+	case models.RoleAdmin, models.RoleReadOnly:
+		principal.Role = role
+	case models.RoleTeamLead:
+		principal.Role = role
+		principal.TeamName = user.TeamName
+	}
+	return principal, nil
+}
+
+func (j *JWT) verify(ctx context.Context, token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrUnauthenticated)
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: malformed header", ErrUnauthenticated)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", ErrUnauthenticated)
+	}
+
+	key, err := j.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if !verifySignature(header.Alg, key, digest[:], signature) {
+		return nil, fmt.Errorf("%w: invalid signature", ErrUnauthenticated)
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: malformed claims", ErrUnauthenticated)
+	}
+	if err := j.checkClaims(claims); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+	return claims, nil
+}
+
+// The algorithm must agree with the key type, so an RSA key can never be
+// used to check an HMAC or "none" token.
+func verifySignature(alg string, key crypto.PublicKey, digest []byte, signature []byte) bool {
+	switch alg {
+	case "RS256":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest, signature) == nil
+	case "ES256":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || ecKey.Curve != elliptic.P256() || len(signature) != 64 {
+			return false
+		}
+		r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(ecKey, digest, r, s)
+	}
+	return false
+}
+
+func (j *JWT) checkClaims(claims map[string]interface{}) error {
+	now := time.Now()
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return errors.New("token has no expiry")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(j.config.Leeway)) {
+		return errors.New("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(j.config.Leeway).Before(time.Unix(int64(nbf), 0)) {
+		return errors.New("token not yet valid")
+	}
+
+	if j.config.Issuer != "" && claims["iss"] != j.config.Issuer {
+		return errors.New("unexpected issuer")
+	}
+	if j.config.Audience != "" && !hasAudience(claims["aud"], j.config.Audience) {
+		return errors.New("unexpected audience")
+	}
+	return nil
+}
+
+func hasAudience(claim interface{}, audience string) bool {
+	switch aud := claim.(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, value := range aud {
+			if value == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// key returns the key for kid, refreshing the key set when it is stale or
+// lacks kid. At most one fetch runs at a time and never under j.mu; other
+// requests keep using the cached keys meanwhile, and after a failed fetch the
+// next one waits MinRefresh, so an unreachable provider costs one slow request
+// rather than stalling every request behind it.
+func (j *JWT) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	for {
+		j.mu.Lock()
+		key, known := j.lookupLocked(kid)
+		if known && time.Since(j.fetchedAt) <= j.config.CacheTTL {
+			j.mu.Unlock()
+			return key, nil
+		}
+
+		if wait := j.refreshing; wait != nil {
+			j.mu.Unlock()
+			if known {
+				return key, nil
+			}
+			select {
+			case <-wait:
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		if time.Since(j.attemptedAt) < j.config.MinRefresh {
+			defer j.mu.Unlock()
+			return j.resultLocked(key, known, kid)
+		}
+
+		done := make(chan struct{})
+		j.refreshing, j.attemptedAt = done, time.Now()
+		j.mu.Unlock()
+
+		// One abandoned request must not fail the refresh others rely on.
+		keys, err := j.fetch(context.WithoutCancel(ctx))
+
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		j.refreshing = nil
+		close(done)
+
+		if err != nil {
+			j.lastErr = err
+			if j.keys != nil {
+				log.Printf("JWKS refresh failed, using cached keys: %v", err)
+			}
+		} else {
+			j.keys, j.fetchedAt, j.lastErr = keys, time.Now(), nil
+		}
+
+		key, known = j.lookupLocked(kid)
+		return j.resultLocked(key, known, kid)
+	}
+}
+
+func (j *JWT) resultLocked(key crypto.PublicKey, known bool, kid string) (crypto.PublicKey, error) {
+	switch {
+	case known:
+		return key, nil
+	case j.keys == nil && j.lastErr != nil:
+		return nil, j.lastErr
+	}
+	return nil, fmt.Errorf("%w: unknown signing key %q", ErrUnauthenticated, kid)
+}
+
+// A token without a key ID is accepted only while the set has a single key.
+func (j *JWT) lookupLocked(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(j.keys) == 1 {
+		for _, key := range j.keys {
+			return key, true
+		}
+	}
+	key, ok := j.keys[kid]
+	return key, ok
+}
+
+func (j *JWT) fetch(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	var body []byte
+	var err error
+	if strings.HasPrefix(j.config.JWKS, "http://") || strings.HasPrefix(j.config.JWKS, "https://") {
+		body, err = j.download(ctx)
+	} else {
+		body, err = os.ReadFile(j.config.JWKS)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load JWKS: %w", err)
+	}
+
+	return ParseJWKS(body)
+}
+
+func (j *JWT) download(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", j.config.JWKS, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := j.config.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS endpoint responded %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// ParseJWKS reads the RSA and P-256 signing keys of a key set and skips
+// everything else.
+func ParseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("parse JWKS: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, key := range set.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+
+		switch key.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(key.N)
+			e, errE := base64.RawURLEncoding.DecodeString(key.E)
+			if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+				return nil, fmt.Errorf("parse JWKS: invalid RSA key %q", key.Kid)
+			}
+			keys[key.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			if key.Crv != "P-256" {
+				continue
+			}
+			x, errX := base64.RawURLEncoding.DecodeString(key.X)
+			y, errY := base64.RawURLEncoding.DecodeString(key.Y)
+			if errX != nil || errY != nil || len(x) != 32 || len(y) != 32 {
+				return nil, fmt.Errorf("parse JWKS: invalid EC key %q", key.Kid)
+			}
+			if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+				return nil, fmt.Errorf("parse JWKS: EC key %q is not on P-256", key.Kid)
+			}
+			keys[key.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("parse JWKS: no usable signing keys")
+	}
+	return keys, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,217 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Chamistery/Test_task/internal/models"
+	"github.com/Chamistery/Test_task/internal/storage"
+)
+
+func IsKnownEventType(name string) bool {
+	switch name {
+	case models.EventPRCreated, models.EventReviewerAssigned, models.EventReviewerReplaced,
+		models.EventPRMerged, models.EventUserDeactivated, models.EventTeamDeactivated, models.EventReviewSubmitted:
+		return true
+	}
+	return false
+}
+
+// A Sink receives every domain event at least once. Deliveries are retried
+// after failures and crashes, so sinks should deduplicate by event ID.
+type Sink interface {
+	Name() string
+	Deliver(ctx context.Context, event models.DomainEvent) error
+}
+
+type SinkFunc struct {
+	SinkName string
+	Fn       func(ctx context.Context, event models.DomainEvent) error
+}
+
+func (f SinkFunc) Name() string {
+	return f.SinkName
+}
+
+func (f SinkFunc) Deliver(ctx context.Context, event models.DomainEvent) error {
+	return f.Fn(ctx, event)
+}
+
+type LogSink struct {
+	Logger *log.Logger
+}
+
+func (LogSink) Name() string {
+	return "log"
+}
+
+func (s LogSink) Deliver(ctx context.Context, event models.DomainEvent) error {
+	logger := s.Logger
+	if logger == nil {
+		logger = log.Default()
+	}
+	logger.Printf("event %d %s %s: %s", event.ID, event.Type, event.AggregateID, event.Payload)
+	return nil
+}
+
+type DispatcherConfig struct {
+	PollInterval time.Duration
+	BatchSize    int
+	MaxAttempts  int
+	BaseBackoff  time.Duration
+	MaxBackoff   time.Duration
+	// Lease reserves a claimed batch for this dispatcher; other replicas
+	// take the events over only if it is not delivered by then.
+	Lease time.Duration
+}
+
+type Dispatcher struct {
+	store  storage.Storage
+	config DispatcherConfig
+
+	mu    sync.Mutex
+	sinks []Sink
+}
+
+func NewDispatcher(store storage.Storage, config DispatcherConfig) *Dispatcher {
+	if config.PollInterval <= 0 {
+		config.PollInterval = time.Second
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = storage.DefaultOutboxBatch
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 8
+	}
+	if config.BaseBackoff <= 0 {
+		config.BaseBackoff = time.Second
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = 10 * time.Minute
+	}
+	if config.Lease <= 0 {
+		config.Lease = time.Minute
+	}
+
+	return &Dispatcher{
+		store:  store,
+		config: config,
+	}
+}
+
+func (d *Dispatcher) Register(sink Sink) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.sinks = append(d.sinks, sink)
+}
+
+// Run polls the outbox until ctx is cancelled.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			delivered, err := d.DispatchPending(ctx)
+			if err != nil {
+				log.Printf("Outbox dispatch failed: %v", err)
+			}
+			if err != nil || delivered < d.config.BatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DispatchPending delivers one batch of due events in order and returns how
+// many were delivered. A failed event holds back the later events of its
+// aggregate, so those never overtake it, but not the events of other
+// aggregates. Failed events are retried with backoff and given up after
+// MaxAttempts; their errors are returned once the batch is done. Without
+// registered sinks events stay pending.
+func (d *Dispatcher) DispatchPending(ctx context.Context) (int, error) {
+	d.mu.Lock()
+	sinks := append([]Sink{}, d.sinks...)
+	d.mu.Unlock()
+
+	if len(sinks) == 0 {
+		return 0, nil
+	}
+
+	due, err := d.store.ClaimDueEvents(ctx, time.Now(), d.config.Lease, d.config.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	var failures []error
+	held := make(map[string]bool)
+	var released []int64
+	for _, event := range due {
+		if held[event.AggregateID] {
+			released = append(released, event.ID)
+			continue
+		}
+
+		if err := deliver(ctx, sinks, event); err != nil {
+			if markErr := d.fail(ctx, event, err); markErr != nil {
+				return delivered, markErr
+			}
+			failures = append(failures, err)
+			held[event.AggregateID] = true
+			continue
+		}
+
+		if err := d.store.MarkEventDelivered(ctx, event.ID); err != nil {
+			return delivered, err
+		}
+		delivered++
+	}
+
+	// Events held behind a failure go back to the queue, so they are retried
+	// right after it instead of when the lease runs out.
+	if err := d.store.ReleaseEvents(ctx, released); err != nil {
+		return delivered, err
+	}
+	return delivered, errors.Join(failures...)
+}
+
+func (d *Dispatcher) fail(ctx context.Context, event models.DomainEvent, err error) error {
+	attempts := event.Attempts + 1
+	if attempts >= d.config.MaxAttempts {
+		log.Printf("Outbox event %d %s given up after %d attempts: %v", event.ID, event.Type, attempts, err)
+		return d.store.MarkEventFailed(ctx, event.ID, err.Error(), nil)
+	}
+
+	next := time.Now().Add(d.backoff(attempts))
+	return d.store.MarkEventFailed(ctx, event.ID, err.Error(), &next)
+}
+
+// backoff doubles the delay after every failed attempt, up to MaxBackoff.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.config.BaseBackoff
+	for i := 1; i < attempts && delay < d.config.MaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, d.config.MaxBackoff)
+}
+
+func deliver(ctx context.Context, sinks []Sink, event models.DomainEvent) error {
+	for _, sink := range sinks {
+		if err := sink.Deliver(ctx, event); err != nil {
+			return fmt.Errorf("sink %s: event %d: %w", sink.Name(), event.ID, err)
+		}
+	}
+	return nil
+}
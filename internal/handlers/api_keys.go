@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/Chamistery/Test_task/internal/auth"
+	"github.com/Chamistery/Test_task/internal/models"
+)
+
+func (h *Handlers) HandleAPIKeyCreate(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
+		return
+	}
+
+	if err := auth.ValidateKeyRequest(req); err != nil {
+		h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
+		return
+	}
+
+	if req.TeamName != "" {
+		exists, err := h.storage.TeamExists(r.Context(), req.TeamName)
+		if err != nil {
+			h.respondInternalError(w, err)
+			return
+		}
+		if !exists {
+			h.respondError(w, http.StatusNotFound, models.ErrNotFound, "team not found")
+			return
+		}
+	}
+
+	created, err := auth.NewAPIKeys(h.storage).Issue(r.Context(), req)
+	if err != nil {
+		h.respondInternalError(w, err)
+		return
+	}
+
+	h.respondJSON(w, http.StatusCreated, created)
+}
+
+func (h *Handlers) HandleAPIKeyList(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.storage.GetAPIKeys(r.Context())
+	if err != nil {
+		h.respondInternalError(w, err)
+		return
+	}
+
+	h.respondJSON(w, http.StatusOK, models.APIKeyListResponse{Keys: keys})
+}
+
+func (h *Handlers) HandleAPIKeyRevoke(w http.ResponseWriter, r *http.Request) {
+	var req models.RevokeAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
+		return
+	}
+
+	err := h.storage.RevokeAPIKey(r.Context(), req.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		h.respondError(w, http.StatusNotFound, models.ErrNotFound, "active api key not found")
+		return
+	}
+	if err != nil {
+		h.respondInternalError(w, err)
+		return
+	}
+
+	h.respondJSON(w, http.StatusOK, map[string]interface{}{
+		"id": req.ID,
+	})
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Chamistery/Test_task/internal/models"
+	"github.com/Chamistery/Test_task/internal/storage"
+)
+
+const (
+	actorHeader       = "X-Actor"
+	auditReasonHeader = "X-Audit-Reason"
+)
+
+// AuditContext attributes storage mutations made while serving a request to
+// the caller named in X-Actor, with an optional X-Audit-Reason.
+func AuditContext(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if actor := r.Header.Get(actorHeader); actor != "" {
+			ctx = storage.WithAuditActor(ctx, actor)
+		}
+		if reason := r.Header.Get(auditReasonHeader); reason != "" {
+			ctx = storage.WithAuditReason(ctx, reason)
+		}
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (h *Handlers) HandleAuditGet(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := models.AuditFilter{
+		PullRequestID: query.Get("pull_request_id"),
+		UserID:        query.Get("user_id"),
+		TeamName:      query.Get("team_name"),
+	}
+
+	var err error
+	if filter.Since, err = parseTimeParam(query, "from"); err != nil {
+		h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", "from must be an RFC 3339 timestamp")
+		return
+	}
+	if filter.Until, err = parseTimeParam(query, "to"); err != nil {
+		h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", "to must be an RFC 3339 timestamp")
+		return
+	}
+
+	if value := query.Get("limit"); value != "" {
+		filter.Limit, err = strconv.Atoi(value)
+		if err != nil || filter.Limit < 1 || filter.Limit > models.MaxAuditLimit {
+			h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", "limit must be between 1 and "+strconv.Itoa(models.MaxAuditLimit))
+			return
+		}
+	}
+
+	if value := query.Get("after_id"); value != "" {
+		filter.AfterID, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || filter.AfterID < 0 {
+			h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", "after_id must be a non-negative integer")
+			return
+		}
+	}
+
+	events, err := h.storage.GetAuditEvents(r.Context(), filter)
+	if err != nil {
+		h.respondInternalError(w, err)
+		return
+	}
+
+	h.respondJSON(w, http.StatusOK, models.AuditResponse{Events: events})
+}
+
+func parseTimeParam(query url.Values, name string) (*time.Time, error) {
+	value := query.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+
+	parsed, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return nil, err
+	}
+	return &parsed, nil
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/Chamistery/Test_task/internal/auth"
+	"github.com/Chamistery/Test_task/internal/models"
+	"github.com/Chamistery/Test_task/internal/storage"
+)
+
+// WithAuthenticator turns on bearer authentication in Authenticate. Without it
+// every request is served anonymously.
+func WithAuthenticator(authenticator auth.Authenticator) Option {
+	return func(h *Handlers) {
+		h.authenticator = authenticator
+	}
+}
+
+// Authenticate resolves the Authorization: Bearer credential into a principal,
+// enforces the route's access level and attributes audit events to the
+// principal instead of the self-declared X-Actor.
+func (h *Handlers) Authenticate(access auth.Access, next http.Handler) http.Handler {
+	if h.authenticator == nil || access == auth.AccessPublic {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !strings.EqualFold(scheme, "Bearer") || token == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			h.respondError(w, http.StatusUnauthorized, models.ErrUnauthorized, "bearer token required")
+			return
+		}
+
+		principal, err := h.authenticator.Authenticate(r.Context(), strings.TrimSpace(token))
+		if errors.Is(err, auth.ErrUnauthenticated) {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			h.respondError(w, http.StatusUnauthorized, models.ErrUnauthorized, err.Error())
+			return
+		}
+		if err != nil {
+			h.respondInternalError(w, err)
+			return
+		}
+
+		if !principal.Allows(access) {
+			h.respondError(w, http.StatusForbidden, models.ErrForbidden, "role "+principal.Role+" may not call "+r.URL.Path)
+			return
+		}
+
+		ctx := auth.WithPrincipal(r.Context(), principal)
+		ctx = storage.WithAuditActor(ctx, principal.Subject)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// authorizeAdmin guards admin-only options of routes other roles may call.
+func (h *Handlers) authorizeAdmin(w http.ResponseWriter, r *http.Request, message string) bool {
+	principal := auth.FromContext(r.Context())
+	if principal == nil || principal.Allows(auth.AccessAdmin) {
+		return true
+	}
+
+	h.respondError(w, http.StatusForbidden, models.ErrForbidden, message)
+	return false
+}
+
+// authorizeTeam narrows team-level routes to admins and the team's own lead.
+func (h *Handlers) authorizeTeam(w http.ResponseWriter, r *http.Request, teamName string) bool {
+	principal := auth.FromContext(r.Context())
+	if principal == nil || principal.ManagesTeam(teamName) {
+		return true
+	}
+
+	h.respondError(w, http.StatusForbidden, models.ErrForbidden, "only an admin or the lead of team "+teamName+" may change it")
+	return false
+}
+
+// authorizeMembers keeps non-admins from pulling users of other teams into
+// theirs, which creating a team with their IDs would otherwise do.
+func (h *Handlers) authorizeMembers(w http.ResponseWriter, r *http.Request, teamName string, members []models.TeamMember) bool {
+	principal := auth.FromContext(r.Context())
+	if principal == nil || principal.Allows(auth.AccessAdmin) {
+		return true
+	}
+
+	for _, member := range members {
+		current, err := h.storage.GetUserTeam(r.Context(), member.UserID)
+		if err != nil {
+			h.respondInternalError(w, err)
+			return false
+		}
+		if current != "" && current != teamName {
+			h.respondError(w, http.StatusForbidden, models.ErrForbidden, "user "+member.UserID+" belongs to team "+current+"; only an admin may move users between teams")
+			return false
+		}
+	}
+	return true
+}
+
+func (h *Handlers) authorizeUser(w http.ResponseWriter, r *http.Request, userID string) bool {
+	if auth.FromContext(r.Context()) == nil {
+		return true
+	}
+
+	teamName, err := h.storage.GetUserTeam(r.Context(), userID)
+	if err != nil {
+		h.respondInternalError(w, err)
+		return false
+	}
+	return h.authorizeTeam(w, r, teamName)
+}
+
+// authorizeSelfOrLead lets users change themselves; anyone else has to manage
+// the user's team.
+func (h *Handlers) authorizeSelfOrLead(w http.ResponseWriter, r *http.Request, userID string) bool {
+	principal := auth.FromContext(r.Context())
+	if principal != nil && principal.UserID != "" && principal.UserID == userID {
+		return true
+	}
+	return h.authorizeUser(w, r, userID)
+}
+
+// authorizeReassign lets users hand off only their own reviews unless they
+// lead the reviewer's team. API keys carry no user and keep what their role
+// grants.
+func (h *Handlers) authorizeReassign(w http.ResponseWriter, r *http.Request, oldUserID string) bool {
+	principal := auth.FromContext(r.Context())
+	if principal == nil || principal.UserID == "" {
+		return true
+	}
+	return h.authorizeSelfOrLead(w, r, oldUserID)
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/Chamistery/Test_task/internal/models"
+	"github.com/Chamistery/Test_task/internal/service"
+	"github.com/Chamistery/Test_task/internal/webhooks"
+)
+
+const (
+	githubSignatureHeader = "X-Hub-Signature-256"
+	githubEventHeader     = "X-GitHub-Event"
+	githubDeliveryHeader  = "X-GitHub-Delivery"
+)
+
+type githubAccount struct {
+	Login string `json:"login"`
+}
+
+type githubPullRequestEvent struct {
+	Action      string `json:"action"`
+	PullRequest struct {
+		Number int           `json:"number"`
+		Title  string        `json:"title"`
+		Draft  bool          `json:"draft"`
+		Merged bool          `json:"merged"`
+		User   githubAccount `json:"user"`
+	} `json:"pull_request"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+	Sender githubAccount `json:"sender"`
+}
+
+// HandleGitHubWebhook mirrors GitHub pull requests into this service. PRs are
+// identified as "<owner>/<repo>#<number>" and authors are resolved through
+// accounts linked with /users/linkAccount.
+func (h *Handlers) HandleGitHubWebhook(w http.ResponseWriter, r *http.Request) {
+	body, ok := h.readInbound(w, r, h.githubSecret)
+	if !ok {
+		return
+	}
+
+	if !webhooks.Verify(h.githubSecret, body, r.Header.Get(githubSignatureHeader)) {
+		h.respondError(w, http.StatusUnauthorized, models.ErrUnauthorized, "invalid signature")
+		return
+	}
+
+	if r.Header.Get(githubEventHeader) != "pull_request" {
+		h.respondJSON(w, http.StatusOK, models.InboundWebhookResponse{Status: models.InboundIgnored})
+		return
+	}
+
+	var payload githubPullRequestEvent
+	if err := json.Unmarshal(body, &payload); err != nil {
+		h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
+		return
+	}
+
+	action := githubAction(payload)
+	if action == "" {
+		h.respondJSON(w, http.StatusOK, models.InboundWebhookResponse{Status: models.InboundIgnored})
+		return
+	}
+
+	if payload.Repository.FullName == "" || payload.PullRequest.Number == 0 {
+		h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", "repository and pull request number are required")
+		return
+	}
+
+	h.applyInbound(w, r, payload.Sender.Login, service.InboundPullRequest{
+		Provider:      models.ProviderGitHub,
+		DeliveryID:    r.Header.Get(githubDeliveryHeader),
+		Action:        action,
+		PullRequestID: fmt.Sprintf("%s#%d", payload.Repository.FullName, payload.PullRequest.Number),
+		Title:         payload.PullRequest.Title,
+		AuthorLogin:   payload.PullRequest.User.Login,
+		IsDraft:       payload.PullRequest.Draft,
+	})
+}
+
+func githubAction(payload githubPullRequestEvent) string {
+	switch payload.Action {
+	case "opened":
+		return service.InboundOpened
+	case "reopened":
+		return service.InboundReopened
+	case "ready_for_review":
+		return service.InboundReady
+	case "closed":
+		if payload.PullRequest.Merged {
+			return service.InboundMerged
+		}
+		return service.InboundClosed
+	}
+	return ""
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/Chamistery/Test_task/internal/models"
+	"github.com/Chamistery/Test_task/internal/service"
+)
+
+const (
+	gitlabTokenHeader = "X-Gitlab-Token"
+	gitlabEventHeader = "X-Gitlab-Event"
+	// Idempotency-Key stays the same across GitLab's retries; older versions
+	// only send the per-event UUID.
+	gitlabIdempotencyHeader = "Idempotency-Key"
+	gitlabEventUUIDHeader   = "X-Gitlab-Event-UUID"
+)
+
+type gitlabMergeRequestEvent struct {
+	ObjectKind string `json:"object_kind"`
+	User       struct {
+		ID       int64  `json:"id"`
+		Username string `json:"username"`
+	} `json:"user"`
+	Project struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+	} `json:"project"`
+	ObjectAttributes struct {
+		IID            int    `json:"iid"`
+		AuthorID       int64  `json:"author_id"`
+		Title          string `json:"title"`
+		Action         string `json:"action"`
+		Draft          bool   `json:"draft"`
+		WorkInProgress bool   `json:"work_in_progress"`
+	} `json:"object_attributes"`
+}
+
+// HandleGitLabWebhook mirrors GitLab merge requests into this service. Merge
+// requests are identified as "<group>/<project>!<iid>". GitLab only sends the
+// author's numeric ID, so the author is known by username only when they
+// triggered the event themselves; a merge request first seen through someone
+// else's event is ignored rather than attributed to that user.
+func (h *Handlers) HandleGitLabWebhook(w http.ResponseWriter, r *http.Request) {
+	body, ok := h.readInbound(w, r, h.gitlabToken)
+	if !ok {
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get(gitlabTokenHeader)), []byte(h.gitlabToken)) != 1 {
+		h.respondError(w, http.StatusUnauthorized, models.ErrUnauthorized, "invalid token")
+		return
+	}
+
+	if r.Header.Get(gitlabEventHeader) != "Merge Request Hook" {
+		h.respondJSON(w, http.StatusOK, models.InboundWebhookResponse{Status: models.InboundIgnored})
+		return
+	}
+
+	var payload gitlabMergeRequestEvent
+	if err := json.Unmarshal(body, &payload); err != nil {
+		h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
+		return
+	}
+
+	action := gitlabAction(payload)
+	if action == "" {
+		h.respondJSON(w, http.StatusOK, models.InboundWebhookResponse{Status: models.InboundIgnored})
+		return
+	}
+
+	if payload.Project.PathWithNamespace == "" || payload.ObjectAttributes.IID == 0 {
+		h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", "project and merge request iid are required")
+		return
+	}
+
+	deliveryID := r.Header.Get(gitlabIdempotencyHeader)
+	if deliveryID == "" {
+		deliveryID = r.Header.Get(gitlabEventUUIDHeader)
+	}
+
+	var author string
+	if payload.User.ID != 0 && payload.User.ID == payload.ObjectAttributes.AuthorID {
+		author = payload.User.Username
+	}
+
+	h.applyInbound(w, r, payload.User.Username, service.InboundPullRequest{
+		Provider:      models.ProviderGitLab,
+		DeliveryID:    deliveryID,
+		Action:        action,
+		PullRequestID: fmt.Sprintf("%s!%d", payload.Project.PathWithNamespace, payload.ObjectAttributes.IID),
+		Title:         payload.ObjectAttributes.Title,
+		AuthorLogin:   author,
+		IsDraft:       payload.ObjectAttributes.Draft || payload.ObjectAttributes.WorkInProgress,
+	})
+}
+
+// An update that leaves the merge request out of draft marks it ready; any
+// other update only makes sure it exists.
+func gitlabAction(payload gitlabMergeRequestEvent) string {
+	attributes := payload.ObjectAttributes
+	switch attributes.Action {
+	case "open":
+		return service.InboundOpened
+	case "reopen":
+		return service.InboundReopened
+	case "update":
+		if attributes.Draft || attributes.WorkInProgress {
+			return service.InboundOpened
+		}
+		return service.InboundReady
+	case "merge":
+		return service.InboundMerged
+	case "close":
+		return service.InboundClosed
+	}
+	return ""
+}
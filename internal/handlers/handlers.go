@@ -1,9 +1,13 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"time"
 
+	"github.com/Chamistery/Test_task/internal/auth"
 	"github.com/Chamistery/Test_task/internal/models"
 	"github.com/Chamistery/Test_task/internal/service"
 	"github.com/Chamistery/Test_task/internal/storage"
@@ -12,13 +16,39 @@ import (
 type Handlers struct {
 	storage storage.Storage
 	service *service.ReviewerService
+
+	githubSecret  string
+	gitlabToken   string
+	authenticator auth.Authenticator
+}
+
+type Option func(*Handlers)
+
+// WithGitHubSecret sets the secret /webhooks/github verifies signatures with.
+// Without it the endpoint rejects every delivery.
+func WithGitHubSecret(secret string) Option {
+	return func(h *Handlers) {
+		h.githubSecret = secret
+	}
 }
 
-func NewHandlers(storage storage.Storage) *Handlers {
-	return &Handlers{
+// WithGitLabToken sets the secret token /webhooks/gitlab expects in
+// X-Gitlab-Token. Without it the endpoint rejects every delivery.
+func WithGitLabToken(token string) Option {
+	return func(h *Handlers) {
+		h.gitlabToken = token
+	}
+}
+
+func NewHandlers(storage storage.Storage, opts ...Option) *Handlers {
+	h := &Handlers{
 		storage: storage,
 		service: service.NewReviewerService(storage),
 	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
 }
 
 func (h *Handlers) respondJSON(w http.ResponseWriter, status int, data interface{}) {
@@ -35,3 +65,32 @@ func (h *Handlers) respondError(w http.ResponseWriter, status int, code, message
 		},
 	})
 }
+
+// Non-standard status popularised by nginx for requests the client abandoned.
+const statusClientClosedRequest = 499
+
+func (h *Handlers) respondInternalError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		h.respondError(w, http.StatusGatewayTimeout, models.ErrTimeout, "request timed out")
+	case errors.Is(err, context.Canceled):
+		h.respondError(w, statusClientClosedRequest, models.ErrCancelled, "request cancelled")
+	case errors.Is(err, storage.ErrConflict):
+		h.respondError(w, http.StatusConflict, models.ErrConflict, "concurrent update, retry the request")
+	default:
+		h.respondError(w, http.StatusInternalServerError, models.ErrInternal, err.Error())
+	}
+}
+
+func RequestTimeout(timeout time.Duration, next http.Handler) http.Handler {
+	if timeout <= 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/Chamistery/Test_task/internal/models"
+	"github.com/Chamistery/Test_task/internal/service"
+	"github.com/Chamistery/Test_task/internal/storage"
+)
+
+// readInbound returns the raw body of a code host webhook, which has to be
+// read whole before its signature can be checked. The router caps its size.
+func (h *Handlers) readInbound(w http.ResponseWriter, r *http.Request, secret string) ([]byte, bool) {
+	if secret == "" {
+		h.respondError(w, http.StatusServiceUnavailable, "NOT_CONFIGURED", "webhook secret is not configured")
+		return nil, false
+	}
+
+	body, err := io.ReadAll(r.Body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		h.respondError(w, http.StatusRequestEntityTooLarge, models.ErrTooLarge, err.Error())
+		return nil, false
+	}
+	if err != nil {
+		h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
+		return nil, false
+	}
+
+	return body, true
+}
+
+func (h *Handlers) applyInbound(w http.ResponseWriter, r *http.Request, actor string, event service.InboundPullRequest) {
+	if event.DeliveryID == "" {
+		h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", "delivery id header is required")
+		return
+	}
+
+	ctx := storage.WithAuditActor(r.Context(), event.Provider+":"+actor)
+	pr, err := h.service.ApplyInbound(ctx, event)
+	switch {
+	case errors.Is(err, service.ErrDuplicateDelivery):
+		h.respondJSON(w, http.StatusOK, models.InboundWebhookResponse{Status: models.InboundDuplicate})
+	case errors.Is(err, service.ErrUnmappedUser):
+		h.respondError(w, http.StatusUnprocessableEntity, models.ErrUnmappedUser, event.Provider+" user "+event.AuthorLogin+" is not linked to a user")
+	case errors.Is(err, service.ErrAuthorNotFound):
+		h.respondError(w, http.StatusUnprocessableEntity, models.ErrUnmappedUser, "linked author not found")
+	case errors.Is(err, storage.ErrPRExists):
+		h.respondError(w, http.StatusConflict, models.ErrPRExists, "PR was created concurrently; retry the delivery")
+	case err != nil:
+		h.respondInternalError(w, err)
+	case pr == nil:
+		h.respondJSON(w, http.StatusOK, models.InboundWebhookResponse{Status: models.InboundIgnored})
+	default:
+		h.respondJSON(w, http.StatusOK, models.InboundWebhookResponse{Status: models.InboundApplied, PR: pr})
+	}
+}
@@ -2,163 +2,287 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
 	"github.com/Chamistery/Test_task/internal/models"
+	"github.com/Chamistery/Test_task/internal/service"
+	"github.com/Chamistery/Test_task/internal/storage"
 )
 
 func (h *Handlers) HandlePullRequestCreate(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		h.respondError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "method not allowed")
-		return
-	}
-
 	var req models.CreatePRRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
 		return
 	}
 
-	exists, err := h.storage.PRExists(req.PullRequestID)
-	if err != nil {
-		h.respondError(w, http.StatusInternalServerError, "INTERNAL_ERROR", err.Error())
-		return
-	}
-	if exists {
-		h.respondError(w, http.StatusConflict, models.ErrPRExists, "PR id already exists")
-		return
+	reviewersCount := 0
+	if req.ReviewersCount != nil {
+		if *req.ReviewersCount < 1 {
+			h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", "reviewers_count must be positive")
+			return
+		}
+		reviewersCount = min(*req.ReviewersCount, models.MaxReviewersRequired)
 	}
 
-	author, err := h.storage.GetUser(req.AuthorID)
-	if err != nil {
-		h.respondError(w, http.StatusInternalServerError, "INTERNAL_ERROR", err.Error())
+	createdPR, shortfall, err := h.service.CreatePullRequest(r.Context(), &models.PullRequest{
+		PullRequestID:     req.PullRequestID,
+		PullRequestName:   req.PullRequestName,
+		AuthorID:          req.AuthorID,
+		IsDraft:           req.IsDraft,
+		ReviewersRequired: reviewersCount,
+	})
+	if errors.Is(err, storage.ErrPRExists) {
+		h.respondError(w, http.StatusConflict, models.ErrPRExists, "PR id already exists")
 		return
 	}
-	if author == nil {
+	if errors.Is(err, service.ErrAuthorNotFound) {
 		h.respondError(w, http.StatusNotFound, models.ErrNotFound, "author not found")
 		return
 	}
-
-	reviewers, err := h.service.AssignReviewers(req.AuthorID)
 	if err != nil {
-		h.respondError(w, http.StatusInternalServerError, "INTERNAL_ERROR", err.Error())
+		h.respondInternalError(w, err)
 		return
 	}
 
-	pr := &models.PullRequest{
-		PullRequestID:     req.PullRequestID,
-		PullRequestName:   req.PullRequestName,
-		AuthorID:          req.AuthorID,
-		AssignedReviewers: reviewers,
+	h.respondJSON(w, http.StatusCreated, models.CreatePRResponse{
+		PR:        createdPR,
+		Shortfall: shortfall,
+	})
+}
+
+func (h *Handlers) HandlePullRequestMerge(w http.ResponseWriter, r *http.Request) {
+	var req models.MergePRRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
+		return
 	}
 
-	if err := h.storage.CreatePullRequest(pr); err != nil {
-		h.respondError(w, http.StatusInternalServerError, "INTERNAL_ERROR", err.Error())
+	if req.Force && !h.authorizeAdmin(w, r, "only an admin may force a merge") {
 		return
 	}
 
-	createdPR, err := h.storage.GetPullRequest(pr.PullRequestID)
+	pr, err := h.storage.MergePullRequest(r.Context(), req.PullRequestID, req.Force)
+	if errors.Is(err, storage.ErrNotApproved) {
+		h.respondError(w, http.StatusConflict, models.ErrNotApproved, err.Error())
+		return
+	}
+	if errors.Is(err, storage.ErrPRClosed) {
+		h.respondError(w, http.StatusConflict, models.ErrPRClosed, "cannot merge closed PR")
+		return
+	}
+	if errors.Is(err, storage.ErrPRDraft) {
+		h.respondError(w, http.StatusConflict, models.ErrPRDraft, "cannot merge draft PR; mark it ready first")
+		return
+	}
 	if err != nil {
-		h.respondError(w, http.StatusInternalServerError, "INTERNAL_ERROR", err.Error())
+		h.respondInternalError(w, err)
+		return
+	}
+
+	if pr == nil {
+		h.respondError(w, http.StatusNotFound, models.ErrNotFound, "pull request not found")
 		return
 	}
 
-	h.respondJSON(w, http.StatusCreated, map[string]interface{}{
-		"pr": createdPR,
+	h.respondJSON(w, http.StatusOK, map[string]interface{}{
+		"pr": pr,
 	})
 }
 
-func (h *Handlers) HandlePullRequestMerge(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		h.respondError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "method not allowed")
+func (h *Handlers) HandlePullRequestReassign(w http.ResponseWriter, r *http.Request) {
+	var req models.ReassignRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
 		return
 	}
 
-	var req models.MergePRRequest
+	if !h.authorizeReassign(w, r, req.OldUserID) {
+		return
+	}
+
+	updatedPR, newReviewerID, err := h.service.ReassignReviewer(r.Context(), req.PullRequestID, req.OldUserID)
+	switch {
+	case errors.Is(err, service.ErrPRNotFound):
+		h.respondError(w, http.StatusNotFound, models.ErrNotFound, "pull request not found")
+		return
+	case errors.Is(err, storage.ErrPRMerged):
+		h.respondError(w, http.StatusConflict, models.ErrPRMerged, "cannot reassign on merged PR")
+		return
+	case errors.Is(err, storage.ErrPRClosed):
+		h.respondError(w, http.StatusConflict, models.ErrPRClosed, "cannot reassign on closed PR")
+		return
+	case errors.Is(err, storage.ErrNotAssigned):
+		h.respondError(w, http.StatusConflict, models.ErrNotAssigned, "reviewer is not assigned to this PR")
+		return
+	case errors.Is(err, service.ErrNoCandidate):
+		h.respondError(w, http.StatusConflict, models.ErrNoCandidate, "no active replacement candidate in team")
+		return
+	case err != nil:
+		h.respondInternalError(w, err)
+		return
+	}
+
+	response := models.ReassignResponse{
+		PR:         *updatedPR,
+		ReplacedBy: newReviewerID,
+	}
+
+	h.respondJSON(w, http.StatusOK, response)
+}
+
+func (h *Handlers) HandlePullRequestReview(w http.ResponseWriter, r *http.Request) {
+	var req models.SubmitReviewRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
 		return
 	}
 
-	pr, err := h.storage.MergePullRequest(req.PullRequestID)
-	if err != nil {
-		h.respondError(w, http.StatusInternalServerError, "INTERNAL_ERROR", err.Error())
+	switch req.State {
+	case models.ReviewApproved, models.ReviewChangesRequested, models.ReviewCommented:
+	default:
+		h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", "state must be APPROVED, CHANGES_REQUESTED or COMMENTED")
 		return
 	}
 
-	if pr == nil {
+	// Approvals gate merges, so reviews are submitted by the reviewer
+	// themselves unless the caller leads the reviewer's team.
+	if !h.authorizeSelfOrLead(w, r, req.UserID) {
+		return
+	}
+
+	updatedPR, err := h.service.SubmitReview(r.Context(), req.PullRequestID, req.UserID, req.State)
+	switch {
+	case errors.Is(err, service.ErrPRNotFound):
 		h.respondError(w, http.StatusNotFound, models.ErrNotFound, "pull request not found")
 		return
+	case errors.Is(err, storage.ErrPRMerged):
+		h.respondError(w, http.StatusConflict, models.ErrPRMerged, "cannot review merged PR")
+		return
+	case errors.Is(err, storage.ErrPRClosed):
+		h.respondError(w, http.StatusConflict, models.ErrPRClosed, "cannot review closed PR")
+		return
+	case errors.Is(err, storage.ErrNotAssigned):
+		h.respondError(w, http.StatusConflict, models.ErrNotAssigned, "reviewer is not assigned to this PR")
+		return
+	case err != nil:
+		h.respondInternalError(w, err)
+		return
 	}
 
 	h.respondJSON(w, http.StatusOK, map[string]interface{}{
-		"pr": pr,
+		"pr": updatedPR,
 	})
 }
 
-func (h *Handlers) HandlePullRequestReassign(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		h.respondError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "method not allowed")
-		return
-	}
-
-	var req models.ReassignRequest
+func (h *Handlers) HandlePullRequestClose(w http.ResponseWriter, r *http.Request) {
+	var req models.ClosePRRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
 		return
 	}
 
-	pr, err := h.storage.GetPullRequest(req.PullRequestID)
+	pr, err := h.storage.ClosePullRequest(r.Context(), req.PullRequestID)
+	if errors.Is(err, storage.ErrPRMerged) {
+		h.respondError(w, http.StatusConflict, models.ErrPRMerged, "cannot close merged PR")
+		return
+	}
 	if err != nil {
-		h.respondError(w, http.StatusInternalServerError, "INTERNAL_ERROR", err.Error())
+		h.respondInternalError(w, err)
 		return
 	}
+
 	if pr == nil {
 		h.respondError(w, http.StatusNotFound, models.ErrNotFound, "pull request not found")
 		return
 	}
 
-	if pr.Status == "MERGED" {
-		h.respondError(w, http.StatusConflict, models.ErrPRMerged, "cannot reassign on merged PR")
+	h.respondJSON(w, http.StatusOK, map[string]interface{}{
+		"pr": pr,
+	})
+}
+
+func (h *Handlers) HandlePullRequestReopen(w http.ResponseWriter, r *http.Request) {
+	var req models.ReopenPRRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
 		return
 	}
 
-	isAssigned, err := h.storage.IsReviewerAssigned(req.PullRequestID, req.OldUserID)
-	if err != nil {
-		h.respondError(w, http.StatusInternalServerError, "INTERNAL_ERROR", err.Error())
+	pr, shortfall, err := h.service.ReopenPullRequest(r.Context(), req.PullRequestID)
+	switch {
+	case errors.Is(err, service.ErrPRNotFound):
+		h.respondError(w, http.StatusNotFound, models.ErrNotFound, "pull request not found")
 		return
-	}
-	if !isAssigned {
-		h.respondError(w, http.StatusConflict, models.ErrNotAssigned, "reviewer is not assigned to this PR")
+	case errors.Is(err, storage.ErrPRMerged):
+		h.respondError(w, http.StatusConflict, models.ErrPRMerged, "cannot reopen merged PR")
+		return
+	case err != nil:
+		h.respondInternalError(w, err)
 		return
 	}
 
-	newReviewerID, err := h.service.FindReplacementReviewer(req.PullRequestID, req.OldUserID)
-	if err != nil {
-		h.respondError(w, http.StatusInternalServerError, "INTERNAL_ERROR", err.Error())
+	h.respondJSON(w, http.StatusOK, models.CreatePRResponse{
+		PR:        pr,
+		Shortfall: shortfall,
+	})
+}
+
+func (h *Handlers) HandlePullRequestReady(w http.ResponseWriter, r *http.Request) {
+	var req models.ReadyPRRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
 		return
 	}
 
-	if newReviewerID == "" {
-		h.respondError(w, http.StatusConflict, models.ErrNoCandidate, "no active replacement candidate in team")
+	pr, shortfall, err := h.service.MarkPullRequestReady(r.Context(), req.PullRequestID)
+	switch {
+	case errors.Is(err, service.ErrPRNotFound):
+		h.respondError(w, http.StatusNotFound, models.ErrNotFound, "pull request not found")
+		return
+	case errors.Is(err, storage.ErrPRMerged):
+		h.respondError(w, http.StatusConflict, models.ErrPRMerged, "cannot mark merged PR as ready")
+		return
+	case errors.Is(err, storage.ErrPRClosed):
+		h.respondError(w, http.StatusConflict, models.ErrPRClosed, "cannot mark closed PR as ready")
+		return
+	case err != nil:
+		h.respondInternalError(w, err)
 		return
 	}
 
-	if err := h.storage.ReassignReviewer(req.PullRequestID, req.OldUserID, newReviewerID); err != nil {
-		h.respondError(w, http.StatusInternalServerError, "INTERNAL_ERROR", err.Error())
+	h.respondJSON(w, http.StatusOK, models.CreatePRResponse{
+		PR:        pr,
+		Shortfall: shortfall,
+	})
+}
+
+func (h *Handlers) HandlePullRequestHistory(w http.ResponseWriter, r *http.Request) {
+	prID := r.URL.Query().Get("pull_request_id")
+	if prID == "" {
+		h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", "pull_request_id query parameter required")
 		return
 	}
 
-	updatedPR, err := h.storage.GetPullRequest(req.PullRequestID)
+	exists, err := h.storage.PRExists(r.Context(), prID)
 	if err != nil {
-		h.respondError(w, http.StatusInternalServerError, "INTERNAL_ERROR", err.Error())
+		h.respondInternalError(w, err)
+		return
+	}
+	if !exists {
+		h.respondError(w, http.StatusNotFound, models.ErrNotFound, "pull request not found")
 		return
 	}
 
-	response := models.ReassignResponse{
-		PR:         *updatedPR,
-		ReplacedBy: newReviewerID,
+	history, err := h.storage.GetReviewerHistory(r.Context(), prID)
+	if err != nil {
+		h.respondInternalError(w, err)
+		return
 	}
 
-	h.respondJSON(w, http.StatusOK, response)
+	h.respondJSON(w, http.StatusOK, models.PRHistoryResponse{
+		PullRequestID: prID,
+		Assignments:   history,
+	})
 }
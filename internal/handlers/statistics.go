@@ -5,14 +5,9 @@ import (
 )
 
 func (h *Handlers) HandleStatistics(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "GET" {
-		h.respondError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "method not allowed")
-		return
-	}
-
-	stats, err := h.storage.GetStatistics()
+	stats, err := h.storage.GetStatistics(r.Context())
 	if err != nil {
-		h.respondError(w, http.StatusInternalServerError, "INTERNAL_ERROR", err.Error())
+		h.respondInternalError(w, err)
 		return
 	}
 
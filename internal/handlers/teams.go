@@ -1,28 +1,58 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/Chamistery/Test_task/internal/models"
+	"github.com/Chamistery/Test_task/internal/service"
+	"github.com/Chamistery/Test_task/internal/storage"
 )
 
 func (h *Handlers) HandleTeamAdd(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		h.respondError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "method not allowed")
-		return
-	}
-
 	var team models.Team
 	if err := json.NewDecoder(r.Body).Decode(&team); err != nil {
 		h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
 		return
 	}
 
-	exists, err := h.storage.TeamExists(team.TeamName)
+	if !h.authorizeTeam(w, r, team.TeamName) || !h.authorizeMembers(w, r, team.TeamName, team.Members) {
+		return
+	}
+
+	if team.AssignmentStrategy != "" && !service.IsKnownStrategy(team.AssignmentStrategy) {
+		h.respondError(w, http.StatusBadRequest, models.ErrUnknownStrategy, "unknown assignment_strategy")
+		return
+	}
+
+	if team.ReviewersRequired < 0 || team.ReviewersRequired > models.MaxReviewersRequired {
+		h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", fmt.Sprintf("reviewers_required must be between 0 and %d", models.MaxReviewersRequired))
+		return
+	}
+
+	if team.ApprovalsRequired < 0 || team.ApprovalsRequired > models.MaxReviewersRequired {
+		h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", fmt.Sprintf("approvals_required must be between 0 and %d", models.MaxReviewersRequired))
+		return
+	}
+
+	for _, member := range team.Members {
+		if member.MaxOpenReviews < 0 {
+			h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", "max_open_reviews must not be negative")
+			return
+		}
+	}
+
+	if !h.validateFallbackTeams(r.Context(), w, team.TeamName, team.FallbackTeams) {
+		return
+	}
+
+	exists, err := h.storage.TeamExists(r.Context(), team.TeamName)
 	if err != nil {
-		h.respondError(w, http.StatusInternalServerError, "INTERNAL_ERROR", err.Error())
+		h.respondInternalError(w, err)
 		return
 	}
 
@@ -31,14 +61,19 @@ func (h *Handlers) HandleTeamAdd(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.storage.CreateTeam(&team); err != nil {
-		h.respondError(w, http.StatusInternalServerError, "INTERNAL_ERROR", err.Error())
+	err = h.storage.CreateTeam(r.Context(), &team)
+	if errors.Is(err, storage.ErrTeamExists) {
+		h.respondError(w, http.StatusBadRequest, models.ErrTeamExists, "team_name already exists")
+		return
+	}
+	if err != nil {
+		h.respondInternalError(w, err)
 		return
 	}
 
-	createdTeam, err := h.storage.GetTeam(team.TeamName)
+	createdTeam, err := h.storage.GetTeam(r.Context(), team.TeamName)
 	if err != nil {
-		h.respondError(w, http.StatusInternalServerError, "INTERNAL_ERROR", err.Error())
+		h.respondInternalError(w, err)
 		return
 	}
 
@@ -48,20 +83,15 @@ func (h *Handlers) HandleTeamAdd(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handlers) HandleTeamGet(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "GET" {
-		h.respondError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "method not allowed")
-		return
-	}
-
 	teamName := r.URL.Query().Get("team_name")
 	if teamName == "" {
 		h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", "team_name query parameter required")
 		return
 	}
 
-	team, err := h.storage.GetTeam(teamName)
+	team, err := h.storage.GetTeam(r.Context(), teamName)
 	if err != nil {
-		h.respondError(w, http.StatusInternalServerError, "INTERNAL_ERROR", err.Error())
+		h.respondInternalError(w, err)
 		return
 	}
 
@@ -73,24 +103,85 @@ func (h *Handlers) HandleTeamGet(w http.ResponseWriter, r *http.Request) {
 	h.respondJSON(w, http.StatusOK, team)
 }
 
-func (h *Handlers) HandleTeamDeactivate(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		h.respondError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "method not allowed")
+func (h *Handlers) HandleTeamUpdate(w http.ResponseWriter, r *http.Request) {
+	var req models.UpdateTeamSettingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
+		return
+	}
+
+	if !h.authorizeTeam(w, r, req.TeamName) {
+		return
+	}
+
+	settings, err := h.storage.GetTeamSettings(r.Context(), req.TeamName)
+	if err != nil {
+		h.respondInternalError(w, err)
+		return
+	}
+	if settings == nil {
+		h.respondError(w, http.StatusNotFound, models.ErrNotFound, "team not found")
 		return
 	}
 
+	if req.AssignmentStrategy != nil {
+		if !service.IsKnownStrategy(*req.AssignmentStrategy) {
+			h.respondError(w, http.StatusBadRequest, models.ErrUnknownStrategy, "unknown assignment_strategy")
+			return
+		}
+		settings.AssignmentStrategy = *req.AssignmentStrategy
+	}
+
+	if req.ReviewersRequired != nil {
+		if *req.ReviewersRequired < 1 || *req.ReviewersRequired > models.MaxReviewersRequired {
+			h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", fmt.Sprintf("reviewers_required must be between 1 and %d", models.MaxReviewersRequired))
+			return
+		}
+		settings.ReviewersRequired = *req.ReviewersRequired
+	}
+
+	if req.ApprovalsRequired != nil {
+		if *req.ApprovalsRequired < 0 || *req.ApprovalsRequired > models.MaxReviewersRequired {
+			h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", fmt.Sprintf("approvals_required must be between 0 and %d", models.MaxReviewersRequired))
+			return
+		}
+		settings.ApprovalsRequired = *req.ApprovalsRequired
+	}
+
+	if req.FallbackTeams != nil {
+		if !h.validateFallbackTeams(r.Context(), w, settings.TeamName, *req.FallbackTeams) {
+			return
+		}
+		settings.FallbackTeams = *req.FallbackTeams
+	}
+
+	if err := h.storage.UpdateTeamSettings(r.Context(), settings); err != nil {
+		h.respondInternalError(w, err)
+		return
+	}
+
+	h.respondJSON(w, http.StatusOK, map[string]interface{}{
+		"team": settings,
+	})
+}
+
+func (h *Handlers) HandleTeamDeactivate(w http.ResponseWriter, r *http.Request) {
 	var req models.BulkDeactivateRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
 		return
 	}
 
+	if !h.authorizeTeam(w, r, req.TeamName) {
+		return
+	}
+
 	start := time.Now()
-	deactivated, reassigned, err := h.storage.BulkDeactivateTeamMembers(req.TeamName)
+	deactivated, reassigned, err := h.service.DeactivateTeam(r.Context(), req.TeamName)
 	duration := time.Since(start)
 
 	if err != nil {
-		h.respondError(w, http.StatusInternalServerError, "INTERNAL_ERROR", err.Error())
+		h.respondInternalError(w, err)
 		return
 	}
 
@@ -102,3 +193,26 @@ func (h *Handlers) HandleTeamDeactivate(w http.ResponseWriter, r *http.Request)
 
 	h.respondJSON(w, http.StatusOK, response)
 }
+
+func (h *Handlers) validateFallbackTeams(ctx context.Context, w http.ResponseWriter, teamName string, fallbackTeams []string) bool {
+	seen := make(map[string]bool, len(fallbackTeams))
+	for _, fallback := range fallbackTeams {
+		if fallback == teamName || seen[fallback] {
+			h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", "fallback_teams must be distinct and differ from team_name")
+			return false
+		}
+		seen[fallback] = true
+
+		exists, err := h.storage.TeamExists(ctx, fallback)
+		if err != nil {
+			h.respondInternalError(w, err)
+			return false
+		}
+		if !exists {
+			h.respondError(w, http.StatusNotFound, models.ErrNotFound, "fallback team not found: "+fallback)
+			return false
+		}
+	}
+
+	return true
+}
@@ -1,32 +1,38 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"net/http"
 
 	"github.com/Chamistery/Test_task/internal/models"
 )
 
 func (h *Handlers) HandleUserSetIsActive(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		h.respondError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "method not allowed")
-		return
-	}
-
 	var req models.SetIsActiveRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
 		return
 	}
 
-	if err := h.storage.SetUserIsActive(req.UserID, req.IsActive); err != nil {
+	if !h.authorizeSelfOrLead(w, r, req.UserID) {
+		return
+	}
+
+	err := h.storage.SetUserIsActive(r.Context(), req.UserID, req.IsActive)
+	if errors.Is(err, sql.ErrNoRows) {
 		h.respondError(w, http.StatusNotFound, models.ErrNotFound, "user not found")
 		return
 	}
+	if err != nil {
+		h.respondInternalError(w, err)
+		return
+	}
 
-	user, err := h.storage.GetUser(req.UserID)
+	user, err := h.storage.GetUser(r.Context(), req.UserID)
 	if err != nil {
-		h.respondError(w, http.StatusInternalServerError, "INTERNAL_ERROR", err.Error())
+		h.respondInternalError(w, err)
 		return
 	}
 
@@ -35,21 +41,62 @@ func (h *Handlers) HandleUserSetIsActive(w http.ResponseWriter, r *http.Request)
 	})
 }
 
-func (h *Handlers) HandleUsersGetReview(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "GET" {
-		h.respondError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "method not allowed")
+func (h *Handlers) HandleUserUpdate(w http.ResponseWriter, r *http.Request) {
+	var req models.UpdateUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
+		return
+	}
+
+	if !h.authorizeUser(w, r, req.UserID) {
+		return
+	}
+
+	if req.MaxOpenReviews != nil && *req.MaxOpenReviews < 0 {
+		h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", "max_open_reviews must not be negative")
+		return
+	}
+
+	user, err := h.storage.GetUser(r.Context(), req.UserID)
+	if err != nil {
+		h.respondInternalError(w, err)
+		return
+	}
+	if user == nil {
+		h.respondError(w, http.StatusNotFound, models.ErrNotFound, "user not found")
+		return
+	}
+
+	if req.Username != nil {
+		user.Username = *req.Username
+	}
+	if req.IsActive != nil {
+		user.IsActive = *req.IsActive
+	}
+	if req.MaxOpenReviews != nil {
+		user.MaxOpenReviews = *req.MaxOpenReviews
+	}
+
+	if err := h.storage.UpdateUser(r.Context(), user); err != nil {
+		h.respondInternalError(w, err)
 		return
 	}
 
+	h.respondJSON(w, http.StatusOK, map[string]interface{}{
+		"user": user,
+	})
+}
+
+func (h *Handlers) HandleUsersGetReview(w http.ResponseWriter, r *http.Request) {
 	userID := r.URL.Query().Get("user_id")
 	if userID == "" {
 		h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", "user_id query parameter required")
 		return
 	}
 
-	prs, err := h.storage.GetPRsByReviewer(userID)
+	prs, err := h.storage.GetPRsByReviewer(r.Context(), userID)
 	if err != nil {
-		h.respondError(w, http.StatusInternalServerError, "INTERNAL_ERROR", err.Error())
+		h.respondInternalError(w, err)
 		return
 	}
 
@@ -60,3 +107,41 @@ func (h *Handlers) HandleUsersGetReview(w http.ResponseWriter, r *http.Request)
 
 	h.respondJSON(w, http.StatusOK, response)
 }
+
+func (h *Handlers) HandleUserLinkAccount(w http.ResponseWriter, r *http.Request) {
+	var req models.LinkAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
+		return
+	}
+
+	if !h.authorizeUser(w, r, req.UserID) {
+		return
+	}
+
+	if req.Provider != models.ProviderGitHub && req.Provider != models.ProviderGitLab {
+		h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", "unknown provider "+req.Provider)
+		return
+	}
+	if req.Login == "" {
+		h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", "login is required")
+		return
+	}
+
+	user, err := h.storage.GetUser(r.Context(), req.UserID)
+	if err != nil {
+		h.respondInternalError(w, err)
+		return
+	}
+	if user == nil {
+		h.respondError(w, http.StatusNotFound, models.ErrNotFound, "user not found")
+		return
+	}
+
+	if err := h.storage.LinkExternalAccount(r.Context(), req.Provider, req.Login, req.UserID); err != nil {
+		h.respondInternalError(w, err)
+		return
+	}
+
+	h.respondJSON(w, http.StatusOK, req)
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Chamistery/Test_task/internal/events"
+	"github.com/Chamistery/Test_task/internal/models"
+)
+
+func (h *Handlers) HandleWebhookAdd(w http.ResponseWriter, r *http.Request) {
+	var subscription models.WebhookSubscription
+	if err := json.NewDecoder(r.Body).Decode(&subscription); err != nil {
+		h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
+		return
+	}
+
+	target, err := url.Parse(subscription.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", "url must be an absolute http or https URL")
+		return
+	}
+
+	if subscription.Secret == "" {
+		h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", "secret is required")
+		return
+	}
+
+	for _, eventType := range subscription.Events {
+		if !events.IsKnownEventType(eventType) {
+			h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", "unknown event type "+eventType)
+			return
+		}
+	}
+
+	if err := h.storage.CreateWebhookSubscription(r.Context(), &subscription); err != nil {
+		h.respondInternalError(w, err)
+		return
+	}
+
+	subscription.Secret = ""
+	h.respondJSON(w, http.StatusCreated, subscription)
+}
+
+func (h *Handlers) HandleWebhookList(w http.ResponseWriter, r *http.Request) {
+	subscriptions, err := h.storage.GetWebhookSubscriptions(r.Context())
+	if err != nil {
+		h.respondInternalError(w, err)
+		return
+	}
+
+	for i := range subscriptions {
+		subscriptions[i].Secret = ""
+	}
+
+	h.respondJSON(w, http.StatusOK, models.WebhookListResponse{Subscriptions: subscriptions})
+}
+
+func (h *Handlers) HandleWebhookDelete(w http.ResponseWriter, r *http.Request) {
+	var req models.DeleteWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
+		return
+	}
+
+	err := h.storage.DeleteWebhookSubscription(r.Context(), req.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		h.respondError(w, http.StatusNotFound, models.ErrNotFound, "webhook subscription not found")
+		return
+	}
+	if err != nil {
+		h.respondInternalError(w, err)
+		return
+	}
+
+	h.respondJSON(w, http.StatusOK, map[string]interface{}{
+		"id": req.ID,
+	})
+}
+
+func (h *Handlers) HandleWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	subscriptionID, err := strconv.ParseInt(query.Get("subscription_id"), 10, 64)
+	if err != nil {
+		h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", "subscription_id query parameter required")
+		return
+	}
+
+	limit := 0
+	if value := query.Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > models.MaxAuditLimit {
+			h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", "limit must be between 1 and "+strconv.Itoa(models.MaxAuditLimit))
+			return
+		}
+	}
+
+	deliveries, err := h.storage.GetWebhookDeliveries(r.Context(), subscriptionID, limit)
+	if err != nil {
+		h.respondInternalError(w, err)
+		return
+	}
+
+	h.respondJSON(w, http.StatusOK, models.WebhookDeliveriesResponse{Deliveries: deliveries})
+}
+
+// HandleWebhookRedeliver schedules a delivery for an immediate attempt, even if
+// it already succeeded or exhausted its retries; the delivery worker sends it.
+func (h *Handlers) HandleWebhookRedeliver(w http.ResponseWriter, r *http.Request) {
+	var req models.RedeliverWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
+		return
+	}
+
+	err := h.storage.ScheduleWebhookDelivery(r.Context(), req.DeliveryID, time.Now())
+	if errors.Is(err, sql.ErrNoRows) {
+		h.respondError(w, http.StatusNotFound, models.ErrNotFound, "webhook delivery not found")
+		return
+	}
+	if err != nil {
+		h.respondInternalError(w, err)
+		return
+	}
+
+	delivery, err := h.storage.GetWebhookDelivery(r.Context(), req.DeliveryID)
+	if err != nil {
+		h.respondInternalError(w, err)
+		return
+	}
+
+	h.respondJSON(w, http.StatusAccepted, delivery)
+}
@@ -1,23 +1,52 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type TeamMember struct {
-	UserID   string `json:"user_id"`
-	Username string `json:"username"`
-	IsActive bool   `json:"is_active"`
+	UserID         string `json:"user_id"`
+	Username       string `json:"username"`
+	IsActive       bool   `json:"is_active"`
+	MaxOpenReviews int    `json:"max_open_reviews,omitempty"`
 }
 
 type Team struct {
-	TeamName string       `json:"team_name"`
-	Members  []TeamMember `json:"members"`
+	TeamName           string       `json:"team_name"`
+	AssignmentStrategy string       `json:"assignment_strategy,omitempty"`
+	ReviewersRequired  int          `json:"reviewers_required,omitempty"`
+	ApprovalsRequired  int          `json:"approvals_required,omitempty"`
+	FallbackTeams      []string     `json:"fallback_teams,omitempty"`
+	Members            []TeamMember `json:"members"`
+}
+
+type TeamSettings struct {
+	TeamName           string   `json:"team_name"`
+	AssignmentStrategy string   `json:"assignment_strategy"`
+	ReviewersRequired  int      `json:"reviewers_required"`
+	ApprovalsRequired  int      `json:"approvals_required"`
+	FallbackTeams      []string `json:"fallback_teams"`
 }
 
+const (
+	DefaultReviewersRequired = 2
+	MaxReviewersRequired     = 10
+)
+
+const (
+	StrategyRandom      = "random"
+	StrategyRoundRobin  = "round_robin"
+	StrategyLeastLoaded = "least_loaded"
+	StrategyWeighted    = "weighted"
+)
+
 type User struct {
-	UserID   string `json:"user_id"`
-	Username string `json:"username"`
-	TeamName string `json:"team_name"`
-	IsActive bool   `json:"is_active"`
+	UserID         string `json:"user_id"`
+	Username       string `json:"username"`
+	TeamName       string `json:"team_name"`
+	IsActive       bool   `json:"is_active"`
+	MaxOpenReviews int    `json:"max_open_reviews,omitempty"`
 }
 
 type PullRequest struct {
@@ -25,9 +54,14 @@ type PullRequest struct {
 	PullRequestName   string     `json:"pull_request_name"`
 	AuthorID          string     `json:"author_id"`
 	Status            string     `json:"status"`
+	IsDraft           bool       `json:"is_draft"`
 	AssignedReviewers []string   `json:"assigned_reviewers"`
+	ReviewersRequired int        `json:"reviewers_required,omitempty"`
+	Reviews           []Review   `json:"reviews"`
+	ForceMerged       bool       `json:"force_merged,omitempty"`
 	CreatedAt         *time.Time `json:"createdAt,omitempty"`
 	MergedAt          *time.Time `json:"mergedAt,omitempty"`
+	ClosedAt          *time.Time `json:"closedAt,omitempty"`
 }
 
 type PullRequestShort struct {
@@ -35,6 +69,41 @@ type PullRequestShort struct {
 	PullRequestName string `json:"pull_request_name"`
 	AuthorID        string `json:"author_id"`
 	Status          string `json:"status"`
+	ReviewState     string `json:"review_state,omitempty"`
+	NeedsAction     bool   `json:"needs_action"`
+}
+
+type Review struct {
+	UserID      string     `json:"user_id"`
+	State       string     `json:"state"`
+	SubmittedAt *time.Time `json:"submittedAt,omitempty"`
+}
+
+const (
+	ReviewPending          = "PENDING"
+	ReviewApproved         = "APPROVED"
+	ReviewChangesRequested = "CHANGES_REQUESTED"
+	ReviewCommented        = "COMMENTED"
+)
+
+const (
+	AssignmentInitial        = "INITIAL"
+	AssignmentManualReassign = "MANUAL_REASSIGN"
+	AssignmentDeactivation   = "DEACTIVATION"
+	AssignmentCapacity       = "CAPACITY"
+)
+
+type ReviewerAssignment struct {
+	UserID         string     `json:"user_id"`
+	AssignedAt     time.Time  `json:"assigned_at"`
+	AssignReason   string     `json:"assign_reason"`
+	UnassignedAt   *time.Time `json:"unassigned_at,omitempty"`
+	UnassignReason string     `json:"unassign_reason,omitempty"`
+}
+
+type PRHistoryResponse struct {
+	PullRequestID string               `json:"pull_request_id"`
+	Assignments   []ReviewerAssignment `json:"assignments"`
 }
 
 type ErrorResponse struct {
@@ -50,9 +119,24 @@ const (
 	ErrTeamExists  = "TEAM_EXISTS"
 	ErrPRExists    = "PR_EXISTS"
 	ErrPRMerged    = "PR_MERGED"
+	ErrPRClosed    = "PR_CLOSED"
+	ErrPRDraft     = "PR_DRAFT"
 	ErrNotAssigned = "NOT_ASSIGNED"
 	ErrNoCandidate = "NO_CANDIDATE"
 	ErrNotFound    = "NOT_FOUND"
+
+	ErrUnknownStrategy  = "UNKNOWN_STRATEGY"
+	ErrAtCapacity       = "AT_CAPACITY"
+	ErrNotApproved      = "NOT_APPROVED"
+	ErrTimeout          = "TIMEOUT"
+	ErrCancelled        = "CANCELLED"
+	ErrConflict         = "CONFLICT"
+	ErrUnmappedUser     = "UNMAPPED_USER"
+	ErrUnauthorized     = "UNAUTHORIZED"
+	ErrForbidden        = "FORBIDDEN"
+	ErrMethodNotAllowed = "METHOD_NOT_ALLOWED"
+	ErrTooLarge         = "PAYLOAD_TOO_LARGE"
+	ErrInternal         = "INTERNAL_ERROR"
 )
 
 type SetIsActiveRequest struct {
@@ -60,14 +144,54 @@ type SetIsActiveRequest struct {
 	IsActive bool   `json:"is_active"`
 }
 
+type UpdateUserRequest struct {
+	UserID         string  `json:"user_id"`
+	Username       *string `json:"username,omitempty"`
+	IsActive       *bool   `json:"is_active,omitempty"`
+	MaxOpenReviews *int    `json:"max_open_reviews,omitempty"`
+}
+
 type CreatePRRequest struct {
 	PullRequestID   string `json:"pull_request_id"`
 	PullRequestName string `json:"pull_request_name"`
 	AuthorID        string `json:"author_id"`
+	ReviewersCount  *int   `json:"reviewers_count,omitempty"`
+	IsDraft         bool   `json:"is_draft,omitempty"`
+}
+
+type CreatePRResponse struct {
+	PR        *PullRequest       `json:"pr"`
+	Shortfall *ReviewerShortfall `json:"reviewer_shortfall,omitempty"`
+}
+
+type ReviewerShortfall struct {
+	Requested int    `json:"requested"`
+	Assigned  int    `json:"assigned"`
+	Code      string `json:"code"`
+	Reason    string `json:"reason"`
 }
 
 type MergePRRequest struct {
 	PullRequestID string `json:"pull_request_id"`
+	Force         bool   `json:"force,omitempty"`
+}
+
+type SubmitReviewRequest struct {
+	PullRequestID string `json:"pull_request_id"`
+	UserID        string `json:"user_id"`
+	State         string `json:"state"`
+}
+
+type ClosePRRequest struct {
+	PullRequestID string `json:"pull_request_id"`
+}
+
+type ReopenPRRequest struct {
+	PullRequestID string `json:"pull_request_id"`
+}
+
+type ReadyPRRequest struct {
+	PullRequestID string `json:"pull_request_id"`
 }
 
 type ReassignRequest struct {
@@ -89,11 +213,21 @@ type Statistics struct {
 	TotalPRs              int            `json:"total_prs"`
 	OpenPRs               int            `json:"open_prs"`
 	MergedPRs             int            `json:"merged_prs"`
+	ClosedPRs             int            `json:"closed_prs"`
+	DraftPRs              int            `json:"draft_prs"`
 	ReviewerAssignments   map[string]int `json:"reviewer_assignments"`
 	PRsByAuthor           map[string]int `json:"prs_by_author"`
 	AverageReviewersPerPR float64        `json:"average_reviewers_per_pr"`
 }
 
+type UpdateTeamSettingsRequest struct {
+	TeamName           string    `json:"team_name"`
+	AssignmentStrategy *string   `json:"assignment_strategy,omitempty"`
+	ReviewersRequired  *int      `json:"reviewers_required,omitempty"`
+	ApprovalsRequired  *int      `json:"approvals_required,omitempty"`
+	FallbackTeams      *[]string `json:"fallback_teams,omitempty"`
+}
+
 type BulkDeactivateRequest struct {
 	TeamName string `json:"team_name"`
 }
@@ -103,3 +237,224 @@ type BulkDeactivateResponse struct {
 	ReassignedPRs    int    `json:"reassigned_prs"`
 	Duration         string `json:"duration"`
 }
+
+const (
+	AuditTeamCreated         = "TEAM_CREATED"
+	AuditTeamDeactivated     = "TEAM_DEACTIVATED"
+	AuditUserActivityChanged = "USER_ACTIVITY_CHANGED"
+	AuditPRCreated           = "PR_CREATED"
+	AuditPRMerged            = "PR_MERGED"
+	AuditReviewerReassigned  = "REVIEWER_REASSIGNED"
+	AuditReviewersReplaced   = "REVIEWERS_REPLACED"
+	AuditReviewSubmitted     = "REVIEW_SUBMITTED"
+	AuditPRClosed            = "PR_CLOSED"
+	AuditPRReopened          = "PR_REOPENED"
+	AuditPRReady             = "PR_READY"
+	AuditTeamSettingsUpdated = "TEAM_SETTINGS_UPDATED"
+)
+
+const (
+	DefaultAuditLimit = 100
+	MaxAuditLimit     = 1000
+)
+
+type AuditEvent struct {
+	ID            int64           `json:"id"`
+	Type          string          `json:"event_type"`
+	Actor         string          `json:"actor"`
+	Reason        string          `json:"reason,omitempty"`
+	TeamName      string          `json:"team_name,omitempty"`
+	PullRequestID string          `json:"pull_request_id,omitempty"`
+	UserIDs       []string        `json:"user_ids,omitempty"`
+	Before        json.RawMessage `json:"before,omitempty"`
+	After         json.RawMessage `json:"after,omitempty"`
+	CreatedAt     time.Time       `json:"created_at"`
+}
+
+type AuditFilter struct {
+	PullRequestID string
+	UserID        string
+	TeamName      string
+	Since         *time.Time
+	Until         *time.Time
+	AfterID       int64
+	Limit         int
+}
+
+type AuditResponse struct {
+	Events []AuditEvent `json:"events"`
+}
+
+const (
+	EventPRCreated        = "PRCreated"
+	EventReviewerAssigned = "ReviewerAssigned"
+	EventReviewerReplaced = "ReviewerReplaced"
+	EventPRMerged         = "PRMerged"
+	EventUserDeactivated  = "UserDeactivated"
+	EventTeamDeactivated  = "TeamDeactivated"
+	EventReviewSubmitted  = "ReviewSubmitted"
+)
+
+type DomainEvent struct {
+	ID          int64           `json:"id"`
+	Type        string          `json:"type"`
+	AggregateID string          `json:"aggregate_id"`
+	Payload     json.RawMessage `json:"payload"`
+	OccurredAt  time.Time       `json:"occurred_at"`
+	Attempts    int             `json:"-"`
+}
+
+type PRCreatedPayload struct {
+	PullRequestID     string   `json:"pull_request_id"`
+	PullRequestName   string   `json:"pull_request_name"`
+	AuthorID          string   `json:"author_id"`
+	IsDraft           bool     `json:"is_draft"`
+	AssignedReviewers []string `json:"assigned_reviewers"`
+}
+
+type ReviewerAssignedPayload struct {
+	PullRequestID string `json:"pull_request_id"`
+	UserID        string `json:"user_id"`
+	Reason        string `json:"reason"`
+}
+
+type ReviewerReplacedPayload struct {
+	PullRequestID  string   `json:"pull_request_id"`
+	RemovedUserIDs []string `json:"removed_user_ids"`
+	AddedUserIDs   []string `json:"added_user_ids"`
+	Reason         string   `json:"reason"`
+}
+
+type ReviewSubmittedPayload struct {
+	PullRequestID string `json:"pull_request_id"`
+	UserID        string `json:"user_id"`
+	State         string `json:"state"`
+}
+
+type PRMergedPayload struct {
+	PullRequestID string `json:"pull_request_id"`
+	ForceMerged   bool   `json:"force_merged"`
+}
+
+type UserDeactivatedPayload struct {
+	UserID   string `json:"user_id"`
+	TeamName string `json:"team_name"`
+}
+
+type TeamDeactivatedPayload struct {
+	TeamName         string   `json:"team_name"`
+	DeactivatedUsers []string `json:"deactivated_users"`
+	ReassignedPRs    int      `json:"reassigned_prs"`
+}
+
+const (
+	WebhookPending   = "PENDING"
+	WebhookDelivered = "DELIVERED"
+	WebhookFailed    = "FAILED"
+)
+
+type WebhookSubscription struct {
+	ID        int64     `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type WebhookDelivery struct {
+	ID             int64            `json:"id"`
+	SubscriptionID int64            `json:"subscription_id"`
+	EventID        int64            `json:"event_id"`
+	EventType      string           `json:"event_type"`
+	Status         string           `json:"status"`
+	AttemptCount   int              `json:"attempt_count"`
+	NextAttemptAt  *time.Time       `json:"next_attempt_at,omitempty"`
+	CreatedAt      time.Time        `json:"created_at"`
+	DeliveredAt    *time.Time       `json:"delivered_at,omitempty"`
+	Attempts       []WebhookAttempt `json:"attempts,omitempty"`
+	Payload        json.RawMessage  `json:"-"`
+	URL            string           `json:"-"`
+	Secret         string           `json:"-"`
+}
+
+type WebhookAttempt struct {
+	AttemptedAt time.Time `json:"attempted_at"`
+	StatusCode  int       `json:"status_code,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+type DeleteWebhookRequest struct {
+	ID int64 `json:"id"`
+}
+
+type RedeliverWebhookRequest struct {
+	DeliveryID int64 `json:"delivery_id"`
+}
+
+type WebhookListResponse struct {
+	Subscriptions []WebhookSubscription `json:"subscriptions"`
+}
+
+type WebhookDeliveriesResponse struct {
+	Deliveries []WebhookDelivery `json:"deliveries"`
+}
+
+const (
+	ProviderGitHub = "github"
+	ProviderGitLab = "gitlab"
+)
+
+type LinkAccountRequest struct {
+	Provider string `json:"provider"`
+	Login    string `json:"login"`
+	UserID   string `json:"user_id"`
+}
+
+const (
+	InboundApplied   = "applied"
+	InboundDuplicate = "duplicate"
+	InboundIgnored   = "ignored"
+)
+
+type InboundWebhookResponse struct {
+	Status string       `json:"status"`
+	PR     *PullRequest `json:"pr,omitempty"`
+}
+
+const (
+	RoleAdmin    = "admin"
+	RoleTeamLead = "team-lead"
+	RoleBot      = "bot"
+	RoleReadOnly = "read-only"
+	// RoleMember is given to SSO users whose token carries no role.
+	RoleMember = "member"
+)
+
+type APIKey struct {
+	ID        int64      `json:"id"`
+	Name      string     `json:"name"`
+	Role      string     `json:"role"`
+	TeamName  string     `json:"team_name,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name     string `json:"name"`
+	Role     string `json:"role"`
+	TeamName string `json:"team_name,omitempty"`
+}
+
+// CreateAPIKeyResponse is the only place the plaintext key is ever returned.
+type CreateAPIKeyResponse struct {
+	APIKey APIKey `json:"api_key"`
+	Key    string `json:"key"`
+}
+
+type RevokeAPIKeyRequest struct {
+	ID int64 `json:"id"`
+}
+
+type APIKeyListResponse struct {
+	Keys []APIKey `json:"keys"`
+}
@@ -0,0 +1,144 @@
+package router
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Chamistery/Test_task/internal/auth"
+	"github.com/Chamistery/Test_task/internal/handlers"
+	"github.com/Chamistery/Test_task/internal/models"
+)
+
+// Route binds a method and path to a handler together with the least
+// privileged role that may call it.
+type Route struct {
+	Method  string
+	Path    string
+	Access  auth.Access
+	Handler http.HandlerFunc
+}
+
+// Routes is the service's route table. Webhook receivers are public because
+// they check their own signatures.
+func Routes(h *handlers.Handlers) []Route {
+	return []Route{
+		{"POST", "/team/add", auth.AccessTeam, h.HandleTeamAdd},
+		{"GET", "/team/get", auth.AccessRead, h.HandleTeamGet},
+		{"POST", "/team/update", auth.AccessTeam, h.HandleTeamUpdate},
+		{"POST", "/team/deactivate", auth.AccessTeam, h.HandleTeamDeactivate},
+
+		{"POST", "/users/setIsActive", auth.AccessWrite, h.HandleUserSetIsActive},
+		{"POST", "/users/update", auth.AccessTeam, h.HandleUserUpdate},
+		{"POST", "/users/linkAccount", auth.AccessTeam, h.HandleUserLinkAccount},
+		{"GET", "/users/getReview", auth.AccessRead, h.HandleUsersGetReview},
+
+		{"POST", "/pullRequest/create", auth.AccessWrite, h.HandlePullRequestCreate},
+		{"POST", "/pullRequest/merge", auth.AccessWrite, h.HandlePullRequestMerge},
+		{"POST", "/pullRequest/close", auth.AccessWrite, h.HandlePullRequestClose},
+		{"POST", "/pullRequest/reopen", auth.AccessWrite, h.HandlePullRequestReopen},
+		{"POST", "/pullRequest/ready", auth.AccessWrite, h.HandlePullRequestReady},
+		{"POST", "/pullRequest/reassign", auth.AccessWrite, h.HandlePullRequestReassign},
+		{"POST", "/pullRequest/review", auth.AccessWrite, h.HandlePullRequestReview},
+		{"GET", "/pullRequest/history", auth.AccessRead, h.HandlePullRequestHistory},
+
+		{"GET", "/statistics", auth.AccessRead, h.HandleStatistics},
+		{"GET", "/audit", auth.AccessRead, h.HandleAuditGet},
+
+		{"POST", "/webhook/add", auth.AccessAdmin, h.HandleWebhookAdd},
+		{"GET", "/webhook/list", auth.AccessAdmin, h.HandleWebhookList},
+		{"POST", "/webhook/delete", auth.AccessAdmin, h.HandleWebhookDelete},
+		{"GET", "/webhook/deliveries", auth.AccessAdmin, h.HandleWebhookDeliveries},
+		{"POST", "/webhook/redeliver", auth.AccessAdmin, h.HandleWebhookRedeliver},
+
+		{"POST", "/webhooks/github", auth.AccessPublic, h.HandleGitHubWebhook},
+		{"POST", "/webhooks/gitlab", auth.AccessPublic, h.HandleGitLabWebhook},
+
+		{"POST", "/apikey/create", auth.AccessAdmin, h.HandleAPIKeyCreate},
+		{"GET", "/apikey/list", auth.AccessAdmin, h.HandleAPIKeyList},
+		{"POST", "/apikey/revoke", auth.AccessAdmin, h.HandleAPIKeyRevoke},
+	}
+}
+
+type Config struct {
+	RequestTimeout time.Duration
+	// MaxBodyBytes caps request bodies; zero means 1 MiB.
+	MaxBodyBytes int64
+	// AllowedOrigins lists the origins browsers may call the API from; "*"
+	// allows any. Without it CORS headers are never sent.
+	AllowedOrigins []string
+	Logger         *log.Logger
+}
+
+// New serves Routes(h) behind the shared middleware. Outermost first, a
+// request gets an ID, is logged, recovered from panics, answered for CORS,
+// has its body capped and its deadline set before it is authenticated and
+// dispatched.
+func New(h *handlers.Handlers, config Config) http.Handler {
+	if config.MaxBodyBytes <= 0 {
+		config.MaxBodyBytes = 1 << 20
+	}
+	if config.Logger == nil {
+		config.Logger = log.Default()
+	}
+
+	mux := http.NewServeMux()
+	for _, route := range Routes(h) {
+		mux.Handle(route.Method+" "+route.Path, h.Authenticate(route.Access, route.Handler))
+	}
+
+	var handler http.Handler = &jsonMux{mux: mux}
+	handler = handlers.AuditContext(handler)
+	handler = handlers.RequestTimeout(config.RequestTimeout, handler)
+	handler = LimitBody(config.MaxBodyBytes, handler)
+	handler = CORS(config.AllowedOrigins, handler)
+	handler = Recover(config.Logger, handler)
+	handler = Logging(config.Logger, handler)
+	return RequestID(handler)
+}
+
+// jsonMux answers unknown paths and wrong methods in the API's error format
+// instead of ServeMux's plain text.
+type jsonMux struct {
+	mux *http.ServeMux
+}
+
+func (m *jsonMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	handler, pattern := m.mux.Handler(r)
+	if pattern != "" {
+		handler.ServeHTTP(w, r)
+		return
+	}
+
+	probe := &headerProbe{header: http.Header{}}
+	handler.ServeHTTP(probe, r)
+	switch probe.status {
+	case http.StatusMethodNotAllowed:
+		w.Header().Set("Allow", probe.header.Get("Allow"))
+		writeError(w, http.StatusMethodNotAllowed, models.ErrMethodNotAllowed, r.Method+" is not allowed on "+r.URL.Path)
+	case http.StatusNotFound:
+		writeError(w, http.StatusNotFound, models.ErrNotFound, "no route for "+r.URL.Path)
+	default:
+		// Redirects to the cleaned path.
+		handler.ServeHTTP(w, r)
+	}
+}
+
+// headerProbe records what ServeMux's fallback handler would answer.
+type headerProbe struct {
+	header http.Header
+	status int
+}
+
+func (p *headerProbe) Header() http.Header { return p.header }
+
+func (p *headerProbe) Write(data []byte) (int, error) { return len(data), nil }
+
+func (p *headerProbe) WriteHeader(status int) { p.status = status }
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(models.ErrorResponse{Error: models.ErrorDetail{Code: code, Message: message}})
+}
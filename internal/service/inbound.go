@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Chamistery/Test_task/internal/models"
+	"github.com/Chamistery/Test_task/internal/storage"
+)
+
+const (
+	InboundOpened   = "opened"
+	InboundReady    = "ready"
+	InboundClosed   = "closed"
+	InboundMerged   = "merged"
+	InboundReopened = "reopened"
+)
+
+var (
+	ErrDuplicateDelivery = errors.New("delivery already processed")
+	ErrUnmappedUser      = errors.New("external account is not linked to a user")
+)
+
+// InboundPullRequest is a pull request event from a code host translated into
+// this service's terms. Provider names both the external account namespace and
+// the delivery ID namespace. AuthorLogin is empty when the code host did not
+// say who authored the pull request; such events never create it.
+type InboundPullRequest struct {
+	Provider      string
+	DeliveryID    string
+	Action        string
+	PullRequestID string
+	Title         string
+	AuthorLogin   string
+	IsDraft       bool
+}
+
+// ApplyInbound brings the pull request in line with an event from a code host
+// and returns its resulting state, or nil if the event referred to a pull
+// request this service never saw. The delivery ID is recorded in the same
+// transaction, so a redelivery reports ErrDuplicateDelivery while a delivery
+// that failed can be retried by the sender.
+func (s *ReviewerService) ApplyInbound(ctx context.Context, event InboundPullRequest) (*models.PullRequest, error) {
+	applied, err := s.applyInbound(ctx, event)
+	if errors.Is(err, storage.ErrPRExists) {
+		// A concurrent delivery created the pull request first. The rollback
+		// also dropped this delivery's record, so apply it to that one.
+		return s.applyInbound(ctx, event)
+	}
+	return applied, err
+}
+
+func (s *ReviewerService) applyInbound(ctx context.Context, event InboundPullRequest) (*models.PullRequest, error) {
+	var applied *models.PullRequest
+
+	err := s.storage.WithinTx(ctx, func(tx storage.Storage) error {
+		first, err := tx.RecordInboundDelivery(ctx, event.Provider, event.DeliveryID)
+		if err != nil {
+			return err
+		}
+		if !first {
+			return ErrDuplicateDelivery
+		}
+
+		svc := s.withStorage(tx)
+		pr, err := tx.GetPullRequestForUpdate(ctx, event.PullRequestID)
+		if err != nil {
+			return err
+		}
+
+		switch event.Action {
+		case InboundClosed, InboundMerged:
+			if pr == nil {
+				return nil
+			}
+		default:
+			if pr == nil && event.AuthorLogin == "" {
+				return nil
+			}
+			if pr == nil {
+				applied, err = svc.createInbound(ctx, event)
+				return err
+			}
+		}
+
+		switch event.Action {
+		case InboundClosed:
+			if pr.Status == "OPEN" {
+				if _, err := tx.ClosePullRequest(ctx, pr.PullRequestID); err != nil {
+					return err
+				}
+			}
+		case InboundMerged:
+			if pr.Status != "MERGED" {
+				if _, err := tx.MergePullRequest(ctx, pr.PullRequestID, true); err != nil {
+					return err
+				}
+			}
+		case InboundReopened:
+			if pr.Status == "CLOSED" {
+				if _, err := svc.reopen(ctx, pr); err != nil {
+					return err
+				}
+			}
+		case InboundReady:
+			if pr.Status == "OPEN" {
+				if _, err := svc.markReady(ctx, pr); err != nil {
+					return err
+				}
+			}
+		}
+
+		applied, err = tx.GetPullRequest(ctx, event.PullRequestID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return applied, nil
+}
+
+// Events for pull requests opened before the webhook was configured create
+// them on first sight, so later closes and merges have something to act on.
+func (s *ReviewerService) createInbound(ctx context.Context, event InboundPullRequest) (*models.PullRequest, error) {
+	authorID, err := s.resolveAccount(ctx, event.Provider, event.AuthorLogin)
+	if err != nil {
+		return nil, err
+	}
+
+	created, _, err := s.CreatePullRequest(ctx, &models.PullRequest{
+		PullRequestID:   event.PullRequestID,
+		PullRequestName: event.Title,
+		AuthorID:        authorID,
+		IsDraft:         event.IsDraft && event.Action != InboundReady,
+	})
+	return created, err
+}
+
+// resolveAccount prefers an explicitly linked account and otherwise accepts a
+// login that is itself a user ID, which covers teams that reuse their code
+// host usernames as IDs.
+func (s *ReviewerService) resolveAccount(ctx context.Context, provider string, login string) (string, error) {
+	userID, err := s.storage.GetExternalAccountUser(ctx, provider, login)
+	if err != nil || userID != "" {
+		return userID, err
+	}
+
+	user, err := s.storage.GetUser(ctx, login)
+	if err != nil {
+		return "", err
+	}
+	if user == nil {
+		return "", ErrUnmappedUser
+	}
+	return user.UserID, nil
+}
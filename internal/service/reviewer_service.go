@@ -1,72 +1,508 @@
 package service
 
 import (
-	"math/rand"
-	"time"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
 
+	"github.com/Chamistery/Test_task/internal/models"
 	"github.com/Chamistery/Test_task/internal/storage"
 )
 
+var (
+	ErrAuthorNotFound = errors.New("author not found")
+	ErrPRNotFound     = errors.New("pull request not found")
+	ErrNoCandidate    = errors.New("no active replacement candidate")
+)
+
 type ReviewerService struct {
-	storage storage.Storage
-	rand    *rand.Rand
+	storage    storage.Storage
+	strategies map[string]Strategy
 }
 
 func NewReviewerService(storage storage.Storage) *ReviewerService {
+	strategies := make(map[string]Strategy)
+	for _, name := range []string{
+		models.StrategyRandom,
+		models.StrategyRoundRobin,
+		models.StrategyLeastLoaded,
+		models.StrategyWeighted,
+	} {
+		strategies[name] = NewStrategy(name)
+	}
+
 	return &ReviewerService{
-		storage: storage,
-		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+		storage:    storage,
+		strategies: strategies,
 	}
 }
 
-func (s *ReviewerService) AssignReviewers(authorID string) ([]string, error) {
-	teamName, err := s.storage.GetUserTeam(authorID)
+func (s *ReviewerService) CreatePullRequest(ctx context.Context, pr *models.PullRequest) (*models.PullRequest, *models.ReviewerShortfall, error) {
+	var created *models.PullRequest
+	var shortfall *models.ReviewerShortfall
+
+	err := s.storage.WithinTx(ctx, func(tx storage.Storage) error {
+		exists, err := tx.PRExists(ctx, pr.PullRequestID)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return storage.ErrPRExists
+		}
+
+		author, err := tx.GetUser(ctx, pr.AuthorID)
+		if err != nil {
+			return err
+		}
+		if author == nil {
+			return ErrAuthorNotFound
+		}
+
+		pr.AssignedReviewers = []string{}
+		if !pr.IsDraft {
+			pr.AssignedReviewers, shortfall, err = s.withStorage(tx).AssignReviewers(ctx, pr.AuthorID, pr.ReviewersRequired)
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := tx.CreatePullRequest(ctx, pr); err != nil {
+			return err
+		}
+
+		created, err = tx.GetPullRequest(ctx, pr.PullRequestID)
+		return err
+	})
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	if teamName == "" {
-		return []string{}, nil
+	return created, shortfall, nil
+}
+
+func (s *ReviewerService) AssignReviewers(ctx context.Context, authorID string, requested int) ([]string, *models.ReviewerShortfall, error) {
+	pools, settings, err := s.authorPools(ctx, authorID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if settings == nil {
+		if requested <= 0 {
+			requested = models.DefaultReviewersRequired
+		}
+		return []string{}, &models.ReviewerShortfall{
+			Requested: requested,
+			Code:      models.ErrNoCandidate,
+			Reason:    "author does not belong to a team",
+		}, nil
+	}
+
+	if requested <= 0 {
+		requested = settings.ReviewersRequired
 	}
 
-	candidates, err := s.storage.GetActiveCandidates(teamName, []string{authorID})
+	excludeIDs := []string{authorID}
+
+	reviewers, err := s.fillFromPools(ctx, pools, excludeIDs, requested)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	if len(reviewers) >= requested {
+		return reviewers, nil, nil
+	}
+
+	shortfall, err := s.explainShortfall(ctx, pools, append(excludeIDs, reviewers...), requested, len(reviewers))
+	if err != nil {
+		return nil, nil, err
 	}
 
-	s.rand.Shuffle(len(candidates), func(i, j int) {
-		candidates[i], candidates[j] = candidates[j], candidates[i]
+	return reviewers, shortfall, nil
+}
+
+// authorPools lists the teams reviewers are drawn from for the author's PRs:
+// the author's team followed by its fallback teams. Settings are nil when the
+// author has no team.
+func (s *ReviewerService) authorPools(ctx context.Context, authorID string) ([]string, *models.TeamSettings, error) {
+	teamName, err := s.storage.GetUserTeam(ctx, authorID)
+	if err != nil || teamName == "" {
+		return nil, nil, err
+	}
+
+	settings, err := s.teamSettings(ctx, teamName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return append([]string{teamName}, settings.FallbackTeams...), settings, nil
+}
+
+func (s *ReviewerService) ReassignReviewer(ctx context.Context, prID string, oldUserID string) (*models.PullRequest, string, error) {
+	var updated *models.PullRequest
+	var newReviewerID string
+
+	err := s.storage.WithinTx(ctx, func(tx storage.Storage) error {
+		pr, err := tx.GetPullRequestForUpdate(ctx, prID)
+		if err != nil {
+			return err
+		}
+		if pr == nil {
+			return ErrPRNotFound
+		}
+
+		switch pr.Status {
+		case "MERGED":
+			return storage.ErrPRMerged
+		case "CLOSED":
+			return storage.ErrPRClosed
+		}
+
+		if !slices.Contains(pr.AssignedReviewers, oldUserID) {
+			return storage.ErrNotAssigned
+		}
+
+		newReviewerID, err = s.withStorage(tx).FindReplacementReviewer(ctx, prID, oldUserID)
+		if err != nil {
+			return err
+		}
+		if newReviewerID == "" {
+			return ErrNoCandidate
+		}
+
+		if err := tx.ReassignReviewer(ctx, prID, oldUserID, newReviewerID); err != nil {
+			return err
+		}
+
+		updated, err = tx.GetPullRequest(ctx, prID)
+		return err
 	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return updated, newReviewerID, nil
+}
+
+// SubmitReview records a reviewer's decision on the locked PR, so a concurrent
+// merge, close or reassign cannot slip in between the checks and the write.
+func (s *ReviewerService) SubmitReview(ctx context.Context, prID string, userID string, state string) (*models.PullRequest, error) {
+	var updated *models.PullRequest
+
+	err := s.storage.WithinTx(ctx, func(tx storage.Storage) error {
+		pr, err := tx.GetPullRequestForUpdate(ctx, prID)
+		if err != nil {
+			return err
+		}
+		if pr == nil {
+			return ErrPRNotFound
+		}
+
+		switch pr.Status {
+		case "MERGED":
+			return storage.ErrPRMerged
+		case "CLOSED":
+			return storage.ErrPRClosed
+		}
 
-	maxReviewers := 2
-	if len(candidates) < maxReviewers {
-		maxReviewers = len(candidates)
+		if !slices.Contains(pr.AssignedReviewers, userID) {
+			return storage.ErrNotAssigned
+		}
+
+		if err := tx.SubmitReview(ctx, prID, userID, state); err != nil {
+			return err
+		}
+
+		updated, err = tx.GetPullRequest(ctx, prID)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return candidates[:maxReviewers], nil
+	return updated, nil
 }
 
-func (s *ReviewerService) FindReplacementReviewer(prID string, oldUserID string) (string, error) {
-	teamName, err := s.storage.GetUserTeam(oldUserID)
+func (s *ReviewerService) FindReplacementReviewer(ctx context.Context, prID string, oldUserID string) (string, error) {
+	teamName, err := s.storage.GetUserTeam(ctx, oldUserID)
 	if err != nil {
 		return "", err
 	}
 
-	pr, err := s.storage.GetPullRequest(prID)
+	pr, err := s.storage.GetPullRequest(ctx, prID)
 	if err != nil || pr == nil {
 		return "", err
 	}
 
+	authorPools, _, err := s.authorPools(ctx, pr.AuthorID)
+	if err != nil {
+		return "", err
+	}
+	pools := append([]string{teamName}, authorPools...)
+
 	excludeIDs := append(pr.AssignedReviewers, pr.AuthorID)
 
-	candidates, err := s.storage.GetActiveCandidates(teamName, excludeIDs)
+	selected, err := s.fillFromPools(ctx, pools, excludeIDs, 1)
 	if err != nil {
 		return "", err
 	}
 
-	if len(candidates) == 0 {
+	if len(selected) == 0 {
 		return "", nil
 	}
 
-	return candidates[s.rand.Intn(len(candidates))], nil
+	return selected[0], nil
+}
+
+func (s *ReviewerService) TopUpReviewers(ctx context.Context, pr *models.PullRequest) (*models.ReviewerShortfall, error) {
+	var keep, drop []string
+	for _, reviewerID := range pr.AssignedReviewers {
+		user, err := s.storage.GetUser(ctx, reviewerID)
+		if err != nil {
+			return nil, err
+		}
+		if user == nil || !user.IsActive {
+			drop = append(drop, reviewerID)
+			continue
+		}
+		keep = append(keep, reviewerID)
+	}
+
+	pools, settings, err := s.authorPools(ctx, pr.AuthorID)
+	if err != nil {
+		return nil, err
+	}
+
+	requested := pr.ReviewersRequired
+	if requested <= 0 && settings != nil {
+		requested = settings.ReviewersRequired
+	}
+	if requested <= 0 {
+		requested = models.DefaultReviewersRequired
+	}
+
+	excludeIDs := append(append([]string{}, pr.AssignedReviewers...), pr.AuthorID)
+
+	var added []string
+	if missing := requested - len(keep); missing > 0 {
+		added, err = s.fillFromPools(ctx, pools, excludeIDs, missing)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(drop) > 0 || len(added) > 0 {
+		if err := s.storage.ReplaceReviewers(ctx, pr.PullRequestID, drop, added, topUpReason(pr, drop)); err != nil {
+			return nil, err
+		}
+	}
+
+	assigned := len(keep) + len(added)
+	if assigned >= requested {
+		return nil, nil
+	}
+
+	return s.explainShortfall(ctx, pools, append(excludeIDs, added...), requested, assigned)
+}
+
+// ReopenPullRequest reopens a closed PR and, unless it is a draft, tops its
+// reviewers back up. Reopening an open PR changes nothing.
+func (s *ReviewerService) ReopenPullRequest(ctx context.Context, prID string) (*models.PullRequest, *models.ReviewerShortfall, error) {
+	return s.transition(ctx, prID, (*ReviewerService).reopen)
+}
+
+// MarkPullRequestReady takes a PR out of draft and assigns its reviewers. A PR
+// that is not a draft is returned unchanged.
+func (s *ReviewerService) MarkPullRequestReady(ctx context.Context, prID string) (*models.PullRequest, *models.ReviewerShortfall, error) {
+	return s.transition(ctx, prID, (*ReviewerService).markReady)
+}
+
+// transition runs a status change and the reviewer top-up it triggers on the
+// locked PR in one transaction, so concurrent calls cannot both assign
+// reviewers and a failed top-up leaves the status untouched.
+func (s *ReviewerService) transition(ctx context.Context, prID string, step func(*ReviewerService, context.Context, *models.PullRequest) (*models.ReviewerShortfall, error)) (*models.PullRequest, *models.ReviewerShortfall, error) {
+	var updated *models.PullRequest
+	var shortfall *models.ReviewerShortfall
+
+	err := s.storage.WithinTx(ctx, func(tx storage.Storage) error {
+		pr, err := tx.GetPullRequestForUpdate(ctx, prID)
+		if err != nil {
+			return err
+		}
+		if pr == nil {
+			return ErrPRNotFound
+		}
+
+		if shortfall, err = step(s.withStorage(tx), ctx, pr); err != nil {
+			return err
+		}
+
+		updated, err = tx.GetPullRequest(ctx, prID)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return updated, shortfall, nil
+}
+
+// reopen and markReady expect pr to be locked by the caller's transaction.
+func (s *ReviewerService) reopen(ctx context.Context, pr *models.PullRequest) (*models.ReviewerShortfall, error) {
+	if pr.Status == "OPEN" {
+		return nil, nil
+	}
+
+	reopened, err := s.storage.ReopenPullRequest(ctx, pr.PullRequestID)
+	if err != nil || reopened.IsDraft {
+		return nil, err
+	}
+	return s.TopUpReviewers(ctx, reopened)
+}
+
+func (s *ReviewerService) markReady(ctx context.Context, pr *models.PullRequest) (*models.ReviewerShortfall, error) {
+	if !pr.IsDraft {
+		return nil, nil
+	}
+
+	ready, err := s.storage.MarkPullRequestReady(ctx, pr.PullRequestID)
+	if err != nil {
+		return nil, err
+	}
+	return s.TopUpReviewers(ctx, ready)
+}
+
+// Dropped reviewers were deactivated; otherwise the PR either had no reviewers
+// yet or is filling slots that were left empty because candidates were busy.
+func topUpReason(pr *models.PullRequest, drop []string) string {
+	switch {
+	case len(drop) > 0:
+		return models.AssignmentDeactivation
+	case len(pr.AssignedReviewers) == 0:
+		return models.AssignmentInitial
+	default:
+		return models.AssignmentCapacity
+	}
+}
+
+func (s *ReviewerService) fillFromPools(ctx context.Context, pools []string, excludeIDs []string, count int) ([]string, error) {
+	reviewers := []string{}
+	visited := make(map[string]bool, len(pools))
+
+	for _, teamName := range pools {
+		if len(reviewers) >= count {
+			break
+		}
+		if teamName == "" || visited[teamName] {
+			continue
+		}
+		visited[teamName] = true
+
+		settings, err := s.teamSettings(ctx, teamName)
+		if err != nil {
+			return nil, err
+		}
+
+		exclude := append(append([]string{}, excludeIDs...), reviewers...)
+		candidates, err := s.storage.GetActiveCandidates(ctx, teamName, exclude)
+		if err != nil {
+			return nil, err
+		}
+
+		selected, err := s.selectReviewers(ctx, settings, candidates, count-len(reviewers))
+		if err != nil {
+			return nil, err
+		}
+
+		reviewers = append(reviewers, selected...)
+	}
+
+	return reviewers, nil
+}
+
+func (s *ReviewerService) explainShortfall(ctx context.Context, pools []string, excludeIDs []string, requested, assigned int) (*models.ReviewerShortfall, error) {
+	saturated := 0
+	for _, teamName := range pools {
+		count, err := s.storage.CountSaturatedCandidates(ctx, teamName, excludeIDs)
+		if err != nil {
+			return nil, err
+		}
+		saturated += count
+	}
+
+	if saturated > 0 {
+		return &models.ReviewerShortfall{
+			Requested: requested,
+			Assigned:  assigned,
+			Code:      models.ErrAtCapacity,
+			Reason:    fmt.Sprintf("%d active candidate(s) skipped: already at max_open_reviews", saturated),
+		}, nil
+	}
+
+	return &models.ReviewerShortfall{
+		Requested: requested,
+		Assigned:  assigned,
+		Code:      models.ErrNoCandidate,
+		Reason:    "not enough active candidates in team or its fallback teams",
+	}, nil
+}
+
+func (s *ReviewerService) teamSettings(ctx context.Context, teamName string) (*models.TeamSettings, error) {
+	settings, err := s.storage.GetTeamSettings(ctx, teamName)
+	if err != nil {
+		return nil, err
+	}
+
+	if settings == nil {
+		settings = &models.TeamSettings{
+			TeamName:           teamName,
+			AssignmentStrategy: models.StrategyRandom,
+			ReviewersRequired:  models.DefaultReviewersRequired,
+		}
+	}
+
+	return settings, nil
+}
+
+func (s *ReviewerService) selectReviewers(ctx context.Context, settings *models.TeamSettings, candidates []string, count int) ([]string, error) {
+	if len(candidates) == 0 {
+		return []string{}, nil
+	}
+
+	loads, err := s.storage.GetOpenReviewCounts(ctx, candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	strategy := s.strategyFor(settings.AssignmentStrategy)
+	return strategy.Select(settings.TeamName, candidates, loads, count), nil
+}
+
+// DeactivateTeam deactivates the team's members and refills their open
+// reviews from the same pools as new PRs, fallback teams included.
+func (s *ReviewerService) DeactivateTeam(ctx context.Context, teamName string) (int, int, error) {
+	return s.storage.BulkDeactivateTeamMembers(ctx, teamName, func(ctx context.Context, tx storage.Storage, pr *models.PullRequest, count int) ([]string, error) {
+		svc := s.withStorage(tx)
+		pools, _, err := svc.authorPools(ctx, pr.AuthorID)
+		if err != nil {
+			return nil, err
+		}
+
+		excludeIDs := append(append([]string{}, pr.AssignedReviewers...), pr.AuthorID)
+		return svc.fillFromPools(ctx, pools, excludeIDs, count)
+	})
+}
+
+func (s *ReviewerService) withStorage(store storage.Storage) *ReviewerService {
+	return &ReviewerService{
+		storage:    store,
+		strategies: s.strategies,
+	}
+}
+
+func (s *ReviewerService) strategyFor(name string) Strategy {
+	if strategy, ok := s.strategies[name]; ok {
+		return strategy
+	}
+	return s.strategies[models.StrategyRandom]
 }
@@ -0,0 +1,170 @@
+package service
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Chamistery/Test_task/internal/models"
+)
+
+type Strategy interface {
+	Select(teamName string, candidates []string, loads map[string]int, count int) []string
+}
+
+func NewStrategy(name string) Strategy {
+	source := newLockedRand()
+
+	switch name {
+	case models.StrategyRandom, "":
+		return &RandomStrategy{rand: source}
+	case models.StrategyRoundRobin:
+		return NewRoundRobinStrategy()
+	case models.StrategyLeastLoaded:
+		return &LeastLoadedStrategy{rand: source}
+	case models.StrategyWeighted:
+		return &WeightedStrategy{rand: source}
+	}
+
+	return nil
+}
+
+func IsKnownStrategy(name string) bool {
+	switch name {
+	case models.StrategyRandom, models.StrategyRoundRobin, models.StrategyLeastLoaded, models.StrategyWeighted:
+		return true
+	}
+	return false
+}
+
+type RandomStrategy struct {
+	rand *lockedRand
+}
+
+func (s *RandomStrategy) Select(_ string, candidates []string, _ map[string]int, count int) []string {
+	shuffled := append([]string(nil), candidates...)
+	s.rand.Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+
+	return shuffled[:limit(count, len(shuffled))]
+}
+
+type RoundRobinStrategy struct {
+	mu   sync.Mutex
+	last map[string]string
+}
+
+func NewRoundRobinStrategy() *RoundRobinStrategy {
+	return &RoundRobinStrategy{last: make(map[string]string)}
+}
+
+func (s *RoundRobinStrategy) Select(teamName string, candidates []string, _ map[string]int, count int) []string {
+	n := limit(count, len(candidates))
+	if n == 0 {
+		return []string{}
+	}
+
+	ordered := append([]string(nil), candidates...)
+	sort.Strings(ordered)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	start := sort.SearchStrings(ordered, s.last[teamName])
+	if start < len(ordered) && ordered[start] == s.last[teamName] {
+		start++
+	}
+
+	selected := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		selected = append(selected, ordered[(start+i)%len(ordered)])
+	}
+	s.last[teamName] = selected[len(selected)-1]
+
+	return selected
+}
+
+type LeastLoadedStrategy struct {
+	rand *lockedRand
+}
+
+func (s *LeastLoadedStrategy) Select(_ string, candidates []string, loads map[string]int, count int) []string {
+	ordered := append([]string(nil), candidates...)
+	s.rand.Shuffle(len(ordered), func(i, j int) {
+		ordered[i], ordered[j] = ordered[j], ordered[i]
+	})
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return loads[ordered[i]] < loads[ordered[j]]
+	})
+
+	return ordered[:limit(count, len(ordered))]
+}
+
+type WeightedStrategy struct {
+	rand *lockedRand
+}
+
+func (s *WeightedStrategy) Select(_ string, candidates []string, loads map[string]int, count int) []string {
+	remaining := append([]string(nil), candidates...)
+	n := limit(count, len(remaining))
+	selected := make([]string, 0, n)
+
+	for len(selected) < n {
+		total := 0.0
+		for _, userID := range remaining {
+			total += weightFor(loads[userID])
+		}
+
+		target := s.rand.Float64() * total
+		picked := len(remaining) - 1
+		for i, userID := range remaining {
+			target -= weightFor(loads[userID])
+			if target < 0 {
+				picked = i
+				break
+			}
+		}
+
+		selected = append(selected, remaining[picked])
+		remaining = append(remaining[:picked], remaining[picked+1:]...)
+	}
+
+	return selected
+}
+
+func weightFor(openReviews int) float64 {
+	return 1 / float64(1+openReviews)
+}
+
+func limit(count, available int) int {
+	if count < 0 {
+		return 0
+	}
+	if count > available {
+		return available
+	}
+	return count
+}
+
+type lockedRand struct {
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+func newLockedRand() *lockedRand {
+	return &lockedRand{rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (r *lockedRand) Float64() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rand.Float64()
+}
+
+func (r *lockedRand) Shuffle(n int, swap func(i, j int)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rand.Shuffle(n, swap)
+}
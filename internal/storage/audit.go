@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/Chamistery/Test_task/internal/models"
+)
+
+const SystemActor = "system"
+
+type auditActorKey struct{}
+
+type auditReasonKey struct{}
+
+func WithAuditActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, auditActorKey{}, actor)
+}
+
+func WithAuditReason(ctx context.Context, reason string) context.Context {
+	return context.WithValue(ctx, auditReasonKey{}, reason)
+}
+
+// newAuditEvent stamps an event with the caller from ctx. A reason set on ctx
+// overrides the storage default, which only describes the mechanical change.
+func newAuditEvent(ctx context.Context, eventType string, defaultReason string) *models.AuditEvent {
+	actor, _ := ctx.Value(auditActorKey{}).(string)
+	if actor == "" {
+		actor = SystemActor
+	}
+
+	reason, _ := ctx.Value(auditReasonKey{}).(string)
+	if reason == "" {
+		reason = defaultReason
+	}
+
+	return &models.AuditEvent{
+		Type:      eventType,
+		Actor:     actor,
+		Reason:    reason,
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+func activityEvent(ctx context.Context, userID string, teamName string, wasActive bool, isActive bool, defaultReason string) *models.AuditEvent {
+	event := newAuditEvent(ctx, models.AuditUserActivityChanged, defaultReason)
+	event.TeamName = teamName
+	event.UserIDs = []string{userID}
+	event.Before = auditState(map[string]bool{"is_active": wasActive})
+	event.After = auditState(map[string]bool{"is_active": isActive})
+	return event
+}
+
+func reviewersEvent(ctx context.Context, eventType string, prID string, before []string, after []string, defaultReason string) *models.AuditEvent {
+	event := newAuditEvent(ctx, eventType, defaultReason)
+	event.PullRequestID = prID
+	event.Before = auditState(map[string][]string{"assigned_reviewers": before})
+	event.After = auditState(map[string][]string{"assigned_reviewers": after})
+
+	kept := toSet(after)
+	for _, userID := range before {
+		if !kept[userID] {
+			event.UserIDs = append(event.UserIDs, userID)
+		}
+	}
+	previous := toSet(before)
+	for _, userID := range after {
+		if !previous[userID] {
+			event.UserIDs = append(event.UserIDs, userID)
+		}
+	}
+
+	return event
+}
+
+func mergeEvent(ctx context.Context, prID string, status string, force bool) *models.AuditEvent {
+	reason := "pull request merged"
+	if force {
+		reason = "pull request force-merged"
+	}
+
+	event := newAuditEvent(ctx, models.AuditPRMerged, reason)
+	event.PullRequestID = prID
+	event.Before = auditState(map[string]string{"status": status})
+	event.After = auditState(map[string]interface{}{"status": "MERGED", "force_merged": force})
+	return event
+}
+
+func reviewEvent(ctx context.Context, prID string, userID string, before string, after string) *models.AuditEvent {
+	event := newAuditEvent(ctx, models.AuditReviewSubmitted, "review submitted")
+	event.PullRequestID = prID
+	event.UserIDs = []string{userID}
+	event.Before = auditState(map[string]string{"review_state": before})
+	event.After = auditState(map[string]string{"review_state": after})
+	return event
+}
+
+func statusEvent(ctx context.Context, eventType string, prID string, before string, after string, defaultReason string) *models.AuditEvent {
+	event := newAuditEvent(ctx, eventType, defaultReason)
+	event.PullRequestID = prID
+	event.Before = auditState(map[string]string{"status": before})
+	event.After = auditState(map[string]string{"status": after})
+	return event
+}
+
+func readyEvent(ctx context.Context, prID string) *models.AuditEvent {
+	event := newAuditEvent(ctx, models.AuditPRReady, "pull request marked ready for review")
+	event.PullRequestID = prID
+	event.Before = auditState(map[string]bool{"is_draft": true})
+	event.After = auditState(map[string]bool{"is_draft": false})
+	return event
+}
+
+func teamSettingsEvent(ctx context.Context, before *models.TeamSettings, after *models.TeamSettings) *models.AuditEvent {
+	event := newAuditEvent(ctx, models.AuditTeamSettingsUpdated, "team settings updated")
+	event.TeamName = after.TeamName
+	event.Before = auditState(before)
+	event.After = auditState(after)
+	return event
+}
+
+func auditState(v interface{}) json.RawMessage {
+	data, _ := json.Marshal(v)
+	return data
+}
+
+func auditUserIDs(userIDs []string) []string {
+	seen := make(map[string]bool, len(userIDs))
+	unique := make([]string, 0, len(userIDs))
+	for _, userID := range userIDs {
+		if userID != "" && !seen[userID] {
+			seen[userID] = true
+			unique = append(unique, userID)
+		}
+	}
+	sort.Strings(unique)
+	return unique
+}
+
+func auditLimit(limit int) int {
+	if limit <= 0 {
+		return models.DefaultAuditLimit
+	}
+	return min(limit, models.MaxAuditLimit)
+}
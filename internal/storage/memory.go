@@ -0,0 +1,1378 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Chamistery/Test_task/internal/models"
+)
+
+type MemoryStorage struct {
+	mu        sync.RWMutex
+	inTx      bool
+	teams     map[string]*models.TeamSettings
+	users     map[string]*models.User
+	prs       map[string]*models.PullRequest
+	reviewers map[string]map[string]*models.Review
+	history   map[string][]models.ReviewerAssignment
+	accounts  map[string]string
+	inbound   map[string]bool
+	audit     *auditLog
+	outbox    *outboxLog
+	webhooks  *webhookLog
+	apiKeys   *apiKeyLog
+}
+
+type auditLog struct {
+	events []models.AuditEvent
+	nextID int64
+}
+
+type outboxLog struct {
+	events      []models.DomainEvent
+	delivered   map[int64]bool
+	failed      map[int64]bool
+	nextAttempt map[int64]time.Time
+	claimed     map[int64]time.Time
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		teams:     make(map[string]*models.TeamSettings),
+		users:     make(map[string]*models.User),
+		prs:       make(map[string]*models.PullRequest),
+		reviewers: make(map[string]map[string]*models.Review),
+		history:   make(map[string][]models.ReviewerAssignment),
+		accounts:  make(map[string]string),
+		inbound:   make(map[string]bool),
+		audit:     &auditLog{},
+		outbox:    &outboxLog{delivered: make(map[int64]bool), failed: make(map[int64]bool), nextAttempt: make(map[int64]time.Time), claimed: make(map[int64]time.Time)},
+		webhooks:  &webhookLog{claimed: make(map[int64]time.Time)},
+		apiKeys:   &apiKeyLog{hashes: make(map[string]int)},
+	}
+}
+
+func (s *MemoryStorage) Close() error {
+	return nil
+}
+
+// A transaction view shares the maps of its parent and runs while the parent
+// holds the write lock, so its own lock calls are no-ops.
+func (s *MemoryStorage) lock() {
+	if !s.inTx {
+		s.mu.Lock()
+	}
+}
+
+func (s *MemoryStorage) unlock() {
+	if !s.inTx {
+		s.mu.Unlock()
+	}
+}
+
+func (s *MemoryStorage) rlock() {
+	if !s.inTx {
+		s.mu.RLock()
+	}
+}
+
+func (s *MemoryStorage) runlock() {
+	if !s.inTx {
+		s.mu.RUnlock()
+	}
+}
+
+func (s *MemoryStorage) WithinTx(ctx context.Context, fn func(tx Storage) error) error {
+	if s.inTx {
+		return fn(s)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	snapshot := s.snapshotLocked()
+	tx := &MemoryStorage{
+		inTx:      true,
+		teams:     s.teams,
+		users:     s.users,
+		prs:       s.prs,
+		reviewers: s.reviewers,
+		history:   s.history,
+		accounts:  s.accounts,
+		inbound:   s.inbound,
+		audit:     s.audit,
+		outbox:    s.outbox,
+		webhooks:  s.webhooks,
+		apiKeys:   s.apiKeys,
+	}
+
+	if err := fn(tx); err != nil {
+		s.restoreLocked(snapshot)
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		s.restoreLocked(snapshot)
+		return err
+	}
+
+	return nil
+}
+
+type memorySnapshot struct {
+	teams     map[string]models.TeamSettings
+	users     map[string]models.User
+	prs       map[string]models.PullRequest
+	reviewers map[string]map[string]models.Review
+	history   map[string][]models.ReviewerAssignment
+	accounts  map[string]string
+	inbound   map[string]bool
+	auditLen  int
+	outboxLen int
+}
+
+func (s *MemoryStorage) snapshotLocked() *memorySnapshot {
+	snapshot := &memorySnapshot{
+		teams:     make(map[string]models.TeamSettings, len(s.teams)),
+		users:     make(map[string]models.User, len(s.users)),
+		prs:       make(map[string]models.PullRequest, len(s.prs)),
+		reviewers: make(map[string]map[string]models.Review, len(s.reviewers)),
+		history:   make(map[string][]models.ReviewerAssignment, len(s.history)),
+		accounts:  maps.Clone(s.accounts),
+		inbound:   maps.Clone(s.inbound),
+		auditLen:  len(s.audit.events),
+		outboxLen: len(s.outbox.events),
+	}
+
+	for name, team := range s.teams {
+		copied := *team
+		copied.FallbackTeams = append([]string{}, team.FallbackTeams...)
+		snapshot.teams[name] = copied
+	}
+	for id, user := range s.users {
+		snapshot.users[id] = *user
+	}
+	for id, pr := range s.prs {
+		snapshot.prs[id] = *pr
+	}
+	for prID, reviewers := range s.reviewers {
+		copied := make(map[string]models.Review, len(reviewers))
+		for userID, review := range reviewers {
+			copied[userID] = *review
+		}
+		snapshot.reviewers[prID] = copied
+	}
+	for prID, assignments := range s.history {
+		snapshot.history[prID] = slices.Clone(assignments)
+	}
+
+	return snapshot
+}
+
+func (s *MemoryStorage) restoreLocked(snapshot *memorySnapshot) {
+	clear(s.teams)
+	clear(s.users)
+	clear(s.prs)
+	clear(s.reviewers)
+	clear(s.history)
+	clear(s.accounts)
+	clear(s.inbound)
+	s.audit.events = s.audit.events[:snapshot.auditLen]
+	s.outbox.events = s.outbox.events[:snapshot.outboxLen]
+
+	for name, team := range snapshot.teams {
+		s.teams[name] = &team
+	}
+	for id, user := range snapshot.users {
+		s.users[id] = &user
+	}
+	for id, pr := range snapshot.prs {
+		s.prs[id] = &pr
+	}
+	for prID, reviewers := range snapshot.reviewers {
+		restored := make(map[string]*models.Review, len(reviewers))
+		for userID, review := range reviewers {
+			restored[userID] = &review
+		}
+		s.reviewers[prID] = restored
+	}
+	for prID, assignments := range snapshot.history {
+		s.history[prID] = assignments
+	}
+	maps.Copy(s.accounts, snapshot.accounts)
+	maps.Copy(s.inbound, snapshot.inbound)
+}
+
+func (s *MemoryStorage) CreateTeam(ctx context.Context, team *models.Team) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	if _, exists := s.teams[team.TeamName]; exists {
+		return ErrTeamExists
+	}
+	if err := s.checkFallbacksLocked(team.FallbackTeams); err != nil {
+		return err
+	}
+
+	strategy := team.AssignmentStrategy
+	if strategy == "" {
+		strategy = models.StrategyRandom
+	}
+
+	reviewersRequired := team.ReviewersRequired
+	if reviewersRequired == 0 {
+		reviewersRequired = models.DefaultReviewersRequired
+	}
+
+	s.teams[team.TeamName] = &models.TeamSettings{
+		TeamName:           team.TeamName,
+		AssignmentStrategy: strategy,
+		ReviewersRequired:  reviewersRequired,
+		ApprovalsRequired:  team.ApprovalsRequired,
+		FallbackTeams:      append([]string{}, team.FallbackTeams...),
+	}
+
+	for _, member := range team.Members {
+		s.users[member.UserID] = &models.User{
+			UserID:         member.UserID,
+			Username:       member.Username,
+			TeamName:       team.TeamName,
+			IsActive:       member.IsActive,
+			MaxOpenReviews: member.MaxOpenReviews,
+		}
+	}
+
+	created := *team
+	created.AssignmentStrategy = strategy
+	created.ReviewersRequired = reviewersRequired
+
+	event := newAuditEvent(ctx, models.AuditTeamCreated, "team added")
+	event.TeamName = team.TeamName
+	event.After = auditState(created)
+	for _, member := range team.Members {
+		event.UserIDs = append(event.UserIDs, member.UserID)
+	}
+	s.recordLocked(event)
+
+	return nil
+}
+
+func (s *MemoryStorage) GetTeam(ctx context.Context, teamName string) (*models.Team, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.rlock()
+	defer s.runlock()
+
+	settings, exists := s.teams[teamName]
+	if !exists {
+		return nil, nil
+	}
+
+	team := &models.Team{
+		TeamName:           teamName,
+		AssignmentStrategy: settings.AssignmentStrategy,
+		ReviewersRequired:  settings.ReviewersRequired,
+		ApprovalsRequired:  settings.ApprovalsRequired,
+		FallbackTeams:      append([]string{}, settings.FallbackTeams...),
+		Members:            []models.TeamMember{},
+	}
+
+	for _, user := range s.sortedUsersLocked() {
+		if user.TeamName != teamName {
+			continue
+		}
+		team.Members = append(team.Members, models.TeamMember{
+			UserID:         user.UserID,
+			Username:       user.Username,
+			IsActive:       user.IsActive,
+			MaxOpenReviews: user.MaxOpenReviews,
+		})
+	}
+
+	return team, nil
+}
+
+func (s *MemoryStorage) TeamExists(ctx context.Context, teamName string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	s.rlock()
+	defer s.runlock()
+
+	_, exists := s.teams[teamName]
+	return exists, nil
+}
+
+func (s *MemoryStorage) GetTeamSettings(ctx context.Context, teamName string) (*models.TeamSettings, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.rlock()
+	defer s.runlock()
+
+	settings, exists := s.teams[teamName]
+	if !exists {
+		return nil, nil
+	}
+
+	result := *settings
+	result.FallbackTeams = append([]string{}, settings.FallbackTeams...)
+	return &result, nil
+}
+
+func (s *MemoryStorage) UpdateTeamSettings(ctx context.Context, settings *models.TeamSettings) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	current, exists := s.teams[settings.TeamName]
+	if !exists {
+		return sql.ErrNoRows
+	}
+	if err := s.checkFallbacksLocked(settings.FallbackTeams); err != nil {
+		return err
+	}
+
+	before := *current
+	current.AssignmentStrategy = settings.AssignmentStrategy
+	current.ReviewersRequired = settings.ReviewersRequired
+	current.ApprovalsRequired = settings.ApprovalsRequired
+	current.FallbackTeams = append([]string{}, settings.FallbackTeams...)
+
+	after := *current
+	s.recordLocked(teamSettingsEvent(ctx, &before, &after))
+	return nil
+}
+
+func (s *MemoryStorage) UpsertUser(ctx context.Context, user *models.TeamMember, teamName string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	if _, exists := s.teams[teamName]; !exists {
+		return fmt.Errorf("team %s does not exist", teamName)
+	}
+
+	s.users[user.UserID] = &models.User{
+		UserID:         user.UserID,
+		Username:       user.Username,
+		TeamName:       teamName,
+		IsActive:       user.IsActive,
+		MaxOpenReviews: user.MaxOpenReviews,
+	}
+
+	return nil
+}
+
+func (s *MemoryStorage) GetUser(ctx context.Context, userID string) (*models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.rlock()
+	defer s.runlock()
+
+	user, exists := s.users[userID]
+	if !exists {
+		return nil, nil
+	}
+
+	result := *user
+	return &result, nil
+}
+
+func (s *MemoryStorage) UpdateUser(ctx context.Context, user *models.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	current, exists := s.users[user.UserID]
+	if !exists {
+		return sql.ErrNoRows
+	}
+
+	if current.IsActive != user.IsActive {
+		s.recordLocked(activityEvent(ctx, user.UserID, current.TeamName, current.IsActive, user.IsActive, "user updated"))
+	}
+	if current.IsActive && !user.IsActive {
+		s.publishLocked(userDeactivatedEvent(user.UserID, current.TeamName))
+	}
+
+	current.Username = user.Username
+	current.IsActive = user.IsActive
+	current.MaxOpenReviews = user.MaxOpenReviews
+
+	return nil
+}
+
+func (s *MemoryStorage) SetUserIsActive(ctx context.Context, userID string, isActive bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	user, exists := s.users[userID]
+	if !exists {
+		return sql.ErrNoRows
+	}
+
+	if user.IsActive != isActive {
+		s.recordLocked(activityEvent(ctx, userID, user.TeamName, user.IsActive, isActive, "activity changed"))
+	}
+	if user.IsActive && !isActive {
+		s.publishLocked(userDeactivatedEvent(userID, user.TeamName))
+	}
+
+	user.IsActive = isActive
+	return nil
+}
+
+func (s *MemoryStorage) GetUserTeam(ctx context.Context, userID string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	s.rlock()
+	defer s.runlock()
+
+	user, exists := s.users[userID]
+	if !exists {
+		return "", nil
+	}
+	return user.TeamName, nil
+}
+
+func (s *MemoryStorage) LinkExternalAccount(ctx context.Context, provider string, login string, userID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	if _, exists := s.users[userID]; !exists {
+		return fmt.Errorf("user %s does not exist", userID)
+	}
+
+	s.accounts[provider+"/"+externalLogin(login)] = userID
+	return nil
+}
+
+func (s *MemoryStorage) GetExternalAccountUser(ctx context.Context, provider string, login string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	s.rlock()
+	defer s.runlock()
+
+	return s.accounts[provider+"/"+externalLogin(login)], nil
+}
+
+func (s *MemoryStorage) RecordInboundDelivery(ctx context.Context, source string, deliveryID string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	key := source + "/" + deliveryID
+	if s.inbound[key] {
+		return false, nil
+	}
+	s.inbound[key] = true
+	return true, nil
+}
+
+func (s *MemoryStorage) CreatePullRequest(ctx context.Context, pr *models.PullRequest) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	if _, exists := s.prs[pr.PullRequestID]; exists {
+		return ErrPRExists
+	}
+	if _, exists := s.users[pr.AuthorID]; !exists {
+		return fmt.Errorf("author %s does not exist", pr.AuthorID)
+	}
+
+	reviewers := make(map[string]*models.Review, len(pr.AssignedReviewers))
+	for _, reviewerID := range pr.AssignedReviewers {
+		if _, exists := s.users[reviewerID]; !exists {
+			return fmt.Errorf("reviewer %s does not exist", reviewerID)
+		}
+		if _, exists := reviewers[reviewerID]; exists {
+			return fmt.Errorf("reviewer %s assigned twice", reviewerID)
+		}
+		reviewers[reviewerID] = &models.Review{UserID: reviewerID, State: models.ReviewPending}
+	}
+
+	now := time.Now()
+	s.prs[pr.PullRequestID] = &models.PullRequest{
+		PullRequestID:     pr.PullRequestID,
+		PullRequestName:   pr.PullRequestName,
+		AuthorID:          pr.AuthorID,
+		Status:            "OPEN",
+		IsDraft:           pr.IsDraft,
+		ReviewersRequired: pr.ReviewersRequired,
+		CreatedAt:         &now,
+	}
+	s.reviewers[pr.PullRequestID] = reviewers
+	s.recordAssignedLocked(pr.PullRequestID, pr.AssignedReviewers, models.AssignmentInitial)
+
+	reason := "reviewers auto-assigned on creation"
+	if pr.IsDraft {
+		reason = "draft created without reviewers"
+	}
+	event := newAuditEvent(ctx, models.AuditPRCreated, reason)
+	event.PullRequestID = pr.PullRequestID
+	event.UserIDs = append([]string{pr.AuthorID}, pr.AssignedReviewers...)
+	event.After = auditState(map[string]interface{}{
+		"status":             "OPEN",
+		"is_draft":           pr.IsDraft,
+		"assigned_reviewers": auditUserIDs(pr.AssignedReviewers),
+	})
+	s.recordLocked(event)
+	s.publishLocked(prCreatedEvents(pr)...)
+
+	pr.CreatedAt = &now
+	pr.Status = "OPEN"
+
+	return nil
+}
+
+func (s *MemoryStorage) GetPullRequest(ctx context.Context, prID string) (*models.PullRequest, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.rlock()
+	defer s.runlock()
+
+	return s.getPullRequestLocked(prID), nil
+}
+
+// Every unit of work holds the store's write lock, so there is no separate
+// row lock to take.
+func (s *MemoryStorage) GetPullRequestForUpdate(ctx context.Context, prID string) (*models.PullRequest, error) {
+	return s.GetPullRequest(ctx, prID)
+}
+
+func (s *MemoryStorage) PRExists(ctx context.Context, prID string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	s.rlock()
+	defer s.runlock()
+
+	_, exists := s.prs[prID]
+	return exists, nil
+}
+
+func (s *MemoryStorage) MergePullRequest(ctx context.Context, prID string, force bool) (*models.PullRequest, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	pr, exists := s.prs[prID]
+	if !exists {
+		return nil, nil
+	}
+
+	if pr.Status == "CLOSED" {
+		return nil, ErrPRClosed
+	}
+
+	if pr.Status != "MERGED" {
+		if !force {
+			if pr.IsDraft {
+				return nil, ErrPRDraft
+			}
+
+			approvalsRequired := 0
+			if author, ok := s.users[pr.AuthorID]; ok {
+				if team, ok := s.teams[author.TeamName]; ok {
+					approvalsRequired = team.ApprovalsRequired
+				}
+			}
+
+			approvals, changesRequested := 0, 0
+			for _, review := range s.reviewers[prID] {
+				switch review.State {
+				case models.ReviewApproved:
+					approvals++
+				case models.ReviewChangesRequested:
+					changesRequested++
+				}
+			}
+
+			if changesRequested > 0 {
+				return nil, fmt.Errorf("%w: %d reviewer(s) requested changes", ErrNotApproved, changesRequested)
+			}
+			if approvals < approvalsRequired {
+				return nil, fmt.Errorf("%w: %d of %d required approvals", ErrNotApproved, approvals, approvalsRequired)
+			}
+		}
+
+		s.recordLocked(mergeEvent(ctx, prID, pr.Status, force))
+		s.publishLocked(prMergedEvent(prID, force))
+
+		now := time.Now()
+		pr.Status = "MERGED"
+		pr.MergedAt = &now
+		pr.ForceMerged = force
+	}
+
+	return s.getPullRequestLocked(prID), nil
+}
+
+func (s *MemoryStorage) ClosePullRequest(ctx context.Context, prID string) (*models.PullRequest, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	pr, exists := s.prs[prID]
+	if !exists {
+		return nil, nil
+	}
+
+	switch pr.Status {
+	case "MERGED":
+		return nil, ErrPRMerged
+	case "OPEN":
+		now := time.Now()
+		pr.Status = "CLOSED"
+		pr.ClosedAt = &now
+		s.recordLocked(statusEvent(ctx, models.AuditPRClosed, prID, "OPEN", "CLOSED", "pull request closed"))
+	}
+
+	return s.getPullRequestLocked(prID), nil
+}
+
+func (s *MemoryStorage) ReopenPullRequest(ctx context.Context, prID string) (*models.PullRequest, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	pr, exists := s.prs[prID]
+	if !exists {
+		return nil, nil
+	}
+
+	switch pr.Status {
+	case "MERGED":
+		return nil, ErrPRMerged
+	case "CLOSED":
+		pr.Status = "OPEN"
+		pr.ClosedAt = nil
+		s.recordLocked(statusEvent(ctx, models.AuditPRReopened, prID, "CLOSED", "OPEN", "pull request reopened"))
+	}
+
+	return s.getPullRequestLocked(prID), nil
+}
+
+func (s *MemoryStorage) MarkPullRequestReady(ctx context.Context, prID string) (*models.PullRequest, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	pr, exists := s.prs[prID]
+	if !exists {
+		return nil, nil
+	}
+
+	switch pr.Status {
+	case "MERGED":
+		return nil, ErrPRMerged
+	case "CLOSED":
+		return nil, ErrPRClosed
+	}
+
+	if pr.IsDraft {
+		pr.IsDraft = false
+		s.recordLocked(readyEvent(ctx, prID))
+	}
+	return s.getPullRequestLocked(prID), nil
+}
+
+func (s *MemoryStorage) GetPRsByReviewer(ctx context.Context, userID string) ([]models.PullRequestShort, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.rlock()
+	defer s.runlock()
+
+	prs := []models.PullRequestShort{}
+	for _, prID := range s.sortedPRIDsLocked() {
+		review, assigned := s.reviewers[prID][userID]
+		if !assigned {
+			continue
+		}
+
+		pr := s.prs[prID]
+		prs = append(prs, models.PullRequestShort{
+			PullRequestID:   pr.PullRequestID,
+			PullRequestName: pr.PullRequestName,
+			AuthorID:        pr.AuthorID,
+			Status:          pr.Status,
+			ReviewState:     review.State,
+			NeedsAction:     pr.Status == "OPEN" && review.State == models.ReviewPending,
+		})
+	}
+
+	return prs, nil
+}
+
+func (s *MemoryStorage) IsReviewerAssigned(ctx context.Context, prID string, userID string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	s.rlock()
+	defer s.runlock()
+
+	_, assigned := s.reviewers[prID][userID]
+	return assigned, nil
+}
+
+func (s *MemoryStorage) ReassignReviewer(ctx context.Context, prID string, oldUserID string, newUserID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	if _, assigned := s.reviewers[prID][oldUserID]; !assigned {
+		return ErrNotAssigned
+	}
+
+	return s.replaceAndRecordLocked(ctx, models.AuditReviewerReassigned, prID, []string{oldUserID}, []string{newUserID}, models.AssignmentManualReassign, "reviewer reassigned")
+}
+
+func (s *MemoryStorage) ReplaceReviewers(ctx context.Context, prID string, removeIDs []string, addIDs []string, reason string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	return s.replaceAndRecordLocked(ctx, models.AuditReviewersReplaced, prID, removeIDs, addIDs, reason, "reviewers updated")
+}
+
+func (s *MemoryStorage) SubmitReview(ctx context.Context, prID string, userID string, state string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	review, assigned := s.reviewers[prID][userID]
+	if !assigned {
+		return sql.ErrNoRows
+	}
+
+	s.recordLocked(reviewEvent(ctx, prID, userID, review.State, state))
+	s.publishLocked(reviewSubmittedEvent(prID, userID, state))
+
+	now := time.Now()
+	review.State = state
+	review.SubmittedAt = &now
+
+	return nil
+}
+
+func (s *MemoryStorage) GetActiveCandidates(ctx context.Context, teamName string, excludeIDs []string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.rlock()
+	defer s.runlock()
+
+	return s.activeCandidatesLocked(teamName, excludeIDs), nil
+}
+
+func (s *MemoryStorage) CountSaturatedCandidates(ctx context.Context, teamName string, excludeIDs []string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	s.rlock()
+	defer s.runlock()
+
+	excluded := toSet(excludeIDs)
+	count := 0
+	for _, user := range s.users {
+		if user.TeamName != teamName || !user.IsActive || excluded[user.UserID] {
+			continue
+		}
+		if user.MaxOpenReviews > 0 && s.openReviewCountLocked(user.UserID) >= user.MaxOpenReviews {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (s *MemoryStorage) GetOpenReviewCounts(ctx context.Context, userIDs []string) (map[string]int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.rlock()
+	defer s.runlock()
+
+	return s.openReviewCountsLocked(userIDs), nil
+}
+
+func (s *MemoryStorage) GetStatistics(ctx context.Context) (*models.Statistics, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.rlock()
+	defer s.runlock()
+
+	stats := &models.Statistics{
+		ReviewerAssignments: make(map[string]int),
+		PRsByAuthor:         make(map[string]int),
+	}
+
+	assignments := make(map[string]int)
+	authored := make(map[string]int)
+	totalReviewers := 0
+
+	for prID, pr := range s.prs {
+		stats.TotalPRs++
+		switch {
+		case pr.Status == "OPEN" && pr.IsDraft:
+			stats.DraftPRs++
+		case pr.Status == "OPEN":
+			stats.OpenPRs++
+		case pr.Status == "MERGED":
+			stats.MergedPRs++
+		case pr.Status == "CLOSED":
+			stats.ClosedPRs++
+		}
+
+		authored[pr.AuthorID]++
+		totalReviewers += len(s.reviewers[prID])
+		for _, assignment := range s.history[prID] {
+			assignments[assignment.UserID]++
+		}
+	}
+
+	for userID, count := range assignments {
+		if user, ok := s.users[userID]; ok {
+			stats.ReviewerAssignments[user.Username] = count
+		}
+	}
+	for userID, count := range authored {
+		if user, ok := s.users[userID]; ok {
+			stats.PRsByAuthor[user.Username] = count
+		}
+	}
+
+	if stats.TotalPRs > 0 {
+		stats.AverageReviewersPerPR = float64(totalReviewers) / float64(stats.TotalPRs)
+	}
+
+	return stats, nil
+}
+
+func (s *MemoryStorage) BulkDeactivateTeamMembers(ctx context.Context, teamName string, pickReviewers ReviewerPicker) (int, int, error) {
+	var deactivatedCount, reassignedCount int
+	err := s.WithinTx(ctx, func(unit Storage) error {
+		var err error
+		deactivatedCount, reassignedCount, err = unit.(*MemoryStorage).bulkDeactivate(ctx, teamName, pickReviewers)
+		return err
+	})
+	return deactivatedCount, reassignedCount, err
+}
+
+// bulkDeactivate runs on the transaction view, whose lock helpers are no-ops,
+// so the picker can read through the Storage interface.
+func (s *MemoryStorage) bulkDeactivate(ctx context.Context, teamName string, pickReviewers ReviewerPicker) (int, int, error) {
+	deactivated := make(map[string]bool)
+	for _, user := range s.users {
+		if user.TeamName == teamName && user.IsActive {
+			deactivated[user.UserID] = true
+		}
+	}
+
+	if len(deactivated) == 0 {
+		return 0, 0, nil
+	}
+
+	userIDs := make([]string, 0, len(deactivated))
+	for userID := range deactivated {
+		s.users[userID].IsActive = false
+		userIDs = append(userIDs, userID)
+	}
+	userIDs = auditUserIDs(userIDs)
+
+	reason := "team " + teamName + " deactivated"
+	for _, userID := range userIDs {
+		s.recordLocked(activityEvent(ctx, userID, teamName, true, false, reason))
+		s.publishLocked(userDeactivatedEvent(userID, teamName))
+	}
+
+	reassignedCount := 0
+
+	for _, prID := range s.sortedPRIDsLocked() {
+		pr := s.prs[prID]
+		if pr.Status != "OPEN" {
+			continue
+		}
+
+		currentReviewers := sortedKeys(s.reviewers[prID])
+
+		var toReplace []string
+		for _, revID := range currentReviewers {
+			if deactivated[revID] {
+				toReplace = append(toReplace, revID)
+			}
+		}
+
+		if len(toReplace) == 0 {
+			continue
+		}
+
+		author, ok := s.users[pr.AuthorID]
+		if !ok {
+			continue
+		}
+		team, ok := s.teams[author.TeamName]
+		if !ok {
+			continue
+		}
+
+		reviewersRequired := team.ReviewersRequired
+		if pr.ReviewersRequired > 0 {
+			reviewersRequired = pr.ReviewersRequired
+		}
+
+		remaining := len(currentReviewers) - len(toReplace)
+		needed := reviewersRequired - remaining
+
+		var selected []string
+		if needed > 0 {
+			var err error
+			selected, err = pickReviewers(ctx, s, &models.PullRequest{PullRequestID: prID, AuthorID: pr.AuthorID, AssignedReviewers: currentReviewers}, needed)
+			if err != nil {
+				return 0, 0, err
+			}
+		}
+
+		var removeIDs []string
+		for i, oldRevID := range toReplace {
+			if i >= len(selected) && remaining+len(selected) < reviewersRequired {
+				break
+			}
+			removeIDs = append(removeIDs, oldRevID)
+		}
+
+		if len(removeIDs) == 0 && len(selected) == 0 {
+			continue
+		}
+		if err := s.replaceAndRecordLocked(ctx, models.AuditReviewersReplaced, prID, removeIDs, selected, models.AssignmentDeactivation, reason); err != nil {
+			return 0, 0, err
+		}
+
+		if len(removeIDs) > 0 {
+			reassignedCount++
+		}
+	}
+
+	event := newAuditEvent(ctx, models.AuditTeamDeactivated, reason)
+	event.TeamName = teamName
+	event.UserIDs = userIDs
+	event.After = auditState(map[string]int{"deactivated_users": len(userIDs), "reassigned_prs": reassignedCount})
+	s.recordLocked(event)
+	s.publishLocked(teamDeactivatedEvent(teamName, userIDs, reassignedCount))
+
+	return len(deactivated), reassignedCount, nil
+}
+
+func (s *MemoryStorage) GetAuditEvents(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.rlock()
+	defer s.runlock()
+
+	limit := auditLimit(filter.Limit)
+	events := []models.AuditEvent{}
+	for _, event := range s.audit.events {
+		if len(events) == limit {
+			break
+		}
+
+		switch {
+		case event.ID <= filter.AfterID,
+			filter.PullRequestID != "" && event.PullRequestID != filter.PullRequestID,
+			filter.TeamName != "" && event.TeamName != filter.TeamName,
+			filter.UserID != "" && !slices.Contains(event.UserIDs, filter.UserID),
+			filter.Since != nil && event.CreatedAt.Before(*filter.Since),
+			filter.Until != nil && !event.CreatedAt.Before(*filter.Until):
+			continue
+		}
+
+		event.UserIDs = slices.Clone(event.UserIDs)
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+func (s *MemoryStorage) GetReviewerHistory(ctx context.Context, prID string) ([]models.ReviewerAssignment, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.rlock()
+	defer s.runlock()
+
+	history := append([]models.ReviewerAssignment{}, s.history[prID]...)
+	return history, nil
+}
+
+func (s *MemoryStorage) recordAssignedLocked(prID string, userIDs []string, reason string) {
+	now := time.Now().UTC()
+	for _, userID := range userIDs {
+		s.history[prID] = append(s.history[prID], models.ReviewerAssignment{
+			UserID:       userID,
+			AssignedAt:   now,
+			AssignReason: reason,
+		})
+	}
+}
+
+func (s *MemoryStorage) recordLocked(event *models.AuditEvent) {
+	if event.TeamName == "" {
+		if pr, ok := s.prs[event.PullRequestID]; ok {
+			if author, ok := s.users[pr.AuthorID]; ok {
+				event.TeamName = author.TeamName
+			}
+		}
+	}
+
+	s.audit.nextID++
+	event.ID = s.audit.nextID
+	event.UserIDs = auditUserIDs(event.UserIDs)
+	s.audit.events = append(s.audit.events, *event)
+}
+
+func (s *MemoryStorage) replaceAndRecordLocked(ctx context.Context, eventType string, prID string, removeIDs []string, addIDs []string, assignmentReason string, defaultReason string) error {
+	before := sortedKeys(s.reviewers[prID])
+	if err := s.replaceReviewersLocked(prID, removeIDs, addIDs); err != nil {
+		return err
+	}
+
+	unassigned := toSet(removeIDs)
+	now := time.Now().UTC()
+	for i := range s.history[prID] {
+		assignment := &s.history[prID][i]
+		if assignment.UnassignedAt == nil && unassigned[assignment.UserID] {
+			assignment.UnassignedAt = &now
+			assignment.UnassignReason = assignmentReason
+		}
+	}
+	s.recordAssignedLocked(prID, addIDs, assignmentReason)
+
+	s.recordLocked(reviewersEvent(ctx, eventType, prID, before, sortedKeys(s.reviewers[prID]), defaultReason))
+	s.publishLocked(reviewerEvents(prID, removeIDs, addIDs, assignmentReason)...)
+	return nil
+}
+
+func (s *MemoryStorage) publishLocked(events ...*models.DomainEvent) {
+	for _, event := range events {
+		event.ID = int64(len(s.outbox.events)) + 1
+		s.outbox.events = append(s.outbox.events, *event)
+	}
+}
+
+func (s *MemoryStorage) GetPendingEvents(ctx context.Context, limit int) ([]models.DomainEvent, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.rlock()
+	defer s.runlock()
+
+	limit = outboxLimit(limit)
+	events := []models.DomainEvent{}
+	for _, event := range s.outbox.events {
+		if len(events) == limit {
+			break
+		}
+		if !s.outbox.delivered[event.ID] && !s.outbox.failed[event.ID] {
+			events = append(events, event)
+		}
+	}
+
+	return events, nil
+}
+
+func (s *MemoryStorage) ClaimDueEvents(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]models.DomainEvent, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	limit = outboxLimit(limit)
+	events := []models.DomainEvent{}
+	waiting := make(map[string]bool)
+	for _, event := range s.outbox.events {
+		if len(events) == limit {
+			break
+		}
+		if s.outbox.delivered[event.ID] || s.outbox.failed[event.ID] || waiting[event.AggregateID] {
+			continue
+		}
+
+		next, retrying := s.outbox.nextAttempt[event.ID]
+		until, claimed := s.outbox.claimed[event.ID]
+		if (retrying && next.After(now)) || (claimed && until.After(now)) {
+			waiting[event.AggregateID] = true
+			continue
+		}
+
+		s.outbox.claimed[event.ID] = now.Add(lease)
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+func (s *MemoryStorage) MarkEventDelivered(ctx context.Context, id int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	if id > 0 && id <= int64(len(s.outbox.events)) {
+		s.outbox.events[id-1].Attempts++
+		s.outbox.delivered[id] = true
+		delete(s.outbox.claimed, id)
+	}
+	return nil
+}
+
+func (s *MemoryStorage) MarkEventFailed(ctx context.Context, id int64, lastError string, nextAttemptAt *time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	if id > 0 && id <= int64(len(s.outbox.events)) && !s.outbox.delivered[id] {
+		s.outbox.events[id-1].Attempts++
+		delete(s.outbox.claimed, id)
+		if nextAttemptAt != nil {
+			s.outbox.nextAttempt[id] = *nextAttemptAt
+		} else {
+			s.outbox.failed[id] = true
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStorage) ReleaseEvents(ctx context.Context, ids []int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	for _, id := range ids {
+		delete(s.outbox.claimed, id)
+	}
+	return nil
+}
+
+func (s *MemoryStorage) getPullRequestLocked(prID string) *models.PullRequest {
+	stored, exists := s.prs[prID]
+	if !exists {
+		return nil
+	}
+
+	pr := *stored
+	pr.AssignedReviewers = []string{}
+	pr.Reviews = []models.Review{}
+	for _, reviewerID := range sortedKeys(s.reviewers[prID]) {
+		pr.AssignedReviewers = append(pr.AssignedReviewers, reviewerID)
+		pr.Reviews = append(pr.Reviews, *s.reviewers[prID][reviewerID])
+	}
+
+	return &pr
+}
+
+func (s *MemoryStorage) replaceReviewersLocked(prID string, removeIDs []string, addIDs []string) error {
+	reviewers, exists := s.reviewers[prID]
+	if !exists {
+		return fmt.Errorf("pull request %s does not exist", prID)
+	}
+
+	remaining := make(map[string]bool, len(reviewers))
+	for reviewerID := range reviewers {
+		remaining[reviewerID] = true
+	}
+	for _, reviewerID := range removeIDs {
+		delete(remaining, reviewerID)
+	}
+	for _, reviewerID := range addIDs {
+		if _, ok := s.users[reviewerID]; !ok {
+			return fmt.Errorf("reviewer %s does not exist", reviewerID)
+		}
+		if remaining[reviewerID] {
+			return fmt.Errorf("reviewer %s already assigned to %s", reviewerID, prID)
+		}
+		remaining[reviewerID] = true
+	}
+
+	for _, reviewerID := range removeIDs {
+		delete(reviewers, reviewerID)
+	}
+	for _, reviewerID := range addIDs {
+		reviewers[reviewerID] = &models.Review{UserID: reviewerID, State: models.ReviewPending}
+	}
+
+	return nil
+}
+
+func (s *MemoryStorage) activeCandidatesLocked(teamName string, excludeIDs []string) []string {
+	excluded := toSet(excludeIDs)
+
+	candidates := []string{}
+	for _, user := range s.sortedUsersLocked() {
+		if user.TeamName != teamName || !user.IsActive || excluded[user.UserID] {
+			continue
+		}
+		if user.MaxOpenReviews > 0 && s.openReviewCountLocked(user.UserID) >= user.MaxOpenReviews {
+			continue
+		}
+		candidates = append(candidates, user.UserID)
+	}
+
+	return candidates
+}
+
+func (s *MemoryStorage) openReviewCountsLocked(userIDs []string) map[string]int {
+	counts := make(map[string]int, len(userIDs))
+	for _, userID := range userIDs {
+		counts[userID] = s.openReviewCountLocked(userID)
+	}
+	return counts
+}
+
+func (s *MemoryStorage) openReviewCountLocked(userID string) int {
+	count := 0
+	for prID, reviewers := range s.reviewers {
+		if _, assigned := reviewers[userID]; assigned && s.prs[prID].Status == "OPEN" {
+			count++
+		}
+	}
+	return count
+}
+
+func (s *MemoryStorage) checkFallbacksLocked(fallbackTeams []string) error {
+	for _, fallback := range fallbackTeams {
+		if _, exists := s.teams[fallback]; !exists {
+			return fmt.Errorf("fallback team %s does not exist", fallback)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStorage) sortedUsersLocked() []*models.User {
+	users := make([]*models.User, 0, len(s.users))
+	for _, user := range s.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].UserID < users[j].UserID
+	})
+	return users
+}
+
+func (s *MemoryStorage) sortedPRIDsLocked() []string {
+	ids := make([]string, 0, len(s.prs))
+	for prID := range s.prs {
+		ids = append(ids, prID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func sortedKeys(reviewers map[string]*models.Review) []string {
+	keys := make([]string, 0, len(reviewers))
+	for key := range reviewers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, value := range values {
+		set[value] = true
+	}
+	return set
+}
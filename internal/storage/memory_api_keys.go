@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/Chamistery/Test_task/internal/models"
+)
+
+// API keys are credentials rather than domain data, so transactions do not
+// snapshot them.
+type apiKeyLog struct {
+	keys   []models.APIKey
+	hashes map[string]int
+	nextID int64
+}
+
+func (s *MemoryStorage) CreateAPIKey(ctx context.Context, key *models.APIKey, keyHash string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	if _, exists := s.apiKeys.hashes[keyHash]; exists {
+		return fmt.Errorf("api key hash already exists")
+	}
+	if key.TeamName != "" {
+		if _, exists := s.teams[key.TeamName]; !exists {
+			return fmt.Errorf("team %s does not exist", key.TeamName)
+		}
+	}
+
+	s.apiKeys.nextID++
+	key.ID = s.apiKeys.nextID
+	key.CreatedAt = time.Now().UTC()
+	key.RevokedAt = nil
+
+	s.apiKeys.hashes[keyHash] = len(s.apiKeys.keys)
+	s.apiKeys.keys = append(s.apiKeys.keys, *key)
+	return nil
+}
+
+func (s *MemoryStorage) GetAPIKeyByHash(ctx context.Context, keyHash string) (*models.APIKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.rlock()
+	defer s.runlock()
+
+	index, exists := s.apiKeys.hashes[keyHash]
+	if !exists {
+		return nil, nil
+	}
+
+	key := s.apiKeys.keys[index]
+	return &key, nil
+}
+
+func (s *MemoryStorage) GetAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.rlock()
+	defer s.runlock()
+
+	return append([]models.APIKey{}, s.apiKeys.keys...), nil
+}
+
+func (s *MemoryStorage) RevokeAPIKey(ctx context.Context, id int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	for i := range s.apiKeys.keys {
+		key := &s.apiKeys.keys[i]
+		if key.ID == id && key.RevokedAt == nil {
+			now := time.Now().UTC()
+			key.RevokedAt = &now
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
@@ -0,0 +1,264 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/Chamistery/Test_task/internal/models"
+)
+
+// Webhook state is operational rather than domain data, so transactions do
+// not snapshot it.
+type webhookLog struct {
+	subscriptions []models.WebhookSubscription
+	deliveries    []models.WebhookDelivery
+	claimed       map[int64]time.Time
+	nextSubID     int64
+	nextID        int64
+}
+
+func (s *MemoryStorage) CreateWebhookSubscription(ctx context.Context, subscription *models.WebhookSubscription) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	s.webhooks.nextSubID++
+	subscription.ID = s.webhooks.nextSubID
+	subscription.CreatedAt = time.Now().UTC()
+
+	stored := *subscription
+	stored.Events = append([]string{}, subscription.Events...)
+	s.webhooks.subscriptions = append(s.webhooks.subscriptions, stored)
+	return nil
+}
+
+func (s *MemoryStorage) GetWebhookSubscriptions(ctx context.Context) ([]models.WebhookSubscription, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.rlock()
+	defer s.runlock()
+
+	subscriptions := make([]models.WebhookSubscription, 0, len(s.webhooks.subscriptions))
+	for _, subscription := range s.webhooks.subscriptions {
+		subscription.Events = append([]string{}, subscription.Events...)
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, nil
+}
+
+func (s *MemoryStorage) DeleteWebhookSubscription(ctx context.Context, id int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	index := slices.IndexFunc(s.webhooks.subscriptions, func(subscription models.WebhookSubscription) bool {
+		return subscription.ID == id
+	})
+	if index < 0 {
+		return sql.ErrNoRows
+	}
+
+	s.webhooks.subscriptions = slices.Delete(s.webhooks.subscriptions, index, index+1)
+	s.webhooks.deliveries = slices.DeleteFunc(s.webhooks.deliveries, func(delivery models.WebhookDelivery) bool {
+		return delivery.SubscriptionID == id
+	})
+	return nil
+}
+
+func (s *MemoryStorage) EnqueueWebhookDeliveries(ctx context.Context, event models.DomainEvent) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	payload, err := webhookPayload(event)
+	if err != nil {
+		return 0, err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	now := time.Now().UTC()
+	enqueued := 0
+	for _, subscription := range s.webhooks.subscriptions {
+		if !subscribedTo(subscription, event.Type) {
+			continue
+		}
+
+		exists := slices.ContainsFunc(s.webhooks.deliveries, func(delivery models.WebhookDelivery) bool {
+			return delivery.SubscriptionID == subscription.ID && delivery.EventID == event.ID
+		})
+		if exists {
+			continue
+		}
+
+		next := now
+		s.webhooks.nextID++
+		s.webhooks.deliveries = append(s.webhooks.deliveries, models.WebhookDelivery{
+			ID:             s.webhooks.nextID,
+			SubscriptionID: subscription.ID,
+			EventID:        event.ID,
+			EventType:      event.Type,
+			Status:         models.WebhookPending,
+			NextAttemptAt:  &next,
+			CreatedAt:      now,
+			Payload:        payload,
+		})
+		enqueued++
+	}
+
+	return enqueued, nil
+}
+
+func (s *MemoryStorage) ClaimDueWebhookDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]models.WebhookDelivery, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	var due []models.WebhookDelivery
+	for _, delivery := range s.webhooks.deliveries {
+		if until, claimed := s.webhooks.claimed[delivery.ID]; claimed && until.After(now) {
+			continue
+		}
+		if delivery.Status == models.WebhookPending && delivery.NextAttemptAt != nil && !delivery.NextAttemptAt.After(now) {
+			due = append(due, s.webhookDeliveryLocked(delivery, false))
+		}
+	}
+
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].NextAttemptAt.Before(*due[j].NextAttemptAt)
+	})
+	if limit = outboxLimit(limit); len(due) > limit {
+		due = due[:limit]
+	}
+
+	for _, delivery := range due {
+		s.webhooks.claimed[delivery.ID] = now.Add(lease)
+	}
+	return append([]models.WebhookDelivery{}, due...), nil
+}
+
+func (s *MemoryStorage) GetWebhookDelivery(ctx context.Context, id int64) (*models.WebhookDelivery, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.rlock()
+	defer s.runlock()
+
+	delivery := s.findWebhookDeliveryLocked(id)
+	if delivery == nil {
+		return nil, nil
+	}
+
+	result := s.webhookDeliveryLocked(*delivery, true)
+	return &result, nil
+}
+
+func (s *MemoryStorage) GetWebhookDeliveries(ctx context.Context, subscriptionID int64, limit int) ([]models.WebhookDelivery, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.rlock()
+	defer s.runlock()
+
+	limit = outboxLimit(limit)
+	deliveries := []models.WebhookDelivery{}
+	for i := len(s.webhooks.deliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		if delivery := s.webhooks.deliveries[i]; delivery.SubscriptionID == subscriptionID {
+			deliveries = append(deliveries, s.webhookDeliveryLocked(delivery, true))
+		}
+	}
+	return deliveries, nil
+}
+
+func (s *MemoryStorage) RecordWebhookAttempt(ctx context.Context, id int64, attempt models.WebhookAttempt, status string, nextAttemptAt *time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	delivery := s.findWebhookDeliveryLocked(id)
+	if delivery == nil {
+		return sql.ErrNoRows
+	}
+
+	attempt.AttemptedAt = attempt.AttemptedAt.UTC()
+	delivery.Attempts = append(delivery.Attempts, attempt)
+	delivery.AttemptCount++
+	delivery.Status = status
+	delivery.NextAttemptAt = nil
+	delete(s.webhooks.claimed, id)
+	if nextAttemptAt != nil {
+		next := nextAttemptAt.UTC()
+		delivery.NextAttemptAt = &next
+	}
+	if status == models.WebhookDelivered {
+		delivery.DeliveredAt = &attempt.AttemptedAt
+	}
+	return nil
+}
+
+func (s *MemoryStorage) ScheduleWebhookDelivery(ctx context.Context, id int64, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	delivery := s.findWebhookDeliveryLocked(id)
+	if delivery == nil {
+		return sql.ErrNoRows
+	}
+
+	next := at.UTC()
+	delivery.Status = models.WebhookPending
+	delivery.NextAttemptAt = &next
+	return nil
+}
+
+func (s *MemoryStorage) findWebhookDeliveryLocked(id int64) *models.WebhookDelivery {
+	for i := range s.webhooks.deliveries {
+		if s.webhooks.deliveries[i].ID == id {
+			return &s.webhooks.deliveries[i]
+		}
+	}
+	return nil
+}
+
+// webhookDeliveryLocked returns a copy joined with its subscription's
+// endpoint, matching what the SQL backends select.
+func (s *MemoryStorage) webhookDeliveryLocked(delivery models.WebhookDelivery, withAttempts bool) models.WebhookDelivery {
+	for _, subscription := range s.webhooks.subscriptions {
+		if subscription.ID == delivery.SubscriptionID {
+			delivery.URL = subscription.URL
+			delivery.Secret = subscription.Secret
+		}
+	}
+
+	delivery.Attempts = nil
+	if withAttempts {
+		if stored := s.findWebhookDeliveryLocked(delivery.ID); stored != nil {
+			delivery.Attempts = slices.Clone(stored.Attempts)
+		}
+	}
+	return delivery
+}
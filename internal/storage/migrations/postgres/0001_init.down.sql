@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS pr_reviewers;
+DROP TABLE IF EXISTS pull_requests;
+DROP TABLE IF EXISTS team_fallbacks;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS teams;
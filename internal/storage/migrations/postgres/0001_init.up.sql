@@ -0,0 +1,57 @@
+-- Baseline schema. Statements stay idempotent so databases created before
+-- versioned migrations existed are adopted without changes.
+
+CREATE TABLE IF NOT EXISTS teams (
+	team_name TEXT PRIMARY KEY
+);
+
+ALTER TABLE teams ADD COLUMN IF NOT EXISTS assignment_strategy TEXT NOT NULL DEFAULT 'random';
+ALTER TABLE teams ADD COLUMN IF NOT EXISTS reviewers_required INTEGER NOT NULL DEFAULT 2;
+ALTER TABLE teams ADD COLUMN IF NOT EXISTS approvals_required INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS users (
+	user_id TEXT PRIMARY KEY,
+	username TEXT NOT NULL,
+	team_name TEXT REFERENCES teams(team_name) ON DELETE CASCADE,
+	is_active BOOLEAN DEFAULT true
+);
+
+CREATE TABLE IF NOT EXISTS team_fallbacks (
+	team_name TEXT REFERENCES teams(team_name) ON DELETE CASCADE,
+	fallback_team TEXT REFERENCES teams(team_name) ON DELETE CASCADE,
+	position INTEGER NOT NULL,
+	PRIMARY KEY (team_name, fallback_team)
+);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS max_open_reviews INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS idx_users_team ON users(team_name);
+CREATE INDEX IF NOT EXISTS idx_users_active ON users(is_active);
+
+CREATE TABLE IF NOT EXISTS pull_requests (
+	pull_request_id TEXT PRIMARY KEY,
+	pull_request_name TEXT NOT NULL,
+	author_id TEXT REFERENCES users(user_id),
+	status TEXT DEFAULT 'OPEN',
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	merged_at TIMESTAMP
+);
+
+ALTER TABLE pull_requests ADD COLUMN IF NOT EXISTS reviewers_required INTEGER;
+ALTER TABLE pull_requests ADD COLUMN IF NOT EXISTS force_merged BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE pull_requests ADD COLUMN IF NOT EXISTS closed_at TIMESTAMP;
+ALTER TABLE pull_requests ADD COLUMN IF NOT EXISTS is_draft BOOLEAN NOT NULL DEFAULT false;
+
+CREATE INDEX IF NOT EXISTS idx_pr_status ON pull_requests(status);
+CREATE INDEX IF NOT EXISTS idx_pr_author ON pull_requests(author_id);
+
+CREATE TABLE IF NOT EXISTS pr_reviewers (
+	pull_request_id TEXT REFERENCES pull_requests(pull_request_id) ON DELETE CASCADE,
+	user_id TEXT REFERENCES users(user_id),
+	PRIMARY KEY (pull_request_id, user_id)
+);
+
+ALTER TABLE pr_reviewers ADD COLUMN IF NOT EXISTS review_state TEXT NOT NULL DEFAULT 'PENDING';
+ALTER TABLE pr_reviewers ADD COLUMN IF NOT EXISTS reviewed_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_reviewers_user ON pr_reviewers(user_id);
@@ -0,0 +1,36 @@
+CREATE TABLE audit_events (
+	id BIGSERIAL PRIMARY KEY,
+	event_type TEXT NOT NULL,
+	actor TEXT NOT NULL,
+	reason TEXT NOT NULL DEFAULT '',
+	team_name TEXT,
+	pull_request_id TEXT,
+	before_state JSONB,
+	after_state JSONB,
+	created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE audit_event_users (
+	event_id BIGINT NOT NULL REFERENCES audit_events(id),
+	user_id TEXT NOT NULL,
+	PRIMARY KEY (event_id, user_id)
+);
+
+CREATE INDEX idx_audit_events_pr ON audit_events(pull_request_id);
+CREATE INDEX idx_audit_events_team ON audit_events(team_name);
+CREATE INDEX idx_audit_events_created ON audit_events(created_at);
+CREATE INDEX idx_audit_event_users_user ON audit_event_users(user_id);
+
+CREATE FUNCTION audit_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION '% is append-only', TG_TABLE_NAME;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_events_append_only
+	BEFORE UPDATE OR DELETE ON audit_events
+	FOR EACH ROW EXECUTE FUNCTION audit_append_only();
+
+CREATE TRIGGER audit_event_users_append_only
+	BEFORE UPDATE OR DELETE ON audit_event_users
+	FOR EACH ROW EXECUTE FUNCTION audit_append_only();
@@ -0,0 +1,19 @@
+CREATE TABLE pr_reviewer_history (
+	id BIGSERIAL PRIMARY KEY,
+	pull_request_id TEXT NOT NULL REFERENCES pull_requests(pull_request_id) ON DELETE CASCADE,
+	user_id TEXT NOT NULL REFERENCES users(user_id),
+	assigned_at TIMESTAMP NOT NULL,
+	assign_reason TEXT NOT NULL,
+	unassigned_at TIMESTAMP,
+	unassign_reason TEXT
+);
+
+CREATE INDEX idx_reviewer_history_pr ON pr_reviewer_history(pull_request_id);
+CREATE INDEX idx_reviewer_history_user ON pr_reviewer_history(user_id);
+
+-- Reviewers assigned before history was kept are recorded as initial assignments.
+INSERT INTO pr_reviewer_history (pull_request_id, user_id, assigned_at, assign_reason)
+SELECT prr.pull_request_id, prr.user_id, COALESCE(pr.created_at, CURRENT_TIMESTAMP), 'INITIAL'
+FROM pr_reviewers prr
+JOIN pull_requests pr ON pr.pull_request_id = prr.pull_request_id
+ORDER BY prr.pull_request_id, prr.user_id;
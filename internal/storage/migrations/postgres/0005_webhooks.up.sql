@@ -0,0 +1,34 @@
+CREATE TABLE webhook_subscriptions (
+	id BIGSERIAL PRIMARY KEY,
+	url TEXT NOT NULL,
+	secret TEXT NOT NULL,
+	event_types TEXT NOT NULL DEFAULT '[]',
+	created_at TIMESTAMP NOT NULL
+);
+
+-- Payloads are kept as TEXT so the signed bytes are exactly what gets resent.
+CREATE TABLE webhook_deliveries (
+	id BIGSERIAL PRIMARY KEY,
+	subscription_id BIGINT NOT NULL REFERENCES webhook_subscriptions(id) ON DELETE CASCADE,
+	event_id BIGINT NOT NULL,
+	event_type TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	status TEXT NOT NULL,
+	attempt_count INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMP,
+	created_at TIMESTAMP NOT NULL,
+	delivered_at TIMESTAMP,
+	UNIQUE (subscription_id, event_id)
+);
+
+CREATE INDEX idx_webhook_deliveries_due ON webhook_deliveries(next_attempt_at) WHERE status = 'PENDING';
+
+CREATE TABLE webhook_delivery_attempts (
+	id BIGSERIAL PRIMARY KEY,
+	delivery_id BIGINT NOT NULL REFERENCES webhook_deliveries(id) ON DELETE CASCADE,
+	attempted_at TIMESTAMP NOT NULL,
+	status_code INTEGER,
+	error TEXT
+);
+
+CREATE INDEX idx_webhook_delivery_attempts_delivery ON webhook_delivery_attempts(delivery_id);
@@ -0,0 +1,10 @@
+-- Only the SHA-256 of a key is stored; the key itself is shown once on creation.
+CREATE TABLE api_keys (
+	id BIGSERIAL PRIMARY KEY,
+	name TEXT NOT NULL,
+	key_hash TEXT NOT NULL UNIQUE,
+	role TEXT NOT NULL,
+	team_name TEXT REFERENCES teams(team_name) ON DELETE CASCADE,
+	created_at TIMESTAMP NOT NULL,
+	revoked_at TIMESTAMP
+);
@@ -0,0 +1,3 @@
+-- A dispatcher reserves the events it is delivering until claimed_until, so
+-- replicas polling the same outbox do not send them twice.
+ALTER TABLE outbox_events ADD COLUMN claimed_until TIMESTAMP;
@@ -0,0 +1,3 @@
+-- A deliverer reserves the deliveries it is attempting until claimed_until, so
+-- replicas polling the same queue do not call a subscriber twice.
+ALTER TABLE webhook_deliveries ADD COLUMN claimed_until TIMESTAMP;
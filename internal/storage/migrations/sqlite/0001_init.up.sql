@@ -0,0 +1,50 @@
+CREATE TABLE IF NOT EXISTS teams (
+	team_name TEXT PRIMARY KEY,
+	assignment_strategy TEXT NOT NULL DEFAULT 'random',
+	reviewers_required INTEGER NOT NULL DEFAULT 2,
+	approvals_required INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS users (
+	user_id TEXT PRIMARY KEY,
+	username TEXT NOT NULL,
+	team_name TEXT REFERENCES teams(team_name) ON DELETE CASCADE,
+	is_active BOOLEAN DEFAULT true,
+	max_open_reviews INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS team_fallbacks (
+	team_name TEXT REFERENCES teams(team_name) ON DELETE CASCADE,
+	fallback_team TEXT REFERENCES teams(team_name) ON DELETE CASCADE,
+	position INTEGER NOT NULL,
+	PRIMARY KEY (team_name, fallback_team)
+);
+
+CREATE INDEX IF NOT EXISTS idx_users_team ON users(team_name);
+CREATE INDEX IF NOT EXISTS idx_users_active ON users(is_active);
+
+CREATE TABLE IF NOT EXISTS pull_requests (
+	pull_request_id TEXT PRIMARY KEY,
+	pull_request_name TEXT NOT NULL,
+	author_id TEXT REFERENCES users(user_id),
+	status TEXT DEFAULT 'OPEN',
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	merged_at TIMESTAMP,
+	reviewers_required INTEGER,
+	force_merged BOOLEAN NOT NULL DEFAULT false,
+	closed_at TIMESTAMP,
+	is_draft BOOLEAN NOT NULL DEFAULT false
+);
+
+CREATE INDEX IF NOT EXISTS idx_pr_status ON pull_requests(status);
+CREATE INDEX IF NOT EXISTS idx_pr_author ON pull_requests(author_id);
+
+CREATE TABLE IF NOT EXISTS pr_reviewers (
+	pull_request_id TEXT REFERENCES pull_requests(pull_request_id) ON DELETE CASCADE,
+	user_id TEXT REFERENCES users(user_id),
+	review_state TEXT NOT NULL DEFAULT 'PENDING',
+	reviewed_at TIMESTAMP,
+	PRIMARY KEY (pull_request_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_reviewers_user ON pr_reviewers(user_id);
@@ -0,0 +1,42 @@
+CREATE TABLE audit_events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	event_type TEXT NOT NULL,
+	actor TEXT NOT NULL,
+	reason TEXT NOT NULL DEFAULT '',
+	team_name TEXT,
+	pull_request_id TEXT,
+	before_state TEXT,
+	after_state TEXT,
+	created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE audit_event_users (
+	event_id INTEGER NOT NULL REFERENCES audit_events(id),
+	user_id TEXT NOT NULL,
+	PRIMARY KEY (event_id, user_id)
+);
+
+CREATE INDEX idx_audit_events_pr ON audit_events(pull_request_id);
+CREATE INDEX idx_audit_events_team ON audit_events(team_name);
+CREATE INDEX idx_audit_events_created ON audit_events(created_at);
+CREATE INDEX idx_audit_event_users_user ON audit_event_users(user_id);
+
+CREATE TRIGGER audit_events_no_update BEFORE UPDATE ON audit_events
+BEGIN
+	SELECT RAISE(ABORT, 'audit_events is append-only');
+END;
+
+CREATE TRIGGER audit_events_no_delete BEFORE DELETE ON audit_events
+BEGIN
+	SELECT RAISE(ABORT, 'audit_events is append-only');
+END;
+
+CREATE TRIGGER audit_event_users_no_update BEFORE UPDATE ON audit_event_users
+BEGIN
+	SELECT RAISE(ABORT, 'audit_event_users is append-only');
+END;
+
+CREATE TRIGGER audit_event_users_no_delete BEFORE DELETE ON audit_event_users
+BEGIN
+	SELECT RAISE(ABORT, 'audit_event_users is append-only');
+END;
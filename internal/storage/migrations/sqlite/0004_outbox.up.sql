@@ -0,0 +1,12 @@
+CREATE TABLE outbox_events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	event_type TEXT NOT NULL,
+	aggregate_id TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	occurred_at TIMESTAMP NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT,
+	delivered_at TIMESTAMP
+);
+
+CREATE INDEX idx_outbox_events_pending ON outbox_events(id) WHERE delivered_at IS NULL;
@@ -0,0 +1,34 @@
+CREATE TABLE webhook_subscriptions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	url TEXT NOT NULL,
+	secret TEXT NOT NULL,
+	event_types TEXT NOT NULL DEFAULT '[]',
+	created_at TIMESTAMP NOT NULL
+);
+
+-- Payloads are kept as TEXT so the signed bytes are exactly what gets resent.
+CREATE TABLE webhook_deliveries (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	subscription_id INTEGER NOT NULL REFERENCES webhook_subscriptions(id) ON DELETE CASCADE,
+	event_id INTEGER NOT NULL,
+	event_type TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	status TEXT NOT NULL,
+	attempt_count INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMP,
+	created_at TIMESTAMP NOT NULL,
+	delivered_at TIMESTAMP,
+	UNIQUE (subscription_id, event_id)
+);
+
+CREATE INDEX idx_webhook_deliveries_due ON webhook_deliveries(next_attempt_at) WHERE status = 'PENDING';
+
+CREATE TABLE webhook_delivery_attempts (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	delivery_id INTEGER NOT NULL REFERENCES webhook_deliveries(id) ON DELETE CASCADE,
+	attempted_at TIMESTAMP NOT NULL,
+	status_code INTEGER,
+	error TEXT
+);
+
+CREATE INDEX idx_webhook_delivery_attempts_delivery ON webhook_delivery_attempts(delivery_id);
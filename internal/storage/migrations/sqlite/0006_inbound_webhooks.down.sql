@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS inbound_deliveries;
+DROP TABLE IF EXISTS external_accounts;
@@ -0,0 +1,15 @@
+CREATE TABLE external_accounts (
+	provider TEXT NOT NULL,
+	login TEXT NOT NULL,
+	user_id TEXT NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+	PRIMARY KEY (provider, login)
+);
+
+CREATE INDEX idx_external_accounts_user ON external_accounts(user_id);
+
+CREATE TABLE inbound_deliveries (
+	source TEXT NOT NULL,
+	delivery_id TEXT NOT NULL,
+	received_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (source, delivery_id)
+);
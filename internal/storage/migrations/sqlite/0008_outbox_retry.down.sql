@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_outbox_events_aggregate;
+ALTER TABLE outbox_events DROP COLUMN failed_at;
+ALTER TABLE outbox_events DROP COLUMN next_attempt_at;
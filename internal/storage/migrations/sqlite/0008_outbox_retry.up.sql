@@ -0,0 +1,6 @@
+ALTER TABLE outbox_events ADD COLUMN next_attempt_at TIMESTAMP;
+ALTER TABLE outbox_events ADD COLUMN failed_at TIMESTAMP;
+
+-- Events of one aggregate are delivered in order, so the dispatcher looks up
+-- each aggregate's oldest undelivered event.
+CREATE INDEX idx_outbox_events_aggregate ON outbox_events(aggregate_id, id) WHERE delivered_at IS NULL AND failed_at IS NULL;
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/Chamistery/Test_task/internal/models"
+)
+
+const DefaultOutboxBatch = 100
+
+func newDomainEvent(eventType string, aggregateID string, payload interface{}) *models.DomainEvent {
+	data, _ := json.Marshal(payload)
+	return &models.DomainEvent{
+		Type:        eventType,
+		AggregateID: aggregateID,
+		Payload:     data,
+		OccurredAt:  time.Now().UTC(),
+	}
+}
+
+func prCreatedEvents(pr *models.PullRequest) []*models.DomainEvent {
+	events := []*models.DomainEvent{newDomainEvent(models.EventPRCreated, pr.PullRequestID, models.PRCreatedPayload{
+		PullRequestID:     pr.PullRequestID,
+		PullRequestName:   pr.PullRequestName,
+		AuthorID:          pr.AuthorID,
+		IsDraft:           pr.IsDraft,
+		AssignedReviewers: append([]string{}, pr.AssignedReviewers...),
+	})}
+
+	return append(events, reviewerEvents(pr.PullRequestID, nil, pr.AssignedReviewers, models.AssignmentInitial)...)
+}
+
+// A change that drops reviewers is a single replacement; pure additions are
+// published per reviewer so consumers can notify each of them.
+func reviewerEvents(prID string, removeIDs []string, addIDs []string, reason string) []*models.DomainEvent {
+	if len(removeIDs) > 0 {
+		return []*models.DomainEvent{newDomainEvent(models.EventReviewerReplaced, prID, models.ReviewerReplacedPayload{
+			PullRequestID:  prID,
+			RemovedUserIDs: append([]string{}, removeIDs...),
+			AddedUserIDs:   append([]string{}, addIDs...),
+			Reason:         reason,
+		})}
+	}
+
+	events := make([]*models.DomainEvent, 0, len(addIDs))
+	for _, userID := range addIDs {
+		events = append(events, newDomainEvent(models.EventReviewerAssigned, prID, models.ReviewerAssignedPayload{
+			PullRequestID: prID,
+			UserID:        userID,
+			Reason:        reason,
+		}))
+	}
+	return events
+}
+
+func prMergedEvent(prID string, force bool) *models.DomainEvent {
+	return newDomainEvent(models.EventPRMerged, prID, models.PRMergedPayload{PullRequestID: prID, ForceMerged: force})
+}
+
+func reviewSubmittedEvent(prID string, userID string, state string) *models.DomainEvent {
+	return newDomainEvent(models.EventReviewSubmitted, prID, models.ReviewSubmittedPayload{PullRequestID: prID, UserID: userID, State: state})
+}
+
+func userDeactivatedEvent(userID string, teamName string) *models.DomainEvent {
+	return newDomainEvent(models.EventUserDeactivated, userID, models.UserDeactivatedPayload{UserID: userID, TeamName: teamName})
+}
+
+func teamDeactivatedEvent(teamName string, userIDs []string, reassignedPRs int) *models.DomainEvent {
+	return newDomainEvent(models.EventTeamDeactivated, teamName, models.TeamDeactivatedPayload{
+		TeamName:         teamName,
+		DeactivatedUsers: append([]string{}, userIDs...),
+		ReassignedPRs:    reassignedPRs,
+	})
+}
+
+func outboxLimit(limit int) int {
+	if limit <= 0 {
+		return DefaultOutboxBatch
+	}
+	return limit
+}
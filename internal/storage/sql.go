@@ -0,0 +1,1790 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Chamistery/Test_task/internal/models"
+	"github.com/Chamistery/Test_task/internal/storage/migrations"
+)
+
+type dialect interface {
+	inArray(param string) string
+	array(values []string) interface{}
+	inInt64s(param string) string
+	int64Array(values []int64) interface{}
+	// lockQueue serializes claims on a work queue for the rest of the
+	// transaction; empty when the transaction already does.
+	lockQueue(param string) string
+	forUpdate(tables ...string) string
+	isUniqueViolation(err error) bool
+	isConflict(err error) bool
+}
+
+type executor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+type txn interface {
+	executor
+	Commit() error
+	Rollback() error
+}
+
+type sqlStorage struct {
+	db           *sql.DB
+	tx           *sql.Tx
+	dialect      dialect
+	queryTimeout time.Duration
+}
+
+func (s *sqlStorage) conn() executor {
+	if s.tx != nil {
+		return s.tx
+	}
+	return s.db
+}
+
+func (s *sqlStorage) beginTx(ctx context.Context) (txn, error) {
+	if s.tx != nil {
+		return nestedTx{s.tx}, nil
+	}
+	return s.db.BeginTx(ctx, nil)
+}
+
+// nestedTx lets methods that manage their own transaction run inside a
+// unit of work; the outermost WithinTx decides whether to commit.
+type nestedTx struct {
+	*sql.Tx
+}
+
+func (nestedTx) Commit() error   { return nil }
+func (nestedTx) Rollback() error { return nil }
+
+func (s *sqlStorage) WithinTx(ctx context.Context, fn func(tx Storage) error) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return contextError(ctx, err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(&sqlStorage{db: s.db, tx: tx, dialect: s.dialect, queryTimeout: s.queryTimeout}); err != nil {
+		return err
+	}
+
+	return s.wrapError(ctx, tx.Commit())
+}
+
+// Lock waits that end in a deadlock or serialization failure surface as
+// ErrConflict so callers can report them instead of a generic failure.
+func (s *sqlStorage) wrapError(ctx context.Context, err error) error {
+	err = contextError(ctx, err)
+	if err == nil || ctx.Err() != nil || errors.Is(err, ErrConflict) || !s.dialect.isConflict(err) {
+		return err
+	}
+	return fmt.Errorf("%w: %v", ErrConflict, err)
+}
+
+func (s *sqlStorage) scope(ctx context.Context) (context.Context, func(*error)) {
+	var cancel context.CancelFunc
+	if s.queryTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.queryTimeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+
+	return ctx, func(err *error) {
+		*err = s.wrapError(ctx, *err)
+		cancel()
+	}
+}
+
+func migrateUp(db *sql.DB, driver string) error {
+	migrator, err := migrations.New(db, driver)
+	if err != nil {
+		return err
+	}
+
+	_, err = migrator.Up()
+	return err
+}
+
+func (s *sqlStorage) Close() error {
+	if s.tx != nil {
+		return nil
+	}
+	return s.db.Close()
+}
+
+func (s *sqlStorage) CreateTeam(ctx context.Context, team *models.Team) (err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	tx, err := s.beginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	strategy := team.AssignmentStrategy
+	if strategy == "" {
+		strategy = models.StrategyRandom
+	}
+
+	reviewersRequired := team.ReviewersRequired
+	if reviewersRequired == 0 {
+		reviewersRequired = models.DefaultReviewersRequired
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO teams (team_name, assignment_strategy, reviewers_required, approvals_required) 
+		VALUES ($1, $2, $3, $4)
+	`, team.TeamName, strategy, reviewersRequired, team.ApprovalsRequired)
+	if s.dialect.isUniqueViolation(err) {
+		return ErrTeamExists
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := replaceFallbacksInTx(ctx, tx, team.TeamName, team.FallbackTeams); err != nil {
+		return err
+	}
+
+	for _, member := range team.Members {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO users (user_id, username, team_name, is_active, max_open_reviews) 
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (user_id) DO UPDATE 
+			SET username = EXCLUDED.username, 
+			    team_name = EXCLUDED.team_name,
+			    is_active = EXCLUDED.is_active,
+			    max_open_reviews = EXCLUDED.max_open_reviews
+		`, member.UserID, member.Username, team.TeamName, member.IsActive, member.MaxOpenReviews)
+		if err != nil {
+			return err
+		}
+	}
+
+	created := *team
+	created.AssignmentStrategy = strategy
+	created.ReviewersRequired = reviewersRequired
+
+	event := newAuditEvent(ctx, models.AuditTeamCreated, "team added")
+	event.TeamName = team.TeamName
+	event.After = auditState(created)
+	for _, member := range team.Members {
+		event.UserIDs = append(event.UserIDs, member.UserID)
+	}
+	if err := s.recordEvent(ctx, tx, event); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqlStorage) GetTeam(ctx context.Context, teamName string) (_ *models.Team, err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	settings, err := s.GetTeamSettings(ctx, teamName)
+	if err != nil {
+		return nil, err
+	}
+	if settings == nil {
+		return nil, nil
+	}
+
+	rows, err := s.conn().QueryContext(ctx, `
+		SELECT user_id, username, is_active, max_open_reviews 
+		FROM users 
+		WHERE team_name = $1
+		ORDER BY user_id
+	`, teamName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	team := &models.Team{
+		TeamName:           teamName,
+		AssignmentStrategy: settings.AssignmentStrategy,
+		ReviewersRequired:  settings.ReviewersRequired,
+		ApprovalsRequired:  settings.ApprovalsRequired,
+		FallbackTeams:      settings.FallbackTeams,
+		Members:            []models.TeamMember{},
+	}
+
+	for rows.Next() {
+		var member models.TeamMember
+		if err := rows.Scan(&member.UserID, &member.Username, &member.IsActive, &member.MaxOpenReviews); err != nil {
+			return nil, err
+		}
+		team.Members = append(team.Members, member)
+	}
+
+	return team, nil
+}
+
+func (s *sqlStorage) TeamExists(ctx context.Context, teamName string) (_ bool, err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	var exists bool
+	err = s.conn().QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM teams WHERE team_name = $1)", teamName).Scan(&exists)
+	return exists, err
+}
+
+func (s *sqlStorage) GetTeamSettings(ctx context.Context, teamName string) (_ *models.TeamSettings, err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	return queryTeamSettings(ctx, s.conn(), teamName)
+}
+
+func queryTeamSettings(ctx context.Context, q executor, teamName string) (*models.TeamSettings, error) {
+	var settings models.TeamSettings
+	err := q.QueryRowContext(ctx, `
+		SELECT team_name, assignment_strategy, reviewers_required, approvals_required
+		FROM teams
+		WHERE team_name = $1
+	`, teamName).Scan(&settings.TeamName, &settings.AssignmentStrategy, &settings.ReviewersRequired, &settings.ApprovalsRequired)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.QueryContext(ctx, `
+		SELECT fallback_team
+		FROM team_fallbacks
+		WHERE team_name = $1
+		ORDER BY position
+	`, teamName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	settings.FallbackTeams = []string{}
+	for rows.Next() {
+		var fallback string
+		if err := rows.Scan(&fallback); err != nil {
+			return nil, err
+		}
+		settings.FallbackTeams = append(settings.FallbackTeams, fallback)
+	}
+
+	return &settings, nil
+}
+
+func (s *sqlStorage) UpdateTeamSettings(ctx context.Context, settings *models.TeamSettings) (err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	tx, err := s.beginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var teamName string
+	err = tx.QueryRowContext(ctx, "SELECT team_name FROM teams WHERE team_name = $1 "+s.dialect.forUpdate(), settings.TeamName).Scan(&teamName)
+	if err != nil {
+		return err
+	}
+
+	before, err := queryTeamSettings(ctx, tx, settings.TeamName)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE teams 
+		SET assignment_strategy = $1, reviewers_required = $2, approvals_required = $3 
+		WHERE team_name = $4
+	`, settings.AssignmentStrategy, settings.ReviewersRequired, settings.ApprovalsRequired, settings.TeamName)
+	if err != nil {
+		return err
+	}
+
+	if err := replaceFallbacksInTx(ctx, tx, settings.TeamName, settings.FallbackTeams); err != nil {
+		return err
+	}
+
+	after, err := queryTeamSettings(ctx, tx, settings.TeamName)
+	if err != nil {
+		return err
+	}
+	if err := s.recordEvent(ctx, tx, teamSettingsEvent(ctx, before, after)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func replaceFallbacksInTx(ctx context.Context, tx executor, teamName string, fallbackTeams []string) error {
+	_, err := tx.ExecContext(ctx, "DELETE FROM team_fallbacks WHERE team_name = $1", teamName)
+	if err != nil {
+		return err
+	}
+
+	for position, fallback := range fallbackTeams {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO team_fallbacks (team_name, fallback_team, position) 
+			VALUES ($1, $2, $3)
+		`, teamName, fallback, position)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *sqlStorage) UpsertUser(ctx context.Context, user *models.TeamMember, teamName string) (err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	_, err = s.conn().ExecContext(ctx, `
+		INSERT INTO users (user_id, username, team_name, is_active, max_open_reviews) 
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id) DO UPDATE 
+		SET username = EXCLUDED.username, 
+		    team_name = EXCLUDED.team_name,
+		    is_active = EXCLUDED.is_active,
+		    max_open_reviews = EXCLUDED.max_open_reviews
+	`, user.UserID, user.Username, teamName, user.IsActive, user.MaxOpenReviews)
+	return err
+}
+
+func (s *sqlStorage) GetUser(ctx context.Context, userID string) (_ *models.User, err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	var user models.User
+	err = s.conn().QueryRowContext(ctx, `
+		SELECT user_id, username, team_name, is_active, max_open_reviews 
+		FROM users 
+		WHERE user_id = $1
+	`, userID).Scan(&user.UserID, &user.Username, &user.TeamName, &user.IsActive, &user.MaxOpenReviews)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (s *sqlStorage) UpdateUser(ctx context.Context, user *models.User) (err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	tx, err := s.beginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var wasActive bool
+	var teamName sql.NullString
+	err = tx.QueryRowContext(ctx, "SELECT is_active, team_name FROM users WHERE user_id = $1 "+s.dialect.forUpdate(), user.UserID).Scan(&wasActive, &teamName)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE users 
+		SET username = $1, is_active = $2, max_open_reviews = $3 
+		WHERE user_id = $4
+	`, user.Username, user.IsActive, user.MaxOpenReviews, user.UserID)
+	if err != nil {
+		return err
+	}
+
+	if wasActive != user.IsActive {
+		if err := s.recordEvent(ctx, tx, activityEvent(ctx, user.UserID, teamName.String, wasActive, user.IsActive, "user updated")); err != nil {
+			return err
+		}
+	}
+	if wasActive && !user.IsActive {
+		if err := s.publish(ctx, tx, userDeactivatedEvent(user.UserID, teamName.String)); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqlStorage) SetUserIsActive(ctx context.Context, userID string, isActive bool) (err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	tx, err := s.beginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var wasActive bool
+	var teamName sql.NullString
+	err = tx.QueryRowContext(ctx, "SELECT is_active, team_name FROM users WHERE user_id = $1 "+s.dialect.forUpdate(), userID).Scan(&wasActive, &teamName)
+	if err != nil {
+		return err
+	}
+	if wasActive == isActive {
+		return nil
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE users SET is_active = $1 WHERE user_id = $2", isActive, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.recordEvent(ctx, tx, activityEvent(ctx, userID, teamName.String, wasActive, isActive, "activity changed")); err != nil {
+		return err
+	}
+	if !isActive {
+		if err := s.publish(ctx, tx, userDeactivatedEvent(userID, teamName.String)); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqlStorage) GetUserTeam(ctx context.Context, userID string) (_ string, err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	var teamName string
+	err = s.conn().QueryRowContext(ctx, "SELECT team_name FROM users WHERE user_id = $1", userID).Scan(&teamName)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return teamName, err
+}
+
+func (s *sqlStorage) LinkExternalAccount(ctx context.Context, provider string, login string, userID string) (err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	_, err = s.conn().ExecContext(ctx, `
+		INSERT INTO external_accounts (provider, login, user_id)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (provider, login) DO UPDATE SET user_id = EXCLUDED.user_id
+	`, provider, externalLogin(login), userID)
+	return err
+}
+
+func (s *sqlStorage) GetExternalAccountUser(ctx context.Context, provider string, login string) (_ string, err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	var userID string
+	err = s.conn().QueryRowContext(ctx, "SELECT user_id FROM external_accounts WHERE provider = $1 AND login = $2", provider, externalLogin(login)).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return userID, err
+}
+
+func (s *sqlStorage) CreatePullRequest(ctx context.Context, pr *models.PullRequest) (err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	tx, err := s.beginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO pull_requests (pull_request_id, pull_request_name, author_id, status, created_at, reviewers_required, is_draft) 
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, pr.PullRequestID, pr.PullRequestName, pr.AuthorID, "OPEN", now, nullableInt(pr.ReviewersRequired), pr.IsDraft)
+	if s.dialect.isUniqueViolation(err) {
+		return ErrPRExists
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, reviewerID := range pr.AssignedReviewers {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO pr_reviewers (pull_request_id, user_id) 
+			VALUES ($1, $2)
+		`, pr.PullRequestID, reviewerID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := s.recordAssigned(ctx, tx, pr.PullRequestID, pr.AssignedReviewers, models.AssignmentInitial); err != nil {
+		return err
+	}
+
+	reason := "reviewers auto-assigned on creation"
+	if pr.IsDraft {
+		reason = "draft created without reviewers"
+	}
+	event := newAuditEvent(ctx, models.AuditPRCreated, reason)
+	event.PullRequestID = pr.PullRequestID
+	event.UserIDs = append([]string{pr.AuthorID}, pr.AssignedReviewers...)
+	event.After = auditState(map[string]interface{}{
+		"status":             "OPEN",
+		"is_draft":           pr.IsDraft,
+		"assigned_reviewers": auditUserIDs(pr.AssignedReviewers),
+	})
+	if err := s.recordEvent(ctx, tx, event); err != nil {
+		return err
+	}
+	if err := s.publish(ctx, tx, prCreatedEvents(pr)...); err != nil {
+		return err
+	}
+
+	pr.CreatedAt = &now
+	pr.Status = "OPEN"
+
+	return tx.Commit()
+}
+
+func (s *sqlStorage) GetPullRequest(ctx context.Context, prID string) (_ *models.PullRequest, err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	var pr models.PullRequest
+	var createdAt time.Time
+	var mergedAt, closedAt sql.NullTime
+	var reviewersRequired sql.NullInt64
+
+	err = s.conn().QueryRowContext(ctx, `
+		SELECT pull_request_id, pull_request_name, author_id, status, is_draft, created_at, merged_at, closed_at,
+		       reviewers_required, force_merged
+		FROM pull_requests 
+		WHERE pull_request_id = $1
+	`, prID).Scan(&pr.PullRequestID, &pr.PullRequestName, &pr.AuthorID, &pr.Status, &pr.IsDraft, &createdAt, &mergedAt, &closedAt,
+		&reviewersRequired, &pr.ForceMerged)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pr.CreatedAt = &createdAt
+	if mergedAt.Valid {
+		pr.MergedAt = &mergedAt.Time
+	}
+	if closedAt.Valid {
+		pr.ClosedAt = &closedAt.Time
+	}
+	if reviewersRequired.Valid {
+		pr.ReviewersRequired = int(reviewersRequired.Int64)
+	}
+
+	rows, err := s.conn().QueryContext(ctx, `
+		SELECT user_id, review_state, reviewed_at 
+		FROM pr_reviewers 
+		WHERE pull_request_id = $1
+		ORDER BY user_id
+	`, prID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pr.AssignedReviewers = []string{}
+	pr.Reviews = []models.Review{}
+	for rows.Next() {
+		var review models.Review
+		var reviewedAt sql.NullTime
+		if err := rows.Scan(&review.UserID, &review.State, &reviewedAt); err != nil {
+			return nil, err
+		}
+		if reviewedAt.Valid {
+			review.SubmittedAt = &reviewedAt.Time
+		}
+		pr.AssignedReviewers = append(pr.AssignedReviewers, review.UserID)
+		pr.Reviews = append(pr.Reviews, review)
+	}
+
+	return &pr, nil
+}
+
+func (s *sqlStorage) GetPullRequestForUpdate(ctx context.Context, prID string) (_ *models.PullRequest, err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	var locked string
+	err = s.conn().QueryRowContext(ctx, "SELECT pull_request_id FROM pull_requests WHERE pull_request_id = $1 "+s.dialect.forUpdate(), prID).Scan(&locked)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetPullRequest(ctx, prID)
+}
+
+func (s *sqlStorage) PRExists(ctx context.Context, prID string) (_ bool, err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	var exists bool
+	err = s.conn().QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM pull_requests WHERE pull_request_id = $1)", prID).Scan(&exists)
+	return exists, err
+}
+
+func (s *sqlStorage) MergePullRequest(ctx context.Context, prID string, force bool) (_ *models.PullRequest, err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	tx, err := s.beginTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var status string
+	var isDraft bool
+	var approvalsRequired int
+	err = tx.QueryRowContext(ctx, `
+		SELECT pr.status, pr.is_draft, COALESCE(t.approvals_required, 0)
+		FROM pull_requests pr
+		LEFT JOIN users u ON u.user_id = pr.author_id
+		LEFT JOIN teams t ON t.team_name = u.team_name
+		WHERE pr.pull_request_id = $1
+		`+s.dialect.forUpdate("pr")+`
+	`, prID).Scan(&status, &isDraft, &approvalsRequired)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if status == "CLOSED" {
+		return nil, ErrPRClosed
+	}
+
+	if status != "MERGED" {
+		if !force {
+			if isDraft {
+				return nil, ErrPRDraft
+			}
+
+			var approvals, changesRequested int
+			err = tx.QueryRowContext(ctx, `
+				SELECT 
+					COUNT(*) FILTER (WHERE review_state = 'APPROVED'),
+					COUNT(*) FILTER (WHERE review_state = 'CHANGES_REQUESTED')
+				FROM pr_reviewers
+				WHERE pull_request_id = $1
+			`, prID).Scan(&approvals, &changesRequested)
+			if err != nil {
+				return nil, err
+			}
+
+			if changesRequested > 0 {
+				return nil, fmt.Errorf("%w: %d reviewer(s) requested changes", ErrNotApproved, changesRequested)
+			}
+			if approvals < approvalsRequired {
+				return nil, fmt.Errorf("%w: %d of %d required approvals", ErrNotApproved, approvals, approvalsRequired)
+			}
+		}
+
+		now := time.Now()
+		_, err = tx.ExecContext(ctx, `
+			UPDATE pull_requests 
+			SET status = 'MERGED', merged_at = $1, force_merged = $2 
+			WHERE pull_request_id = $3
+		`, now, force, prID)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := s.recordEvent(ctx, tx, mergeEvent(ctx, prID, status, force)); err != nil {
+			return nil, err
+		}
+		if err := s.publish(ctx, tx, prMergedEvent(prID, force)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return s.GetPullRequest(ctx, prID)
+}
+
+func (s *sqlStorage) ClosePullRequest(ctx context.Context, prID string) (_ *models.PullRequest, err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	tx, err := s.beginTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var status string
+	err = tx.QueryRowContext(ctx, "SELECT status FROM pull_requests WHERE pull_request_id = $1 "+s.dialect.forUpdate(), prID).Scan(&status)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch status {
+	case "MERGED":
+		return nil, ErrPRMerged
+	case "OPEN":
+		_, err = tx.ExecContext(ctx, `
+			UPDATE pull_requests 
+			SET status = 'CLOSED', closed_at = $1 
+			WHERE pull_request_id = $2
+		`, time.Now(), prID)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.recordEvent(ctx, tx, statusEvent(ctx, models.AuditPRClosed, prID, status, "CLOSED", "pull request closed")); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return s.GetPullRequest(ctx, prID)
+}
+
+func (s *sqlStorage) ReopenPullRequest(ctx context.Context, prID string) (_ *models.PullRequest, err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	tx, err := s.beginTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var status string
+	err = tx.QueryRowContext(ctx, "SELECT status FROM pull_requests WHERE pull_request_id = $1 "+s.dialect.forUpdate(), prID).Scan(&status)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch status {
+	case "MERGED":
+		return nil, ErrPRMerged
+	case "CLOSED":
+		_, err = tx.ExecContext(ctx, `
+			UPDATE pull_requests 
+			SET status = 'OPEN', closed_at = NULL 
+			WHERE pull_request_id = $1
+		`, prID)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.recordEvent(ctx, tx, statusEvent(ctx, models.AuditPRReopened, prID, status, "OPEN", "pull request reopened")); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return s.GetPullRequest(ctx, prID)
+}
+
+func (s *sqlStorage) MarkPullRequestReady(ctx context.Context, prID string) (_ *models.PullRequest, err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	tx, err := s.beginTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var status string
+	var isDraft bool
+	err = tx.QueryRowContext(ctx, "SELECT status, is_draft FROM pull_requests WHERE pull_request_id = $1 "+s.dialect.forUpdate(), prID).Scan(&status, &isDraft)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch status {
+	case "MERGED":
+		return nil, ErrPRMerged
+	case "CLOSED":
+		return nil, ErrPRClosed
+	}
+
+	if isDraft {
+		_, err = tx.ExecContext(ctx, "UPDATE pull_requests SET is_draft = false WHERE pull_request_id = $1", prID)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.recordEvent(ctx, tx, readyEvent(ctx, prID)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return s.GetPullRequest(ctx, prID)
+}
+
+func (s *sqlStorage) GetPRsByReviewer(ctx context.Context, userID string) (_ []models.PullRequestShort, err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	rows, err := s.conn().QueryContext(ctx, `
+		SELECT DISTINCT p.pull_request_id, p.pull_request_name, p.author_id, p.status, r.review_state
+		FROM pull_requests p
+		JOIN pr_reviewers r ON p.pull_request_id = r.pull_request_id
+		WHERE r.user_id = $1
+		ORDER BY p.pull_request_id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	prs := []models.PullRequestShort{}
+	for rows.Next() {
+		var pr models.PullRequestShort
+		if err := rows.Scan(&pr.PullRequestID, &pr.PullRequestName, &pr.AuthorID, &pr.Status, &pr.ReviewState); err != nil {
+			return nil, err
+		}
+		pr.NeedsAction = pr.Status == "OPEN" && pr.ReviewState == models.ReviewPending
+		prs = append(prs, pr)
+	}
+
+	return prs, nil
+}
+
+func (s *sqlStorage) IsReviewerAssigned(ctx context.Context, prID string, userID string) (_ bool, err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	var exists bool
+	err = s.conn().QueryRowContext(ctx, `
+		SELECT EXISTS(
+			SELECT 1 FROM pr_reviewers 
+			WHERE pull_request_id = $1 AND user_id = $2
+		)
+	`, prID, userID).Scan(&exists)
+	return exists, err
+}
+
+func (s *sqlStorage) ReassignReviewer(ctx context.Context, prID string, oldUserID string, newUserID string) (err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	tx, err := s.beginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := queryReviewerIDs(ctx, tx, prID)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM pr_reviewers WHERE pull_request_id = $1 AND user_id = $2", prID, oldUserID)
+	if err != nil {
+		return err
+	}
+
+	removed, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if removed == 0 {
+		return ErrNotAssigned
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO pr_reviewers (pull_request_id, user_id) VALUES ($1, $2)", prID, newUserID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.recordUnassigned(ctx, tx, prID, []string{oldUserID}, models.AssignmentManualReassign); err != nil {
+		return err
+	}
+	if err := s.recordAssigned(ctx, tx, prID, []string{newUserID}, models.AssignmentManualReassign); err != nil {
+		return err
+	}
+
+	if err := s.recordReviewerChange(ctx, tx, models.AuditReviewerReassigned, prID, before, "reviewer reassigned"); err != nil {
+		return err
+	}
+	if err := s.publish(ctx, tx, reviewerEvents(prID, []string{oldUserID}, []string{newUserID}, models.AssignmentManualReassign)...); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqlStorage) ReplaceReviewers(ctx context.Context, prID string, removeIDs []string, addIDs []string, reason string) (err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	tx, err := s.beginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := queryReviewerIDs(ctx, tx, prID)
+	if err != nil {
+		return err
+	}
+
+	if len(removeIDs) > 0 {
+		_, err = tx.ExecContext(ctx, "DELETE FROM pr_reviewers WHERE pull_request_id = $1 AND user_id "+s.dialect.inArray("$2"), prID, s.dialect.array(removeIDs))
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, userID := range addIDs {
+		_, err = tx.ExecContext(ctx, "INSERT INTO pr_reviewers (pull_request_id, user_id) VALUES ($1, $2)", prID, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := s.recordUnassigned(ctx, tx, prID, removeIDs, reason); err != nil {
+		return err
+	}
+	if err := s.recordAssigned(ctx, tx, prID, addIDs, reason); err != nil {
+		return err
+	}
+
+	if err := s.recordReviewerChange(ctx, tx, models.AuditReviewersReplaced, prID, before, "reviewers updated"); err != nil {
+		return err
+	}
+	if err := s.publish(ctx, tx, reviewerEvents(prID, removeIDs, addIDs, reason)...); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqlStorage) GetReviewerHistory(ctx context.Context, prID string) (_ []models.ReviewerAssignment, err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	rows, err := s.conn().QueryContext(ctx, `
+		SELECT user_id, assigned_at, assign_reason, unassigned_at, COALESCE(unassign_reason, '')
+		FROM pr_reviewer_history
+		WHERE pull_request_id = $1
+		ORDER BY id
+	`, prID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []models.ReviewerAssignment{}
+	for rows.Next() {
+		var assignment models.ReviewerAssignment
+		var unassignedAt sql.NullTime
+		if err := rows.Scan(&assignment.UserID, &assignment.AssignedAt, &assignment.AssignReason, &unassignedAt, &assignment.UnassignReason); err != nil {
+			return nil, err
+		}
+		assignment.AssignedAt = assignment.AssignedAt.UTC()
+		if unassignedAt.Valid {
+			unassigned := unassignedAt.Time.UTC()
+			assignment.UnassignedAt = &unassigned
+		}
+		history = append(history, assignment)
+	}
+
+	return history, rows.Err()
+}
+
+func (s *sqlStorage) recordAssigned(ctx context.Context, q executor, prID string, userIDs []string, reason string) error {
+	now := time.Now().UTC()
+	for _, userID := range userIDs {
+		_, err := q.ExecContext(ctx, `
+			INSERT INTO pr_reviewer_history (pull_request_id, user_id, assigned_at, assign_reason)
+			VALUES ($1, $2, $3, $4)
+		`, prID, userID, now, reason)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqlStorage) recordUnassigned(ctx context.Context, q executor, prID string, userIDs []string, reason string) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	_, err := q.ExecContext(ctx, `
+		UPDATE pr_reviewer_history
+		SET unassigned_at = $1, unassign_reason = $2
+		WHERE pull_request_id = $3 AND unassigned_at IS NULL AND user_id `+s.dialect.inArray("$4"),
+		time.Now().UTC(), reason, prID, s.dialect.array(userIDs))
+	return err
+}
+
+func (s *sqlStorage) SubmitReview(ctx context.Context, prID string, userID string, state string) (err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	tx, err := s.beginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var before string
+	err = tx.QueryRowContext(ctx, `
+		SELECT review_state FROM pr_reviewers
+		WHERE pull_request_id = $1 AND user_id = $2
+		`+s.dialect.forUpdate()+`
+	`, prID, userID).Scan(&before)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE pr_reviewers 
+		SET review_state = $1, reviewed_at = $2 
+		WHERE pull_request_id = $3 AND user_id = $4
+	`, state, time.Now(), prID, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.recordEvent(ctx, tx, reviewEvent(ctx, prID, userID, before, state)); err != nil {
+		return err
+	}
+	if err := s.publish(ctx, tx, reviewSubmittedEvent(prID, userID, state)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+const openReviewCountQuery = `
+	SELECT COUNT(*) FROM pr_reviewers prr
+	JOIN pull_requests pr ON pr.pull_request_id = prr.pull_request_id
+	WHERE prr.user_id = u.user_id AND pr.status = 'OPEN'
+`
+
+func (s *sqlStorage) activeCandidatesQuery() string {
+	return `
+	SELECT u.user_id FROM users u
+	WHERE u.team_name = $1 AND u.is_active = true AND NOT (u.user_id ` + s.dialect.inArray("$2") + `)
+	  AND (u.max_open_reviews = 0 OR u.max_open_reviews > (` + openReviewCountQuery + `))
+	ORDER BY u.user_id
+`
+}
+
+// GetActiveCandidates locks the candidates that have a max_open_reviews cap
+// before counting their reviews, so a concurrent assignment in another
+// transaction waits and then counts the reviewers this one inserts. The count
+// runs as its own statement to see them under READ COMMITTED.
+func (s *sqlStorage) GetActiveCandidates(ctx context.Context, teamName string, excludeIDs []string) (_ []string, err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	if lock := s.dialect.forUpdate(); lock != "" {
+		_, err := s.conn().ExecContext(ctx, `
+			SELECT u.user_id FROM users u
+			WHERE u.team_name = $1 AND u.is_active = true AND NOT (u.user_id `+s.dialect.inArray("$2")+`)
+			  AND u.max_open_reviews > 0
+			ORDER BY u.user_id
+		`+lock, teamName, s.dialect.array(excludeIDs))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := s.conn().QueryContext(ctx, s.activeCandidatesQuery(), teamName, s.dialect.array(excludeIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	candidates := []string{}
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, userID)
+	}
+
+	return candidates, nil
+}
+
+func (s *sqlStorage) CountSaturatedCandidates(ctx context.Context, teamName string, excludeIDs []string) (_ int, err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	var count int
+	err = s.conn().QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM users u
+		WHERE u.team_name = $1 AND u.is_active = true AND NOT (u.user_id `+s.dialect.inArray("$2")+`)
+		  AND u.max_open_reviews > 0 AND u.max_open_reviews <= (`+openReviewCountQuery+`)
+	`, teamName, s.dialect.array(excludeIDs)).Scan(&count)
+	return count, err
+}
+
+func (s *sqlStorage) GetOpenReviewCounts(ctx context.Context, userIDs []string) (_ map[string]int, err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	return s.queryOpenReviewCounts(ctx, s.conn(), userIDs)
+}
+
+func (s *sqlStorage) GetStatistics(ctx context.Context) (_ *models.Statistics, err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	stats := &models.Statistics{
+		ReviewerAssignments: make(map[string]int),
+		PRsByAuthor:         make(map[string]int),
+	}
+
+	err = s.conn().QueryRowContext(ctx, "SELECT COUNT(*) FROM pull_requests").Scan(&stats.TotalPRs)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.conn().QueryRowContext(ctx, "SELECT COUNT(*) FROM pull_requests WHERE status = 'OPEN' AND NOT is_draft").Scan(&stats.OpenPRs)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.conn().QueryRowContext(ctx, "SELECT COUNT(*) FROM pull_requests WHERE status = 'OPEN' AND is_draft").Scan(&stats.DraftPRs)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.conn().QueryRowContext(ctx, "SELECT COUNT(*) FROM pull_requests WHERE status = 'MERGED'").Scan(&stats.MergedPRs)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.conn().QueryRowContext(ctx, "SELECT COUNT(*) FROM pull_requests WHERE status = 'CLOSED'").Scan(&stats.ClosedPRs)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.conn().QueryContext(ctx, `
+		SELECT u.username, COUNT(*) as count
+		FROM pr_reviewer_history h
+		JOIN users u ON h.user_id = u.user_id
+		GROUP BY u.user_id, u.username
+		ORDER BY count DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		var count int
+		if err := rows.Scan(&name, &count); err != nil {
+			return nil, err
+		}
+		stats.ReviewerAssignments[name] = count
+	}
+
+	rows, err = s.conn().QueryContext(ctx, `
+		SELECT u.username, COUNT(*) as count
+		FROM pull_requests pr
+		JOIN users u ON pr.author_id = u.user_id
+		GROUP BY u.user_id, u.username
+		ORDER BY count DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		var count int
+		if err := rows.Scan(&name, &count); err != nil {
+			return nil, err
+		}
+		stats.PRsByAuthor[name] = count
+	}
+
+	var totalReviewers int
+	err = s.conn().QueryRowContext(ctx, "SELECT COUNT(*) FROM pr_reviewers").Scan(&totalReviewers)
+	if err != nil {
+		return nil, err
+	}
+
+	if stats.TotalPRs > 0 {
+		stats.AverageReviewersPerPR = float64(totalReviewers) / float64(stats.TotalPRs)
+	}
+
+	return stats, nil
+}
+
+func (s *sqlStorage) BulkDeactivateTeamMembers(ctx context.Context, teamName string, pickReviewers ReviewerPicker) (_ int, _ int, err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	var deactivatedCount, reassignedCount int
+	err = s.WithinTx(ctx, func(unit Storage) error {
+		var err error
+		deactivatedCount, reassignedCount, err = unit.(*sqlStorage).bulkDeactivate(ctx, teamName, pickReviewers)
+		return err
+	})
+	return deactivatedCount, reassignedCount, err
+}
+
+// bulkDeactivate runs on a transaction-bound storage, which is also what the
+// picker reads through.
+func (s *sqlStorage) bulkDeactivate(ctx context.Context, teamName string, pickReviewers ReviewerPicker) (int, int, error) {
+	tx := s.conn()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT user_id FROM users
+		WHERE team_name = $1 AND is_active = true
+	`, teamName)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var userIDs []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	rows.Close()
+
+	if len(userIDs) == 0 {
+		return 0, 0, nil
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE users SET is_active = false WHERE user_id "+s.dialect.inArray("$1"), s.dialect.array(userIDs))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	reason := "team " + teamName + " deactivated"
+	for _, userID := range userIDs {
+		if err := s.recordEvent(ctx, tx, activityEvent(ctx, userID, teamName, true, false, reason)); err != nil {
+			return 0, 0, err
+		}
+		if err := s.publish(ctx, tx, userDeactivatedEvent(userID, teamName)); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	// Lock the affected PRs in a fixed order so a concurrent reassign or merge
+	// either finishes first or sees the replaced reviewers.
+	prRows, err := tx.QueryContext(ctx, `
+		SELECT pr.pull_request_id, pr.author_id, pr.reviewers_required
+		FROM pull_requests pr
+		WHERE pr.status = 'OPEN' AND pr.pull_request_id IN (
+			SELECT prr.pull_request_id FROM pr_reviewers prr
+			WHERE prr.user_id `+s.dialect.inArray("$1")+`
+		)
+		ORDER BY pr.pull_request_id
+		`+s.dialect.forUpdate()+`
+	`, s.dialect.array(userIDs))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	type prInfo struct {
+		id                string
+		authorID          string
+		reviewersRequired sql.NullInt64
+	}
+	var prs []prInfo
+	for prRows.Next() {
+		var pr prInfo
+		if err := prRows.Scan(&pr.id, &pr.authorID, &pr.reviewersRequired); err != nil {
+			prRows.Close()
+			return 0, 0, err
+		}
+		prs = append(prs, pr)
+	}
+	prRows.Close()
+
+	reassignedCount := 0
+
+	for _, pr := range prs {
+		var currentReviewers []string
+		reviewerRows, err := tx.QueryContext(ctx, "SELECT user_id FROM pr_reviewers WHERE pull_request_id = $1", pr.id)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		for reviewerRows.Next() {
+			var revID string
+			if err := reviewerRows.Scan(&revID); err != nil {
+				reviewerRows.Close()
+				return 0, 0, err
+			}
+			currentReviewers = append(currentReviewers, revID)
+		}
+		reviewerRows.Close()
+
+		var toReplace []string
+		for _, revID := range currentReviewers {
+			for _, deactivatedID := range userIDs {
+				if revID == deactivatedID {
+					toReplace = append(toReplace, revID)
+					break
+				}
+			}
+		}
+
+		if len(toReplace) == 0 {
+			continue
+		}
+
+		var reviewersRequired sql.NullInt64
+		err = tx.QueryRowContext(ctx, `
+			SELECT t.reviewers_required
+			FROM users u
+			JOIN teams t ON t.team_name = u.team_name
+			WHERE u.user_id = $1
+		`, pr.authorID).Scan(&reviewersRequired)
+		if err != nil && err != sql.ErrNoRows {
+			return 0, 0, err
+		}
+		if err == sql.ErrNoRows {
+			continue
+		}
+
+		required := int(reviewersRequired.Int64)
+		if pr.reviewersRequired.Valid {
+			required = int(pr.reviewersRequired.Int64)
+		}
+
+		remaining := len(currentReviewers) - len(toReplace)
+		needed := required - remaining
+
+		var selected []string
+		if needed > 0 {
+			selected, err = pickReviewers(ctx, s, &models.PullRequest{PullRequestID: pr.id, AuthorID: pr.authorID, AssignedReviewers: currentReviewers}, needed)
+			if err != nil {
+				return 0, 0, err
+			}
+		}
+
+		var removed []string
+		for i, oldRevID := range toReplace {
+			if i >= len(selected) && remaining+len(selected) < required {
+				break
+			}
+
+			_, err = tx.ExecContext(ctx, "DELETE FROM pr_reviewers WHERE pull_request_id = $1 AND user_id = $2", pr.id, oldRevID)
+			if err != nil {
+				return 0, 0, err
+			}
+			removed = append(removed, oldRevID)
+		}
+		replaced := len(removed)
+
+		for _, newRevID := range selected {
+			_, err = tx.ExecContext(ctx, "INSERT INTO pr_reviewers (pull_request_id, user_id) VALUES ($1, $2)", pr.id, newRevID)
+			if err != nil {
+				return 0, 0, err
+			}
+		}
+
+		if err := s.recordUnassigned(ctx, tx, pr.id, removed, models.AssignmentDeactivation); err != nil {
+			return 0, 0, err
+		}
+		if err := s.recordAssigned(ctx, tx, pr.id, selected, models.AssignmentDeactivation); err != nil {
+			return 0, 0, err
+		}
+
+		if replaced > 0 {
+			reassignedCount++
+		}
+
+		if replaced > 0 || len(selected) > 0 {
+			if err := s.recordReviewerChange(ctx, tx, models.AuditReviewersReplaced, pr.id, auditUserIDs(currentReviewers), reason); err != nil {
+				return 0, 0, err
+			}
+			if err := s.publish(ctx, tx, reviewerEvents(pr.id, removed, selected, models.AssignmentDeactivation)...); err != nil {
+				return 0, 0, err
+			}
+		}
+	}
+
+	event := newAuditEvent(ctx, models.AuditTeamDeactivated, reason)
+	event.TeamName = teamName
+	event.UserIDs = userIDs
+	event.After = auditState(map[string]int{"deactivated_users": len(userIDs), "reassigned_prs": reassignedCount})
+	if err := s.recordEvent(ctx, tx, event); err != nil {
+		return 0, 0, err
+	}
+	if err := s.publish(ctx, tx, teamDeactivatedEvent(teamName, auditUserIDs(userIDs), reassignedCount)); err != nil {
+		return 0, 0, err
+	}
+
+	return len(userIDs), reassignedCount, nil
+}
+
+func (s *sqlStorage) queryOpenReviewCounts(ctx context.Context, q executor, userIDs []string) (map[string]int, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT prr.user_id, COUNT(*)
+		FROM pr_reviewers prr
+		JOIN pull_requests pr ON pr.pull_request_id = prr.pull_request_id
+		WHERE pr.status = 'OPEN' AND prr.user_id `+s.dialect.inArray("$1")+`
+		GROUP BY prr.user_id
+	`, s.dialect.array(userIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int, len(userIDs))
+	for _, userID := range userIDs {
+		counts[userID] = 0
+	}
+	for rows.Next() {
+		var userID string
+		var count int
+		if err := rows.Scan(&userID, &count); err != nil {
+			return nil, err
+		}
+		counts[userID] = count
+	}
+
+	return counts, nil
+}
+
+func (s *sqlStorage) GetAuditEvents(ctx context.Context, filter models.AuditFilter) (_ []models.AuditEvent, err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	var conditions []string
+	var args []interface{}
+	where := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, strings.ReplaceAll(condition, "?", fmt.Sprintf("$%d", len(args))))
+	}
+
+	if filter.PullRequestID != "" {
+		where("e.pull_request_id = ?", filter.PullRequestID)
+	}
+	if filter.TeamName != "" {
+		where("e.team_name = ?", filter.TeamName)
+	}
+	if filter.UserID != "" {
+		where("EXISTS (SELECT 1 FROM audit_event_users au WHERE au.event_id = e.id AND au.user_id = ?)", filter.UserID)
+	}
+	if filter.Since != nil {
+		where("e.created_at >= ?", filter.Since.UTC())
+	}
+	if filter.Until != nil {
+		where("e.created_at < ?", filter.Until.UTC())
+	}
+	if filter.AfterID > 0 {
+		where("e.id > ?", filter.AfterID)
+	}
+
+	query := `
+		SELECT e.id, e.event_type, e.actor, e.reason, e.team_name, e.pull_request_id, e.before_state, e.after_state, e.created_at
+		FROM audit_events e`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	args = append(args, auditLimit(filter.Limit))
+	query += fmt.Sprintf(" ORDER BY e.id LIMIT $%d", len(args))
+
+	rows, err := s.conn().QueryContext(ctx, `
+		SELECT e.*, au.user_id
+		FROM (`+query+`) e
+		LEFT JOIN audit_event_users au ON au.event_id = e.id
+		ORDER BY e.id, au.user_id
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []models.AuditEvent{}
+	for rows.Next() {
+		var event models.AuditEvent
+		var teamName, prID, before, after, userID sql.NullString
+		err := rows.Scan(&event.ID, &event.Type, &event.Actor, &event.Reason, &teamName, &prID, &before, &after, &event.CreatedAt, &userID)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(events) == 0 || events[len(events)-1].ID != event.ID {
+			event.TeamName = teamName.String
+			event.PullRequestID = prID.String
+			if before.Valid {
+				event.Before = json.RawMessage(before.String)
+			}
+			if after.Valid {
+				event.After = json.RawMessage(after.String)
+			}
+			event.CreatedAt = event.CreatedAt.UTC()
+			events = append(events, event)
+		}
+		if userID.Valid {
+			last := &events[len(events)-1]
+			last.UserIDs = append(last.UserIDs, userID.String)
+		}
+	}
+
+	return events, rows.Err()
+}
+
+func (s *sqlStorage) recordEvent(ctx context.Context, q executor, event *models.AuditEvent) error {
+	if event.TeamName == "" && event.PullRequestID != "" {
+		err := q.QueryRowContext(ctx, `
+			SELECT COALESCE(u.team_name, '')
+			FROM pull_requests pr
+			JOIN users u ON u.user_id = pr.author_id
+			WHERE pr.pull_request_id = $1
+		`, event.PullRequestID).Scan(&event.TeamName)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+	}
+
+	err := q.QueryRowContext(ctx, `
+		INSERT INTO audit_events (event_type, actor, reason, team_name, pull_request_id, before_state, after_state, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id
+	`, event.Type, event.Actor, event.Reason, nullableString(event.TeamName), nullableString(event.PullRequestID),
+		nullableString(string(event.Before)), nullableString(string(event.After)), event.CreatedAt).Scan(&event.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, userID := range auditUserIDs(event.UserIDs) {
+		_, err := q.ExecContext(ctx, "INSERT INTO audit_event_users (event_id, user_id) VALUES ($1, $2)", event.ID, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *sqlStorage) recordReviewerChange(ctx context.Context, q executor, eventType string, prID string, before []string, defaultReason string) error {
+	after, err := queryReviewerIDs(ctx, q, prID)
+	if err != nil {
+		return err
+	}
+
+	return s.recordEvent(ctx, q, reviewersEvent(ctx, eventType, prID, before, after, defaultReason))
+}
+
+func (s *sqlStorage) publish(ctx context.Context, q executor, events ...*models.DomainEvent) error {
+	for _, event := range events {
+		err := q.QueryRowContext(ctx, `
+			INSERT INTO outbox_events (event_type, aggregate_id, payload, occurred_at)
+			VALUES ($1, $2, $3, $4)
+			RETURNING id
+		`, event.Type, event.AggregateID, string(event.Payload), event.OccurredAt).Scan(&event.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqlStorage) GetPendingEvents(ctx context.Context, limit int) (_ []models.DomainEvent, err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	return queryEvents(ctx, s.conn(), `
+		SELECT id, event_type, aggregate_id, payload, occurred_at, attempts
+		FROM outbox_events
+		WHERE delivered_at IS NULL AND failed_at IS NULL
+		ORDER BY id
+		LIMIT $1
+	`, outboxLimit(limit))
+}
+
+// ClaimDueEvents takes the queue lock first, so a claim sees every claim
+// committed before it and two dispatchers never split an aggregate's events.
+func (s *sqlStorage) ClaimDueEvents(ctx context.Context, now time.Time, lease time.Duration, limit int) (_ []models.DomainEvent, err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	tx, err := s.beginTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if lock := s.dialect.lockQueue("$1"); lock != "" {
+		if _, err := tx.ExecContext(ctx, lock, "outbox_events"); err != nil {
+			return nil, err
+		}
+	}
+
+	events, err := queryEvents(ctx, tx, `
+		UPDATE outbox_events SET claimed_until = $1
+		WHERE id IN (
+			SELECT o.id FROM outbox_events o
+			WHERE o.delivered_at IS NULL AND o.failed_at IS NULL
+				AND (o.next_attempt_at IS NULL OR o.next_attempt_at <= $2)
+				AND (o.claimed_until IS NULL OR o.claimed_until <= $2)
+				AND NOT EXISTS (
+					SELECT 1 FROM outbox_events e
+					WHERE e.aggregate_id = o.aggregate_id AND e.id < o.id
+						AND e.delivered_at IS NULL AND e.failed_at IS NULL
+						AND (e.next_attempt_at > $2 OR e.claimed_until > $2)
+				)
+			ORDER BY o.id
+			LIMIT $3
+		)
+		RETURNING id, event_type, aggregate_id, payload, occurred_at, attempts
+	`, now.Add(lease).UTC(), now.UTC(), outboxLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+	return events, tx.Commit()
+}
+
+func queryEvents(ctx context.Context, q executor, query string, args ...interface{}) ([]models.DomainEvent, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []models.DomainEvent{}
+	for rows.Next() {
+		var event models.DomainEvent
+		var payload string
+		if err := rows.Scan(&event.ID, &event.Type, &event.AggregateID, &payload, &event.OccurredAt, &event.Attempts); err != nil {
+			return nil, err
+		}
+		event.Payload = json.RawMessage(payload)
+		event.OccurredAt = event.OccurredAt.UTC()
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+func (s *sqlStorage) MarkEventDelivered(ctx context.Context, id int64) (err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	_, err = s.conn().ExecContext(ctx, `
+		UPDATE outbox_events SET delivered_at = $1, attempts = attempts + 1, last_error = NULL, claimed_until = NULL
+		WHERE id = $2 AND delivered_at IS NULL
+	`, time.Now().UTC(), id)
+	return err
+}
+
+func (s *sqlStorage) MarkEventFailed(ctx context.Context, id int64, lastError string, nextAttemptAt *time.Time) (err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	var next, failed sql.NullTime
+	if nextAttemptAt != nil {
+		next = sql.NullTime{Time: nextAttemptAt.UTC(), Valid: true}
+	} else {
+		failed = sql.NullTime{Time: time.Now().UTC(), Valid: true}
+	}
+
+	_, err = s.conn().ExecContext(ctx, `
+		UPDATE outbox_events SET attempts = attempts + 1, last_error = $1, next_attempt_at = $2, failed_at = $3, claimed_until = NULL
+		WHERE id = $4 AND delivered_at IS NULL
+	`, lastError, next, failed, id)
+	return err
+}
+
+func (s *sqlStorage) ReleaseEvents(ctx context.Context, ids []int64) (err error) {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	_, err = s.conn().ExecContext(ctx, `
+		UPDATE outbox_events SET claimed_until = NULL
+		WHERE id `+s.dialect.inInt64s("$1"), s.dialect.int64Array(ids))
+	return err
+}
+
+func queryReviewerIDs(ctx context.Context, q executor, prID string) ([]string, error) {
+	rows, err := q.QueryContext(ctx, "SELECT user_id FROM pr_reviewers WHERE pull_request_id = $1 ORDER BY user_id", prID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	userIDs := []string{}
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+
+	return userIDs, rows.Err()
+}
+
+func nullableInt(value int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(value), Valid: value > 0}
+}
+
+func nullableString(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: value != ""}
+}
@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Chamistery/Test_task/internal/models"
+)
+
+func (s *sqlStorage) CreateAPIKey(ctx context.Context, key *models.APIKey, keyHash string) (err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	now := time.Now().UTC()
+	err = s.conn().QueryRowContext(ctx, `
+		INSERT INTO api_keys (name, key_hash, role, team_name, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`, key.Name, keyHash, key.Role, nullableString(key.TeamName), now).Scan(&key.ID)
+	if err != nil {
+		return err
+	}
+
+	key.CreatedAt = now
+	return nil
+}
+
+const apiKeyColumns = "id, name, role, team_name, created_at, revoked_at"
+
+func (s *sqlStorage) GetAPIKeyByHash(ctx context.Context, keyHash string) (_ *models.APIKey, err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	keys, err := s.queryAPIKeys(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE key_hash = $1", keyHash)
+	if err != nil || len(keys) == 0 {
+		return nil, err
+	}
+	return &keys[0], nil
+}
+
+func (s *sqlStorage) GetAPIKeys(ctx context.Context) (_ []models.APIKey, err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	return s.queryAPIKeys(ctx, "SELECT "+apiKeyColumns+" FROM api_keys ORDER BY id")
+}
+
+func (s *sqlStorage) queryAPIKeys(ctx context.Context, query string, args ...interface{}) ([]models.APIKey, error) {
+	rows, err := s.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		var key models.APIKey
+		var teamName sql.NullString
+		var revokedAt sql.NullTime
+		if err := rows.Scan(&key.ID, &key.Name, &key.Role, &teamName, &key.CreatedAt, &revokedAt); err != nil {
+			return nil, err
+		}
+
+		key.TeamName = teamName.String
+		key.CreatedAt = key.CreatedAt.UTC()
+		if revokedAt.Valid {
+			revoked := revokedAt.Time.UTC()
+			key.RevokedAt = &revoked
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+func (s *sqlStorage) RevokeAPIKey(ctx context.Context, id int64) (err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	result, err := s.conn().ExecContext(ctx, `
+		UPDATE api_keys SET revoked_at = $1
+		WHERE id = $2 AND revoked_at IS NULL
+	`, time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+
+	revoked, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if revoked == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,392 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/Chamistery/Test_task/internal/models"
+)
+
+func (s *sqlStorage) CreateWebhookSubscription(ctx context.Context, subscription *models.WebhookSubscription) (err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	events, err := json.Marshal(append([]string{}, subscription.Events...))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	err = s.conn().QueryRowContext(ctx, `
+		INSERT INTO webhook_subscriptions (url, secret, event_types, created_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`, subscription.URL, subscription.Secret, string(events), now).Scan(&subscription.ID)
+	if err != nil {
+		return err
+	}
+
+	subscription.CreatedAt = now
+	return nil
+}
+
+func (s *sqlStorage) GetWebhookSubscriptions(ctx context.Context) (_ []models.WebhookSubscription, err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	return s.queryWebhookSubscriptions(ctx, s.conn())
+}
+
+func (s *sqlStorage) queryWebhookSubscriptions(ctx context.Context, q executor) ([]models.WebhookSubscription, error) {
+	rows, err := q.QueryContext(ctx, "SELECT id, url, secret, event_types, created_at FROM webhook_subscriptions ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subscriptions := []models.WebhookSubscription{}
+	for rows.Next() {
+		var subscription models.WebhookSubscription
+		var events string
+		if err := rows.Scan(&subscription.ID, &subscription.URL, &subscription.Secret, &events, &subscription.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(events), &subscription.Events); err != nil {
+			return nil, err
+		}
+		subscription.CreatedAt = subscription.CreatedAt.UTC()
+		subscriptions = append(subscriptions, subscription)
+	}
+
+	return subscriptions, rows.Err()
+}
+
+func (s *sqlStorage) DeleteWebhookSubscription(ctx context.Context, id int64) (err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	result, err := s.conn().ExecContext(ctx, "DELETE FROM webhook_subscriptions WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// EnqueueWebhookDeliveries is idempotent per subscription and event, so an
+// event the outbox hands over twice is still delivered to each endpoint once.
+func (s *sqlStorage) EnqueueWebhookDeliveries(ctx context.Context, event models.DomainEvent) (_ int, err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	payload, err := webhookPayload(event)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := s.beginTx(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	subscriptions, err := s.queryWebhookSubscriptions(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now().UTC()
+	enqueued := 0
+	for _, subscription := range subscriptions {
+		if !subscribedTo(subscription, event.Type) {
+			continue
+		}
+
+		result, err := tx.ExecContext(ctx, `
+			INSERT INTO webhook_deliveries (subscription_id, event_id, event_type, payload, status, next_attempt_at, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT (subscription_id, event_id) DO NOTHING
+		`, subscription.ID, event.ID, event.Type, string(payload), models.WebhookPending, now, now)
+		if err != nil {
+			return 0, err
+		}
+
+		inserted, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		enqueued += int(inserted)
+	}
+
+	return enqueued, tx.Commit()
+}
+
+const webhookDeliveryColumns = `
+	d.id, d.subscription_id, d.event_id, d.event_type, d.payload, d.status, d.attempt_count,
+	d.next_attempt_at, d.created_at, d.delivered_at, s.url, s.secret
+`
+
+func (s *sqlStorage) ClaimDueWebhookDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) (_ []models.WebhookDelivery, err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	tx, err := s.beginTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if lock := s.dialect.lockQueue("$1"); lock != "" {
+		if _, err := tx.ExecContext(ctx, lock, "webhook_deliveries"); err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		UPDATE webhook_deliveries SET claimed_until = $1
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = $2 AND next_attempt_at <= $3
+				AND (claimed_until IS NULL OR claimed_until <= $3)
+			ORDER BY next_attempt_at, id
+			LIMIT $4
+		)
+		RETURNING id
+	`, now.Add(lease).UTC(), models.WebhookPending, now.UTC(), outboxLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	deliveries, err := queryWebhookDeliveries(ctx, tx, `
+		SELECT `+webhookDeliveryColumns+`
+		FROM webhook_deliveries d
+		JOIN webhook_subscriptions s ON s.id = d.subscription_id
+		WHERE d.id `+s.dialect.inInt64s("$1")+`
+		ORDER BY d.next_attempt_at, d.id
+	`, s.dialect.int64Array(ids))
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, tx.Commit()
+}
+
+func (s *sqlStorage) GetWebhookDelivery(ctx context.Context, id int64) (_ *models.WebhookDelivery, err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	deliveries, err := queryWebhookDeliveries(ctx, s.conn(), `
+		SELECT `+webhookDeliveryColumns+`
+		FROM webhook_deliveries d
+		JOIN webhook_subscriptions s ON s.id = d.subscription_id
+		WHERE d.id = $1
+	`, id)
+	if err != nil || len(deliveries) == 0 {
+		return nil, err
+	}
+
+	if err := s.loadWebhookAttempts(ctx, deliveries); err != nil {
+		return nil, err
+	}
+	return &deliveries[0], nil
+}
+
+func (s *sqlStorage) GetWebhookDeliveries(ctx context.Context, subscriptionID int64, limit int) (_ []models.WebhookDelivery, err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	deliveries, err := queryWebhookDeliveries(ctx, s.conn(), `
+		SELECT `+webhookDeliveryColumns+`
+		FROM webhook_deliveries d
+		JOIN webhook_subscriptions s ON s.id = d.subscription_id
+		WHERE d.subscription_id = $1
+		ORDER BY d.id DESC
+		LIMIT $2
+	`, subscriptionID, outboxLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, s.loadWebhookAttempts(ctx, deliveries)
+}
+
+func queryWebhookDeliveries(ctx context.Context, q executor, query string, args ...interface{}) ([]models.WebhookDelivery, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []models.WebhookDelivery{}
+	for rows.Next() {
+		var delivery models.WebhookDelivery
+		var payload string
+		var nextAttemptAt, deliveredAt sql.NullTime
+		err := rows.Scan(&delivery.ID, &delivery.SubscriptionID, &delivery.EventID, &delivery.EventType, &payload,
+			&delivery.Status, &delivery.AttemptCount, &nextAttemptAt, &delivery.CreatedAt, &deliveredAt,
+			&delivery.URL, &delivery.Secret)
+		if err != nil {
+			return nil, err
+		}
+
+		delivery.Payload = json.RawMessage(payload)
+		delivery.CreatedAt = delivery.CreatedAt.UTC()
+		if nextAttemptAt.Valid {
+			next := nextAttemptAt.Time.UTC()
+			delivery.NextAttemptAt = &next
+		}
+		if deliveredAt.Valid {
+			delivered := deliveredAt.Time.UTC()
+			delivery.DeliveredAt = &delivered
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+func (s *sqlStorage) loadWebhookAttempts(ctx context.Context, deliveries []models.WebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, 0, len(deliveries))
+	index := make(map[int64]int, len(deliveries))
+	for i, delivery := range deliveries {
+		ids = append(ids, delivery.ID)
+		index[delivery.ID] = i
+	}
+
+	rows, err := s.conn().QueryContext(ctx, `
+		SELECT delivery_id, attempted_at, status_code, error
+		FROM webhook_delivery_attempts
+		WHERE delivery_id `+s.dialect.inInt64s("$1")+`
+		ORDER BY id
+	`, s.dialect.int64Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var deliveryID int64
+		var attempt models.WebhookAttempt
+		var statusCode sql.NullInt64
+		var attemptErr sql.NullString
+		if err := rows.Scan(&deliveryID, &attempt.AttemptedAt, &statusCode, &attemptErr); err != nil {
+			return err
+		}
+
+		attempt.AttemptedAt = attempt.AttemptedAt.UTC()
+		attempt.StatusCode = int(statusCode.Int64)
+		attempt.Error = attemptErr.String
+		delivery := &deliveries[index[deliveryID]]
+		delivery.Attempts = append(delivery.Attempts, attempt)
+	}
+
+	return rows.Err()
+}
+
+func (s *sqlStorage) RecordWebhookAttempt(ctx context.Context, id int64, attempt models.WebhookAttempt, status string, nextAttemptAt *time.Time) (err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	tx, err := s.beginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO webhook_delivery_attempts (delivery_id, attempted_at, status_code, error)
+		VALUES ($1, $2, $3, $4)
+	`, id, attempt.AttemptedAt.UTC(), nullableInt(attempt.StatusCode), nullableString(attempt.Error))
+	if err != nil {
+		return err
+	}
+
+	var next, delivered sql.NullTime
+	if nextAttemptAt != nil {
+		next = sql.NullTime{Time: nextAttemptAt.UTC(), Valid: true}
+	}
+	if status == models.WebhookDelivered {
+		delivered = sql.NullTime{Time: attempt.AttemptedAt.UTC(), Valid: true}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE webhook_deliveries
+		SET status = $1, attempt_count = attempt_count + 1, next_attempt_at = $2, delivered_at = COALESCE($3, delivered_at),
+			claimed_until = NULL
+		WHERE id = $4
+	`, status, next, delivered, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqlStorage) ScheduleWebhookDelivery(ctx context.Context, id int64, at time.Time) (err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	result, err := s.conn().ExecContext(ctx, `
+		UPDATE webhook_deliveries SET status = $1, next_attempt_at = $2
+		WHERE id = $3
+	`, models.WebhookPending, at.UTC(), id)
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// RecordInboundDelivery reports whether deliveryID is new for source. Called
+// inside a unit of work, the record rolls back with a failed operation so the
+// sender's retry is processed again.
+func (s *sqlStorage) RecordInboundDelivery(ctx context.Context, source string, deliveryID string) (_ bool, err error) {
+	ctx, done := s.scope(ctx)
+	defer done(&err)
+
+	result, err := s.conn().ExecContext(ctx, `
+		INSERT INTO inbound_deliveries (source, delivery_id, received_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (source, delivery_id) DO NOTHING
+	`, source, deliveryID, time.Now().UTC())
+	if err != nil {
+		return false, err
+	}
+
+	inserted, err := result.RowsAffected()
+	return inserted == 1, err
+}
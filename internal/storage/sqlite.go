@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+type SQLiteConfig struct {
+	Path         string
+	QueryTimeout time.Duration
+}
+
+type SQLiteStorage struct {
+	*sqlStorage
+}
+
+func NewSQLiteStorage(config SQLiteConfig) (*SQLiteStorage, error) {
+	db, err := ConnectSQLite(config.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := migrateUp(db, "sqlite"); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLiteStorage{
+		sqlStorage: &sqlStorage{db: db, dialect: sqliteDialect{}, queryTimeout: config.QueryTimeout},
+	}, nil
+}
+
+func ConnectSQLite(path string) (*sql.DB, error) {
+	dsn := "file:" + path + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_txlock=immediate&_time_format=sqlite"
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite allows a single writer; one connection also keeps ":memory:" databases alive.
+	db.SetMaxOpenConns(1)
+
+	return db, nil
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) inArray(param string) string {
+	return "IN (SELECT value FROM json_each(" + param + "))"
+}
+
+func (sqliteDialect) array(values []string) interface{} {
+	if values == nil {
+		values = []string{}
+	}
+	encoded, _ := json.Marshal(values)
+	return string(encoded)
+}
+
+// json_each yields integers for JSON numbers, so bigint columns are compared
+// without a cast and keep their indexes.
+func (sqliteDialect) inInt64s(param string) string {
+	return "IN (SELECT value FROM json_each(" + param + "))"
+}
+
+func (sqliteDialect) int64Array(values []int64) interface{} {
+	if values == nil {
+		values = []int64{}
+	}
+	encoded, _ := json.Marshal(values)
+	return string(encoded)
+}
+
+// Write transactions begin IMMEDIATE and so already exclude each other.
+func (sqliteDialect) lockQueue(string) string {
+	return ""
+}
+
+func (sqliteDialect) forUpdate(...string) string {
+	return ""
+}
+
+func (sqliteDialect) isUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	code := sqliteErr.Code()
+	return code == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY || code == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+}
+
+func (sqliteDialect) isConflict(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	code := sqliteErr.Code() & 0xff
+	return code == sqlite3.SQLITE_BUSY || code == sqlite3.SQLITE_LOCKED
+}
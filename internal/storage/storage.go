@@ -1,31 +1,108 @@
 package storage
 
-import "github.com/Chamistery/Test_task/internal/models"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Chamistery/Test_task/internal/models"
+)
+
+var (
+	ErrNotApproved = errors.New("pull request is not approved")
+	ErrPRMerged    = errors.New("pull request is merged")
+	ErrPRClosed    = errors.New("pull request is closed")
+	ErrPRDraft     = errors.New("pull request is a draft")
+	ErrPRExists    = errors.New("pull request already exists")
+	ErrTeamExists  = errors.New("team already exists")
+	ErrNotAssigned = errors.New("reviewer is not assigned")
+	ErrConflict    = errors.New("concurrent update conflict")
+)
+
+func contextError(ctx context.Context, err error) error {
+	if err == nil || ctx.Err() == nil || errors.Is(err, ctx.Err()) {
+		return err
+	}
+	return fmt.Errorf("%w: %v", ctx.Err(), err)
+}
+
+// ReviewerPicker chooses up to count new reviewers for a pull request whose
+// reviewers are being deactivated. It runs inside the deactivation and reads
+// through tx; pr carries the author and the current reviewers.
+type ReviewerPicker func(ctx context.Context, tx Storage, pr *models.PullRequest, count int) ([]string, error)
 
 type Storage interface {
-	CreateTeam(team *models.Team) error
-	GetTeam(teamName string) (*models.Team, error)
-	TeamExists(teamName string) (bool, error)
+	CreateTeam(ctx context.Context, team *models.Team) error
+	GetTeam(ctx context.Context, teamName string) (*models.Team, error)
+	TeamExists(ctx context.Context, teamName string) (bool, error)
+	GetTeamSettings(ctx context.Context, teamName string) (*models.TeamSettings, error)
+	UpdateTeamSettings(ctx context.Context, settings *models.TeamSettings) error
+
+	UpsertUser(ctx context.Context, user *models.TeamMember, teamName string) error
+	GetUser(ctx context.Context, userID string) (*models.User, error)
+	UpdateUser(ctx context.Context, user *models.User) error
+	SetUserIsActive(ctx context.Context, userID string, isActive bool) error
+	GetUserTeam(ctx context.Context, userID string) (string, error)
+	LinkExternalAccount(ctx context.Context, provider string, login string, userID string) error
+	GetExternalAccountUser(ctx context.Context, provider string, login string) (string, error)
+
+	CreatePullRequest(ctx context.Context, pr *models.PullRequest) error
+	GetPullRequest(ctx context.Context, prID string) (*models.PullRequest, error)
+	GetPullRequestForUpdate(ctx context.Context, prID string) (*models.PullRequest, error)
+	PRExists(ctx context.Context, prID string) (bool, error)
+	MergePullRequest(ctx context.Context, prID string, force bool) (*models.PullRequest, error)
+	ClosePullRequest(ctx context.Context, prID string) (*models.PullRequest, error)
+	ReopenPullRequest(ctx context.Context, prID string) (*models.PullRequest, error)
+	MarkPullRequestReady(ctx context.Context, prID string) (*models.PullRequest, error)
+	GetPRsByReviewer(ctx context.Context, userID string) ([]models.PullRequestShort, error)
+
+	IsReviewerAssigned(ctx context.Context, prID string, userID string) (bool, error)
+	ReassignReviewer(ctx context.Context, prID string, oldUserID string, newUserID string) error
+	ReplaceReviewers(ctx context.Context, prID string, removeIDs []string, addIDs []string, reason string) error
+	GetReviewerHistory(ctx context.Context, prID string) ([]models.ReviewerAssignment, error)
+	SubmitReview(ctx context.Context, prID string, userID string, state string) error
+
+	GetActiveCandidates(ctx context.Context, teamName string, excludeIDs []string) ([]string, error)
+	CountSaturatedCandidates(ctx context.Context, teamName string, excludeIDs []string) (int, error)
+	GetOpenReviewCounts(ctx context.Context, userIDs []string) (map[string]int, error)
 
-	UpsertUser(user *models.TeamMember, teamName string) error
-	GetUser(userID string) (*models.User, error)
-	SetUserIsActive(userID string, isActive bool) error
-	GetUserTeam(userID string) (string, error)
+	GetStatistics(ctx context.Context) (*models.Statistics, error)
+	GetAuditEvents(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error)
 
-	CreatePullRequest(pr *models.PullRequest) error
-	GetPullRequest(prID string) (*models.PullRequest, error)
-	PRExists(prID string) (bool, error)
-	MergePullRequest(prID string) (*models.PullRequest, error)
-	GetPRsByReviewer(userID string) ([]models.PullRequestShort, error)
+	GetPendingEvents(ctx context.Context, limit int) ([]models.DomainEvent, error)
+	// ClaimDueEvents reserves pending events for lease and returns them in
+	// order, leaving out events claimed by another dispatcher and those queued
+	// behind an event of the same aggregate that is claimed or waits for a retry.
+	ClaimDueEvents(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]models.DomainEvent, error)
+	MarkEventDelivered(ctx context.Context, id int64) error
+	// MarkEventFailed schedules the next attempt, or gives the event up for
+	// good when nextAttemptAt is nil.
+	MarkEventFailed(ctx context.Context, id int64, lastError string, nextAttemptAt *time.Time) error
+	// ReleaseEvents gives claimed events back to the queue undelivered.
+	ReleaseEvents(ctx context.Context, ids []int64) error
 
-	IsReviewerAssigned(prID string, userID string) (bool, error)
-	ReassignReviewer(prID string, oldUserID string, newUserID string) error
+	CreateWebhookSubscription(ctx context.Context, subscription *models.WebhookSubscription) error
+	GetWebhookSubscriptions(ctx context.Context) ([]models.WebhookSubscription, error)
+	DeleteWebhookSubscription(ctx context.Context, id int64) error
+	EnqueueWebhookDeliveries(ctx context.Context, event models.DomainEvent) (int, error)
+	// ClaimDueWebhookDeliveries reserves due deliveries for lease, skipping
+	// those claimed by another deliverer.
+	ClaimDueWebhookDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]models.WebhookDelivery, error)
+	GetWebhookDelivery(ctx context.Context, id int64) (*models.WebhookDelivery, error)
+	GetWebhookDeliveries(ctx context.Context, subscriptionID int64, limit int) ([]models.WebhookDelivery, error)
+	RecordWebhookAttempt(ctx context.Context, id int64, attempt models.WebhookAttempt, status string, nextAttemptAt *time.Time) error
+	ScheduleWebhookDelivery(ctx context.Context, id int64, at time.Time) error
+	RecordInboundDelivery(ctx context.Context, source string, deliveryID string) (bool, error)
 
-	GetActiveCandidates(teamName string, excludeIDs []string) ([]string, error)
+	CreateAPIKey(ctx context.Context, key *models.APIKey, keyHash string) error
+	GetAPIKeyByHash(ctx context.Context, keyHash string) (*models.APIKey, error)
+	GetAPIKeys(ctx context.Context) ([]models.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id int64) error
 
-	GetStatistics() (*models.Statistics, error)
+	WithinTx(ctx context.Context, fn func(tx Storage) error) error
 
-	BulkDeactivateTeamMembers(teamName string) (int, int, error)
+	BulkDeactivateTeamMembers(ctx context.Context, teamName string, pickReviewers ReviewerPicker) (int, int, error)
 
 	Close() error
 }
@@ -0,0 +1,23 @@
+package storage
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+
+	"github.com/Chamistery/Test_task/internal/models"
+)
+
+// An empty event filter subscribes to every domain event.
+func subscribedTo(subscription models.WebhookSubscription, eventType string) bool {
+	return len(subscription.Events) == 0 || slices.Contains(subscription.Events, eventType)
+}
+
+func webhookPayload(event models.DomainEvent) ([]byte, error) {
+	return json.Marshal(event)
+}
+
+// GitHub and GitLab logins are case-insensitive.
+func externalLogin(login string) string {
+	return strings.ToLower(login)
+}
@@ -0,0 +1,192 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Chamistery/Test_task/internal/models"
+	"github.com/Chamistery/Test_task/internal/storage"
+)
+
+const (
+	SignatureHeader = "X-Webhook-Signature-256"
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+)
+
+const signaturePrefix = "sha256="
+
+// Sign returns the hex HMAC-SHA256 of body in the "sha256=<hex>" form used by
+// GitHub, so receivers can reuse existing verification code.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+func Verify(secret string, body []byte, signature string) bool {
+	if !strings.HasPrefix(signature, signaturePrefix) {
+		return false
+	}
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+// Sink fans domain events out into per-subscription deliveries. Enqueueing is
+// idempotent, so outbox redelivery does not duplicate webhook calls.
+type Sink struct {
+	store storage.Storage
+}
+
+func NewSink(store storage.Storage) *Sink {
+	return &Sink{store: store}
+}
+
+func (*Sink) Name() string {
+	return "webhooks"
+}
+
+func (s *Sink) Deliver(ctx context.Context, event models.DomainEvent) error {
+	_, err := s.store.EnqueueWebhookDeliveries(ctx, event)
+	return err
+}
+
+type Config struct {
+	Client       *http.Client
+	PollInterval time.Duration
+	BatchSize    int
+	MaxAttempts  int
+	BaseBackoff  time.Duration
+	MaxBackoff   time.Duration
+	// Lease reserves a claimed batch for this deliverer; other replicas
+	// attempt the deliveries only if it has not recorded them by then.
+	Lease time.Duration
+}
+
+type Deliverer struct {
+	store  storage.Storage
+	config Config
+}
+
+func NewDeliverer(store storage.Storage, config Config) *Deliverer {
+	if config.Client == nil {
+		config.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = time.Second
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = storage.DefaultOutboxBatch
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 8
+	}
+	if config.BaseBackoff <= 0 {
+		config.BaseBackoff = 10 * time.Second
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = time.Hour
+	}
+	if config.Lease <= 0 {
+		config.Lease = time.Minute
+	}
+
+	return &Deliverer{
+		store:  store,
+		config: config,
+	}
+}
+
+func (d *Deliverer) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := d.DeliverDue(ctx); err != nil {
+			log.Printf("Webhook delivery failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DeliverDue attempts every delivery whose retry time has come and returns how
+// many attempts were made. Receiver failures are recorded on the delivery and
+// scheduled for retry; only storage errors are returned.
+func (d *Deliverer) DeliverDue(ctx context.Context) (int, error) {
+	due, err := d.store.ClaimDueWebhookDeliveries(ctx, time.Now(), d.config.Lease, d.config.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, delivery := range due {
+		if err := d.attempt(ctx, delivery); err != nil {
+			return i, err
+		}
+	}
+
+	return len(due), nil
+}
+
+func (d *Deliverer) attempt(ctx context.Context, delivery models.WebhookDelivery) error {
+	attempt := models.WebhookAttempt{AttemptedAt: time.Now()}
+	attempt.StatusCode, attempt.Error = d.post(ctx, delivery)
+
+	status := models.WebhookDelivered
+	var nextAttemptAt *time.Time
+	if attempt.Error != "" {
+		status = models.WebhookFailed
+		if attemptCount := delivery.AttemptCount + 1; attemptCount < d.config.MaxAttempts {
+			status = models.WebhookPending
+			next := attempt.AttemptedAt.Add(d.backoff(attemptCount))
+			nextAttemptAt = &next
+		}
+	}
+
+	return d.store.RecordWebhookAttempt(ctx, delivery.ID, attempt, status, nextAttemptAt)
+}
+
+func (d *Deliverer) post(ctx context.Context, delivery models.WebhookDelivery) (int, string) {
+	req, err := http.NewRequestWithContext(ctx, "POST", delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err.Error()
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(delivery.Secret, delivery.Payload))
+	req.Header.Set(EventHeader, delivery.EventType)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+
+	resp, err := d.config.Client.Do(req)
+	if err != nil {
+		return 0, err.Error()
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Sprintf("receiver responded %s", resp.Status)
+	}
+	return resp.StatusCode, ""
+}
+
+// backoff doubles the delay after every failed attempt, up to MaxBackoff.
+func (d *Deliverer) backoff(attemptCount int) time.Duration {
+	delay := d.config.BaseBackoff
+	for i := 1; i < attemptCount && delay < d.config.MaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, d.config.MaxBackoff)
+}
@@ -0,0 +1,140 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Chamistery/Test_task/internal/models"
+	"github.com/Chamistery/Test_task/internal/storage"
+)
+
+func TestAuditEndpoint(t *testing.T) {
+	server, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	teamName := "audit-" + suffix
+	author := "au-u1-" + suffix
+
+	post := func(path string, payload interface{}) []byte {
+		body, _ := json.Marshal(payload)
+		req, _ := http.NewRequest("POST", server.URL+path, bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Actor", "lead-"+suffix)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request to %s failed: %v", path, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			t.Fatalf("Request to %s returned %d", path, resp.StatusCode)
+		}
+
+		var raw bytes.Buffer
+		raw.ReadFrom(resp.Body)
+		return raw.Bytes()
+	}
+	audit := func(params url.Values) (int, models.AuditResponse) {
+		resp, err := http.Get(server.URL + "/audit?" + params.Encode())
+		if err != nil {
+			t.Fatalf("Failed to query audit log: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var result models.AuditResponse
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, result
+	}
+
+	post("/team/add", models.Team{
+		TeamName: teamName,
+		Members: []models.TeamMember{
+			{UserID: author, Username: "Author", IsActive: true},
+			{UserID: "au-u2-" + suffix, Username: "Rev1", IsActive: true},
+			{UserID: "au-u3-" + suffix, Username: "Rev2", IsActive: true},
+		},
+	})
+
+	prID := "pr-audit-" + suffix
+	one := 1
+	var created models.CreatePRResponse
+	body := post("/pullRequest/create", models.CreatePRRequest{PullRequestID: prID, PullRequestName: "Audited", AuthorID: author, ReviewersCount: &one})
+	json.Unmarshal(body, &created)
+	if len(created.PR.AssignedReviewers) != 1 {
+		t.Fatalf("Expected one reviewer, got %v", created.PR.AssignedReviewers)
+	}
+	reviewer := created.PR.AssignedReviewers[0]
+
+	movedTeam := "audit-moved-" + suffix
+	post("/team/add", models.Team{TeamName: movedTeam, Members: []models.TeamMember{{UserID: reviewer, Username: "Moved", IsActive: true}}})
+	post("/team/deactivate", models.BulkDeactivateRequest{TeamName: movedTeam})
+
+	status, result := audit(url.Values{"pull_request_id": {prID}})
+	if status != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", status)
+	}
+	if len(result.Events) != 2 || result.Events[0].Type != models.AuditPRCreated || result.Events[1].Type != models.AuditReviewersReplaced {
+		t.Fatalf("Expected create and bulk replacement events for %s, got %+v", prID, result.Events)
+	}
+	replaced := result.Events[1]
+	if replaced.Actor != "lead-"+suffix || replaced.Reason != "team "+movedTeam+" deactivated" || replaced.TeamName != teamName {
+		t.Errorf("Expected actor, reason and author team on bulk event, got %+v", replaced)
+	}
+
+	_, result = audit(url.Values{"team_name": {movedTeam}, "user_id": {reviewer}})
+	var types []string
+	for _, event := range result.Events {
+		types = append(types, event.Type)
+	}
+	expected := []string{models.AuditTeamCreated, models.AuditUserActivityChanged, models.AuditTeamDeactivated}
+	if fmt.Sprint(types) != fmt.Sprint(expected) {
+		t.Errorf("Expected events %v for %s, got %v", expected, reviewer, types)
+	}
+
+	_, result = audit(url.Values{"user_id": {reviewer}, "limit": {"2"}})
+	if len(result.Events) != 2 || result.Events[0].Type != models.AuditTeamCreated || result.Events[1].Type != models.AuditPRCreated {
+		t.Errorf("Expected the oldest two events for %s, got %+v", reviewer, result.Events)
+	}
+
+	for _, params := range []url.Values{
+		{"from": {"yesterday"}},
+		{"limit": {"0"}},
+		{"after_id": {"-1"}},
+	} {
+		if status, _ := audit(params); status != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %v, got %d", params, status)
+		}
+	}
+}
+
+func TestAuditEventsAreAppendOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.db")
+	store, err := storage.NewSQLiteStorage(storage.SQLiteConfig{Path: path})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	if err := store.CreateTeam(context.Background(), &models.Team{TeamName: "append-only"}); err != nil {
+		t.Fatalf("Failed to create team: %v", err)
+	}
+	store.Close()
+
+	db, err := storage.ConnectSQLite(path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("UPDATE audit_events SET actor = 'forged'"); err == nil {
+		t.Error("Expected audit events to reject updates")
+	}
+	if _, err := db.Exec("DELETE FROM audit_events"); err == nil {
+		t.Error("Expected audit events to reject deletes")
+	}
+}
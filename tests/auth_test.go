@@ -0,0 +1,119 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Chamistery/Test_task/internal/auth"
+	"github.com/Chamistery/Test_task/internal/handlers"
+	"github.com/Chamistery/Test_task/internal/models"
+)
+
+func TestAPIKeyRoles(t *testing.T) {
+	store := newTestStorage(t, os.Getenv("TEST_STORAGE_DRIVER"))
+	keys := auth.NewAPIKeys(store)
+	server, _, cleanup := setupTestServerWithStorage(t, store, handlers.WithAuthenticator(keys))
+	defer cleanup()
+
+	ctx := context.Background()
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	teamA, teamB := "auth-a-"+suffix, "auth-b-"+suffix
+	mustCreateTeam(t, store, &models.Team{TeamName: teamA, Members: []models.TeamMember{
+		{UserID: "auth-a1-" + suffix, Username: "A1", IsActive: true},
+		{UserID: "auth-a2-" + suffix, Username: "A2", IsActive: true},
+	}})
+	mustCreateTeam(t, store, &models.Team{TeamName: teamB, Members: []models.TeamMember{
+		{UserID: "auth-b1-" + suffix, Username: "B1", IsActive: true},
+	}})
+
+	issue := func(req models.CreateAPIKeyRequest) string {
+		created, err := keys.Issue(ctx, req)
+		if err != nil {
+			t.Fatalf("Issue(%+v): %v", req, err)
+		}
+		return created.Key
+	}
+	admin := issue(models.CreateAPIKeyRequest{Name: "admin-" + suffix, Role: models.RoleAdmin})
+	lead := issue(models.CreateAPIKeyRequest{Name: "lead-" + suffix, Role: models.RoleTeamLead, TeamName: teamA})
+	bot := issue(models.CreateAPIKeyRequest{Name: "bot-" + suffix, Role: models.RoleBot})
+	reader := issue(models.CreateAPIKeyRequest{Name: "reader-" + suffix, Role: models.RoleReadOnly})
+
+	call := func(method, path, key string, payload interface{}) *http.Response {
+		var body bytes.Buffer
+		if payload != nil {
+			json.NewEncoder(&body).Encode(payload)
+		}
+		req, _ := http.NewRequest(method, server.URL+path, &body)
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		return resp
+	}
+	expect := func(name string, resp *http.Response, status int) {
+		t.Helper()
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("%s: expected %d, got %d", name, status, resp.StatusCode)
+		}
+	}
+
+	expect("anonymous", call("GET", "/team/get?team_name="+teamA, "", nil), http.StatusUnauthorized)
+	expect("unknown key", call("GET", "/team/get?team_name="+teamA, "rsk_forged", nil), http.StatusUnauthorized)
+	expect("public receiver", call("POST", "/webhooks/github", "", map[string]string{}), http.StatusServiceUnavailable)
+
+	expect("read-only reads", call("GET", "/team/get?team_name="+teamA, reader, nil), http.StatusOK)
+	expect("read-only writes", call("POST", "/pullRequest/create", reader, models.CreatePRRequest{PullRequestID: "auth-pr0-" + suffix, PullRequestName: "X", AuthorID: "auth-a1-" + suffix}), http.StatusForbidden)
+
+	prID := "auth-pr1-" + suffix
+	expect("bot creates PR", call("POST", "/pullRequest/create", bot, models.CreatePRRequest{PullRequestID: prID, PullRequestName: "X", AuthorID: "auth-a1-" + suffix}), http.StatusCreated)
+	expect("bot deactivates team", call("POST", "/team/deactivate", bot, models.BulkDeactivateRequest{TeamName: teamA}), http.StatusForbidden)
+	expect("bot lists keys", call("GET", "/apikey/list", bot, nil), http.StatusForbidden)
+	expect("bot approves as a reviewer", call("POST", "/pullRequest/review", bot, models.SubmitReviewRequest{PullRequestID: prID, UserID: "auth-a2-" + suffix, State: models.ReviewApproved}), http.StatusForbidden)
+	expect("bot forces merge", call("POST", "/pullRequest/merge", bot, models.MergePRRequest{PullRequestID: prID, Force: true}), http.StatusForbidden)
+	expect("admin forces merge", call("POST", "/pullRequest/merge", admin, models.MergePRRequest{PullRequestID: prID, Force: true}), http.StatusOK)
+
+	expect("lead deactivates other team", call("POST", "/team/deactivate", lead, models.BulkDeactivateRequest{TeamName: teamB}), http.StatusForbidden)
+	expect("lead deactivates other team's user", call("POST", "/users/setIsActive", lead, models.SetIsActiveRequest{UserID: "auth-b1-" + suffix}), http.StatusForbidden)
+	expect("lead deactivates own user", call("POST", "/users/setIsActive", lead, models.SetIsActiveRequest{UserID: "auth-a2-" + suffix}), http.StatusOK)
+	expect("lead moves other team's user", call("POST", "/team/add", lead, models.Team{TeamName: teamA, Members: []models.TeamMember{{UserID: "auth-b1-" + suffix, Username: "B1", IsActive: true}}}), http.StatusForbidden)
+	if team, _ := store.GetUserTeam(ctx, "auth-b1-"+suffix); team != teamB {
+		t.Errorf("Expected auth-b1 to stay in %s, got %q", teamB, team)
+	}
+	expect("admin deactivates any team", call("POST", "/team/deactivate", admin, models.BulkDeactivateRequest{TeamName: teamB}), http.StatusOK)
+
+	events, err := store.GetAuditEvents(ctx, models.AuditFilter{UserID: "auth-a2-" + suffix})
+	if err != nil || len(events) == 0 || events[len(events)-1].Actor != "apikey:lead-"+suffix {
+		t.Errorf("Expected the deactivation attributed to the lead's key, got %+v, %v", events, err)
+	}
+
+	resp := call("POST", "/apikey/create", admin, models.CreateAPIKeyRequest{Name: "issued-" + suffix, Role: models.RoleReadOnly})
+	var created models.CreateAPIKeyResponse
+	json.NewDecoder(resp.Body).Decode(&created)
+	expect("admin creates key", resp, http.StatusCreated)
+	expect("issued key", call("GET", "/statistics", created.Key, nil), http.StatusOK)
+
+	expect("lead creates key", call("POST", "/apikey/create", lead, models.CreateAPIKeyRequest{Name: "x", Role: models.RoleAdmin}), http.StatusForbidden)
+	expect("team-lead key without team", call("POST", "/apikey/create", admin, models.CreateAPIKeyRequest{Name: "x", Role: models.RoleTeamLead}), http.StatusBadRequest)
+
+	expect("admin revokes key", call("POST", "/apikey/revoke", admin, models.RevokeAPIKeyRequest{ID: created.APIKey.ID}), http.StatusOK)
+	expect("revoked key", call("GET", "/statistics", created.Key, nil), http.StatusUnauthorized)
+
+	resp = call("GET", "/apikey/list", admin, nil)
+	var listed models.APIKeyListResponse
+	json.NewDecoder(resp.Body).Decode(&listed)
+	expect("admin lists keys", resp, http.StatusOK)
+	raw, _ := json.Marshal(listed)
+	if bytes.Contains(raw, []byte(created.Key)) || bytes.Contains(raw, []byte(auth.HashKey(created.Key))) {
+		t.Error("Expected listed keys to expose neither the key nor its hash")
+	}
+}
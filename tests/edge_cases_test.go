@@ -5,10 +5,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/Chamistery/Test_task/internal/handlers"
 	"github.com/Chamistery/Test_task/internal/models"
+	"github.com/Chamistery/Test_task/internal/storage"
 )
 
 func TestNoActiveReviewers(t *testing.T) {
@@ -340,3 +345,1043 @@ func TestReassignFromSameTeam(t *testing.T) {
 		}
 	}
 }
+
+func TestReviewerCapacityLimit(t *testing.T) {
+	server, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	team := models.Team{
+		TeamName: "capacity-" + suffix,
+		Members: []models.TeamMember{
+			{UserID: "cap-u1-" + suffix, Username: "Author", IsActive: true},
+			{UserID: "cap-u2-" + suffix, Username: "Rev1", IsActive: true, MaxOpenReviews: 1},
+			{UserID: "cap-u3-" + suffix, Username: "Rev2", IsActive: true, MaxOpenReviews: 1},
+		},
+	}
+
+	body, _ := json.Marshal(team)
+	resp, _ := http.Post(server.URL+"/team/add", "application/json", bytes.NewBuffer(body))
+	resp.Body.Close()
+
+	for i, expected := range []int{2, 0} {
+		prReq := models.CreatePRRequest{
+			PullRequestID:   fmt.Sprintf("pr-capacity-%d-%s", i, suffix),
+			PullRequestName: "Test Capacity",
+			AuthorID:        "cap-u1-" + suffix,
+		}
+
+		body, _ = json.Marshal(prReq)
+		resp, _ = http.Post(server.URL+"/pullRequest/create", "application/json", bytes.NewBuffer(body))
+
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("Expected status 201, got %d", resp.StatusCode)
+		}
+
+		var result models.CreatePRResponse
+		json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+
+		if len(result.PR.AssignedReviewers) != expected {
+			t.Errorf("PR %d: expected %d reviewers, got %d", i, expected, len(result.PR.AssignedReviewers))
+		}
+
+		if expected == 0 {
+			if result.Shortfall == nil || result.Shortfall.Code != models.ErrAtCapacity {
+				t.Errorf("Expected shortfall with code %s, got %+v", models.ErrAtCapacity, result.Shortfall)
+			}
+		}
+	}
+
+	maxOpen := 2
+	updateReq := models.UpdateUserRequest{UserID: "cap-u2-" + suffix, MaxOpenReviews: &maxOpen}
+	body, _ = json.Marshal(updateReq)
+	resp, err := http.Post(server.URL+"/users/update", "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("Failed to update user: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200 from /users/update, got %d", resp.StatusCode)
+	}
+
+	var userResult map[string]models.User
+	json.NewDecoder(resp.Body).Decode(&userResult)
+
+	if userResult["user"].MaxOpenReviews != maxOpen {
+		t.Errorf("Expected max_open_reviews %d, got %d", maxOpen, userResult["user"].MaxOpenReviews)
+	}
+}
+
+func TestTeamReviewersRequired(t *testing.T) {
+	server, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	for _, required := range []int{1, 3} {
+		suffix := fmt.Sprintf("%d-%d", required, time.Now().UnixNano())
+		team := models.Team{
+			TeamName:          "required-" + suffix,
+			ReviewersRequired: required,
+			Members: []models.TeamMember{
+				{UserID: "rq-u1-" + suffix, Username: "Author", IsActive: true},
+				{UserID: "rq-u2-" + suffix, Username: "Rev1", IsActive: true},
+				{UserID: "rq-u3-" + suffix, Username: "Rev2", IsActive: true},
+				{UserID: "rq-u4-" + suffix, Username: "Rev3", IsActive: true},
+			},
+		}
+
+		body, _ := json.Marshal(team)
+		resp, err := http.Post(server.URL+"/team/add", "application/json", bytes.NewBuffer(body))
+		if err != nil {
+			t.Fatalf("Failed to create team: %v", err)
+		}
+		var teamResult map[string]models.Team
+		json.NewDecoder(resp.Body).Decode(&teamResult)
+		resp.Body.Close()
+
+		if teamResult["team"].ReviewersRequired != required {
+			t.Errorf("Expected reviewers_required %d, got %d", required, teamResult["team"].ReviewersRequired)
+		}
+
+		prReq := models.CreatePRRequest{
+			PullRequestID:   "pr-required-" + suffix,
+			PullRequestName: "Test Reviewers Required",
+			AuthorID:        "rq-u1-" + suffix,
+		}
+
+		body, _ = json.Marshal(prReq)
+		resp, err = http.Post(server.URL+"/pullRequest/create", "application/json", bytes.NewBuffer(body))
+		if err != nil {
+			t.Fatalf("Failed to create PR: %v", err)
+		}
+		var result models.CreatePRResponse
+		json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+
+		if len(result.PR.AssignedReviewers) != required {
+			t.Errorf("Expected %d reviewers, got %d", required, len(result.PR.AssignedReviewers))
+		}
+	}
+}
+
+func TestPRReviewersCountOverride(t *testing.T) {
+	server, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	team := models.Team{
+		TeamName: "override-" + suffix,
+		Members: []models.TeamMember{
+			{UserID: "ov-u1-" + suffix, Username: "Author", IsActive: true},
+			{UserID: "ov-u2-" + suffix, Username: "Rev1", IsActive: true},
+			{UserID: "ov-u3-" + suffix, Username: "Rev2", IsActive: true},
+			{UserID: "ov-u4-" + suffix, Username: "Rev3", IsActive: true},
+		},
+	}
+
+	body, _ := json.Marshal(team)
+	resp, err := http.Post(server.URL+"/team/add", "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("Failed to create team: %v", err)
+	}
+	resp.Body.Close()
+
+	reviewersCount := 3
+	prReq := models.CreatePRRequest{
+		PullRequestID:   "pr-override-" + suffix,
+		PullRequestName: "Test Reviewers Override",
+		AuthorID:        "ov-u1-" + suffix,
+		ReviewersCount:  &reviewersCount,
+	}
+
+	body, _ = json.Marshal(prReq)
+	resp, err = http.Post(server.URL+"/pullRequest/create", "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("Failed to create PR: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var result models.CreatePRResponse
+	json.NewDecoder(resp.Body).Decode(&result)
+
+	if len(result.PR.AssignedReviewers) != reviewersCount {
+		t.Errorf("Expected %d reviewers, got %d", reviewersCount, len(result.PR.AssignedReviewers))
+	}
+
+	if result.PR.ReviewersRequired != reviewersCount {
+		t.Errorf("Expected reviewers_required %d on PR, got %d", reviewersCount, result.PR.ReviewersRequired)
+	}
+}
+
+func TestFallbackTeamReviewers(t *testing.T) {
+	server, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	fallback := models.Team{
+		TeamName: "fallback-pool-" + suffix,
+		Members: []models.TeamMember{
+			{UserID: "fb-u1-" + suffix, Username: "Helper1", IsActive: true},
+			{UserID: "fb-u2-" + suffix, Username: "Helper2", IsActive: true},
+		},
+	}
+	team := models.Team{
+		TeamName:      "fallback-owner-" + suffix,
+		FallbackTeams: []string{fallback.TeamName},
+		Members: []models.TeamMember{
+			{UserID: "fo-u1-" + suffix, Username: "Author", IsActive: true},
+			{UserID: "fo-u2-" + suffix, Username: "Rev1", IsActive: true},
+		},
+	}
+
+	for _, tm := range []models.Team{fallback, team} {
+		body, _ := json.Marshal(tm)
+		resp, err := http.Post(server.URL+"/team/add", "application/json", bytes.NewBuffer(body))
+		if err != nil {
+			t.Fatalf("Failed to create team: %v", err)
+		}
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("Expected status 201 for team %s, got %d", tm.TeamName, resp.StatusCode)
+		}
+		resp.Body.Close()
+	}
+
+	prReq := models.CreatePRRequest{
+		PullRequestID:   "pr-fallback-" + suffix,
+		PullRequestName: "Test Fallback",
+		AuthorID:        "fo-u1-" + suffix,
+	}
+
+	body, _ := json.Marshal(prReq)
+	resp, err := http.Post(server.URL+"/pullRequest/create", "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("Failed to create PR: %v", err)
+	}
+	var result models.CreatePRResponse
+	json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+
+	if len(result.PR.AssignedReviewers) != 2 {
+		t.Fatalf("Expected 2 reviewers using fallback team, got %v", result.PR.AssignedReviewers)
+	}
+
+	ownTeammate := false
+	for _, revID := range result.PR.AssignedReviewers {
+		if revID == "fo-u2-"+suffix {
+			ownTeammate = true
+		}
+	}
+	if !ownTeammate {
+		t.Errorf("Expected own teammate to be picked before fallback members, got %v", result.PR.AssignedReviewers)
+	}
+
+	reassignReq := models.ReassignRequest{
+		PullRequestID: prReq.PullRequestID,
+		OldUserID:     "fo-u2-" + suffix,
+	}
+	body, _ = json.Marshal(reassignReq)
+	resp, err = http.Post(server.URL+"/pullRequest/reassign", "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("Failed to reassign: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected reassignment through fallback chain to succeed, got %d", resp.StatusCode)
+	}
+}
+
+func TestReviewSubmission(t *testing.T) {
+	server, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	team := models.Team{
+		TeamName: "review-" + suffix,
+		Members: []models.TeamMember{
+			{UserID: "rv-u1-" + suffix, Username: "Author", IsActive: true},
+			{UserID: "rv-u2-" + suffix, Username: "Rev1", IsActive: true},
+		},
+	}
+
+	body, _ := json.Marshal(team)
+	resp, err := http.Post(server.URL+"/team/add", "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("Failed to create team: %v", err)
+	}
+	resp.Body.Close()
+
+	prReq := models.CreatePRRequest{
+		PullRequestID:   "pr-review-" + suffix,
+		PullRequestName: "Test Review",
+		AuthorID:        "rv-u1-" + suffix,
+	}
+	body, _ = json.Marshal(prReq)
+	resp, err = http.Post(server.URL+"/pullRequest/create", "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("Failed to create PR: %v", err)
+	}
+	resp.Body.Close()
+
+	reviewReq := models.SubmitReviewRequest{
+		PullRequestID: prReq.PullRequestID,
+		UserID:        "rv-u1-" + suffix,
+		State:         models.ReviewApproved,
+	}
+	body, _ = json.Marshal(reviewReq)
+	resp, err = http.Post(server.URL+"/pullRequest/review", "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("Failed to submit review: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("Expected 409 for review by non-assigned user, got %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(fmt.Sprintf("%s/users/getReview?user_id=rv-u2-%s", server.URL, suffix))
+	if err != nil {
+		t.Fatalf("Failed to get reviews: %v", err)
+	}
+	var pending models.UserReviewsResponse
+	json.NewDecoder(resp.Body).Decode(&pending)
+	resp.Body.Close()
+
+	if len(pending.PullRequests) != 1 || !pending.PullRequests[0].NeedsAction {
+		t.Fatalf("Expected one PR needing action, got %+v", pending.PullRequests)
+	}
+
+	reviewReq.UserID = "rv-u2-" + suffix
+	body, _ = json.Marshal(reviewReq)
+	resp, err = http.Post(server.URL+"/pullRequest/review", "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("Failed to submit review: %v", err)
+	}
+	var result map[string]models.PullRequest
+	json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+
+	reviews := result["pr"].Reviews
+	if len(reviews) != 1 || reviews[0].State != models.ReviewApproved {
+		t.Errorf("Expected single APPROVED review, got %+v", reviews)
+	}
+
+	resp, err = http.Get(fmt.Sprintf("%s/users/getReview?user_id=rv-u2-%s", server.URL, suffix))
+	if err != nil {
+		t.Fatalf("Failed to get reviews: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var done models.UserReviewsResponse
+	json.NewDecoder(resp.Body).Decode(&done)
+
+	if len(done.PullRequests) != 1 || done.PullRequests[0].NeedsAction {
+		t.Errorf("Expected reviewed PR not to need action, got %+v", done.PullRequests)
+	}
+}
+
+func TestMergeRequiresApproval(t *testing.T) {
+	server, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	team := models.Team{
+		TeamName:          "approval-" + suffix,
+		ApprovalsRequired: 1,
+		Members: []models.TeamMember{
+			{UserID: "ap-u1-" + suffix, Username: "Author", IsActive: true},
+			{UserID: "ap-u2-" + suffix, Username: "Rev1", IsActive: true},
+		},
+	}
+
+	post := func(path string, payload interface{}) *http.Response {
+		body, _ := json.Marshal(payload)
+		resp, err := http.Post(server.URL+path, "application/json", bytes.NewBuffer(body))
+		if err != nil {
+			t.Fatalf("Request to %s failed: %v", path, err)
+		}
+		return resp
+	}
+
+	post("/team/add", team).Body.Close()
+
+	prID := "pr-approval-" + suffix
+	post("/pullRequest/create", models.CreatePRRequest{
+		PullRequestID:   prID,
+		PullRequestName: "Test Approval",
+		AuthorID:        "ap-u1-" + suffix,
+	}).Body.Close()
+
+	resp := post("/pullRequest/merge", models.MergePRRequest{PullRequestID: prID})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("Expected 409 for unapproved merge, got %d", resp.StatusCode)
+	}
+
+	post("/pullRequest/review", models.SubmitReviewRequest{
+		PullRequestID: prID,
+		UserID:        "ap-u2-" + suffix,
+		State:         models.ReviewChangesRequested,
+	}).Body.Close()
+
+	resp = post("/pullRequest/merge", models.MergePRRequest{PullRequestID: prID})
+	var errResult models.ErrorResponse
+	json.NewDecoder(resp.Body).Decode(&errResult)
+	resp.Body.Close()
+	if errResult.Error.Code != models.ErrNotApproved {
+		t.Errorf("Expected error code %s, got %s", models.ErrNotApproved, errResult.Error.Code)
+	}
+
+	resp = post("/pullRequest/merge", models.MergePRRequest{PullRequestID: prID, Force: true})
+	var result map[string]models.PullRequest
+	json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || !result["pr"].ForceMerged {
+		t.Errorf("Expected forced merge to succeed and be recorded, got %d %+v", resp.StatusCode, result["pr"])
+	}
+
+	resp = post("/pullRequest/merge", models.MergePRRequest{PullRequestID: prID})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected repeated merge to stay idempotent, got %d", resp.StatusCode)
+	}
+}
+
+func TestMergeWithoutApprovalsByDefault(t *testing.T) {
+	server, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	team := models.Team{
+		TeamName: "noapproval-" + suffix,
+		Members: []models.TeamMember{
+			{UserID: "na-u1-" + suffix, Username: "Author", IsActive: true},
+			{UserID: "na-u2-" + suffix, Username: "Rev1", IsActive: true},
+		},
+	}
+
+	post := func(path string, payload interface{}) *http.Response {
+		body, _ := json.Marshal(payload)
+		resp, err := http.Post(server.URL+path, "application/json", bytes.NewBuffer(body))
+		if err != nil {
+			t.Fatalf("Request to %s failed: %v", path, err)
+		}
+		return resp
+	}
+
+	resp := post("/team/add", team)
+	var created map[string]models.Team
+	json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+	if created["team"].ApprovalsRequired != 0 {
+		t.Errorf("Expected approvals_required to default to 0, got %d", created["team"].ApprovalsRequired)
+	}
+
+	prID := "pr-noapproval-" + suffix
+	post("/pullRequest/create", models.CreatePRRequest{
+		PullRequestID:   prID,
+		PullRequestName: "Test Default Approval",
+		AuthorID:        "na-u1-" + suffix,
+	}).Body.Close()
+
+	resp = post("/pullRequest/merge", models.MergePRRequest{PullRequestID: prID})
+	var result map[string]models.PullRequest
+	json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || result["pr"].Status != "MERGED" || result["pr"].ForceMerged {
+		t.Errorf("Expected an unreviewed PR to merge by default, got %d %+v", resp.StatusCode, result["pr"])
+	}
+}
+
+func TestCloseAndReopenPR(t *testing.T) {
+	server, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	team := models.Team{
+		TeamName: "close-" + suffix,
+		Members: []models.TeamMember{
+			{UserID: "cl-u1-" + suffix, Username: "Author", IsActive: true},
+			{UserID: "cl-u2-" + suffix, Username: "Rev1", IsActive: true},
+			{UserID: "cl-u3-" + suffix, Username: "Rev2", IsActive: true},
+			{UserID: "cl-u4-" + suffix, Username: "Rev3", IsActive: true},
+		},
+	}
+
+	post := func(path string, payload interface{}) *http.Response {
+		body, _ := json.Marshal(payload)
+		resp, err := http.Post(server.URL+path, "application/json", bytes.NewBuffer(body))
+		if err != nil {
+			t.Fatalf("Request to %s failed: %v", path, err)
+		}
+		return resp
+	}
+
+	post("/team/add", team).Body.Close()
+
+	prID := "pr-close-" + suffix
+	resp := post("/pullRequest/create", models.CreatePRRequest{
+		PullRequestID:   prID,
+		PullRequestName: "Test Close",
+		AuthorID:        "cl-u1-" + suffix,
+	})
+	var created models.CreatePRResponse
+	json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+
+	if len(created.PR.AssignedReviewers) != 2 {
+		t.Fatalf("Expected 2 reviewers, got %v", created.PR.AssignedReviewers)
+	}
+
+	resp = post("/pullRequest/close", models.ClosePRRequest{PullRequestID: prID})
+	var closed map[string]models.PullRequest
+	json.NewDecoder(resp.Body).Decode(&closed)
+	resp.Body.Close()
+
+	if closed["pr"].Status != "CLOSED" || closed["pr"].ClosedAt == nil {
+		t.Fatalf("Expected CLOSED PR with closedAt, got %+v", closed["pr"])
+	}
+
+	resp = post("/pullRequest/merge", models.MergePRRequest{PullRequestID: prID})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("Expected 409 when merging closed PR, got %d", resp.StatusCode)
+	}
+
+	inactive := created.PR.AssignedReviewers[0]
+	post("/users/setIsActive", models.SetIsActiveRequest{UserID: inactive, IsActive: false}).Body.Close()
+
+	resp = post("/pullRequest/reopen", models.ReopenPRRequest{PullRequestID: prID})
+	var reopened models.CreatePRResponse
+	json.NewDecoder(resp.Body).Decode(&reopened)
+	resp.Body.Close()
+
+	if reopened.PR.Status != "OPEN" || reopened.PR.ClosedAt != nil {
+		t.Fatalf("Expected reopened PR to be OPEN, got %+v", reopened.PR)
+	}
+
+	if len(reopened.PR.AssignedReviewers) != 2 {
+		t.Errorf("Expected reviewers to be topped up to 2, got %v", reopened.PR.AssignedReviewers)
+	}
+
+	for _, reviewerID := range reopened.PR.AssignedReviewers {
+		if reviewerID == inactive {
+			t.Errorf("Inactive reviewer %s should be removed on reopen", inactive)
+		}
+	}
+}
+
+func TestDraftPRDefersAssignment(t *testing.T) {
+	server, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	team := models.Team{
+		TeamName: "draft-" + suffix,
+		Members: []models.TeamMember{
+			{UserID: "dr-u1-" + suffix, Username: "Author", IsActive: true},
+			{UserID: "dr-u2-" + suffix, Username: "Rev1", IsActive: true},
+			{UserID: "dr-u3-" + suffix, Username: "Rev2", IsActive: true},
+		},
+	}
+
+	post := func(path string, payload interface{}) *http.Response {
+		body, _ := json.Marshal(payload)
+		resp, err := http.Post(server.URL+path, "application/json", bytes.NewBuffer(body))
+		if err != nil {
+			t.Fatalf("Request to %s failed: %v", path, err)
+		}
+		return resp
+	}
+
+	post("/team/add", team).Body.Close()
+
+	prID := "pr-draft-" + suffix
+	resp := post("/pullRequest/create", models.CreatePRRequest{
+		PullRequestID:   prID,
+		PullRequestName: "Test Draft",
+		AuthorID:        "dr-u1-" + suffix,
+		IsDraft:         true,
+	})
+	var created models.CreatePRResponse
+	json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+
+	if !created.PR.IsDraft || len(created.PR.AssignedReviewers) != 0 {
+		t.Fatalf("Expected draft PR without reviewers, got %+v", created.PR)
+	}
+
+	resp, err := http.Get(server.URL + "/statistics")
+	if err != nil {
+		t.Fatalf("Failed to get statistics: %v", err)
+	}
+	var stats models.Statistics
+	json.NewDecoder(resp.Body).Decode(&stats)
+	resp.Body.Close()
+
+	if stats.DraftPRs == 0 {
+		t.Error("Expected draft PRs to be reported in statistics")
+	}
+
+	resp = post("/pullRequest/ready", models.ReadyPRRequest{PullRequestID: prID})
+	var ready models.CreatePRResponse
+	json.NewDecoder(resp.Body).Decode(&ready)
+	resp.Body.Close()
+
+	if ready.PR.IsDraft {
+		t.Error("Expected PR to leave draft state")
+	}
+
+	if len(ready.PR.AssignedReviewers) != 2 {
+		t.Errorf("Expected 2 reviewers after ready, got %v", ready.PR.AssignedReviewers)
+	}
+}
+
+func TestTimeoutsMapToGatewayTimeout(t *testing.T) {
+	sqliteStore, err := storage.NewSQLiteStorage(storage.SQLiteConfig{Path: ":memory:", QueryTimeout: time.Nanosecond})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer sqliteStore.Close()
+
+	cases := []struct {
+		name    string
+		store   storage.Storage
+		timeout time.Duration
+	}{
+		{"request timeout", storage.NewMemoryStorage(), time.Nanosecond},
+		{"query timeout", sqliteStore, 10 * time.Second},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := handlers.NewHandlers(tc.store)
+			server := httptest.NewServer(handlers.RequestTimeout(tc.timeout, http.HandlerFunc(h.HandleTeamAdd)))
+			defer server.Close()
+
+			body, _ := json.Marshal(models.Team{TeamName: "timeout-team"})
+			resp, err := http.Post(server.URL+"/team/add", "application/json", bytes.NewBuffer(body))
+			if err != nil {
+				t.Fatalf("Failed to create team: %v", err)
+			}
+			defer resp.Body.Close()
+
+			var errResp models.ErrorResponse
+			json.NewDecoder(resp.Body).Decode(&errResp)
+
+			if resp.StatusCode != http.StatusGatewayTimeout || errResp.Error.Code != models.ErrTimeout {
+				t.Errorf("Expected 504 %s, got %d %s", models.ErrTimeout, resp.StatusCode, errResp.Error.Code)
+			}
+		})
+	}
+}
+
+func TestSetIsActiveKeepsErrorMapping(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	mustCreateTeam(t, store, &models.Team{TeamName: "active-timeout", Members: []models.TeamMember{{UserID: "at-timeout-u1", Username: "U1", IsActive: true}}})
+
+	h := handlers.NewHandlers(store)
+	server := httptest.NewServer(handlers.RequestTimeout(time.Nanosecond, http.HandlerFunc(h.HandleUserSetIsActive)))
+	defer server.Close()
+
+	body, _ := json.Marshal(models.SetIsActiveRequest{UserID: "at-timeout-u1", IsActive: false})
+	resp, err := http.Post(server.URL+"/users/setIsActive", "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("Failed to set activity: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var errResp models.ErrorResponse
+	json.NewDecoder(resp.Body).Decode(&errResp)
+	if resp.StatusCode != http.StatusGatewayTimeout || errResp.Error.Code != models.ErrTimeout {
+		t.Errorf("Expected 504 %s instead of a not-found, got %d %s", models.ErrTimeout, resp.StatusCode, errResp.Error.Code)
+	}
+}
+
+func TestConcurrentPRCreateConflicts(t *testing.T) {
+	server, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	team := models.Team{
+		TeamName: "atomic-" + suffix,
+		Members: []models.TeamMember{
+			{UserID: "at-u1-" + suffix, Username: "Author", IsActive: true},
+			{UserID: "at-u2-" + suffix, Username: "Rev1", IsActive: true},
+			{UserID: "at-u3-" + suffix, Username: "Rev2", IsActive: true},
+		},
+	}
+
+	body, _ := json.Marshal(team)
+	resp, err := http.Post(server.URL+"/team/add", "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("Failed to create team: %v", err)
+	}
+	resp.Body.Close()
+
+	const workers = 10
+	prID := "pr-atomic-" + suffix
+	var wg sync.WaitGroup
+	statuses := make(chan int, workers)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			body, _ := json.Marshal(models.CreatePRRequest{
+				PullRequestID:   prID,
+				PullRequestName: "Raced",
+				AuthorID:        "at-u1-" + suffix,
+			})
+			resp, err := http.Post(server.URL+"/pullRequest/create", "application/json", bytes.NewBuffer(body))
+			if err != nil {
+				t.Errorf("Failed to create PR: %v", err)
+				return
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode == http.StatusConflict {
+				var errResp models.ErrorResponse
+				json.NewDecoder(resp.Body).Decode(&errResp)
+				if errResp.Error.Code != models.ErrPRExists {
+					t.Errorf("Expected %s, got %s", models.ErrPRExists, errResp.Error.Code)
+				}
+			}
+			statuses <- resp.StatusCode
+		}()
+	}
+
+	wg.Wait()
+	close(statuses)
+
+	created := 0
+	for status := range statuses {
+		switch status {
+		case http.StatusCreated:
+			created++
+		case http.StatusConflict:
+		default:
+			t.Errorf("Expected 201 or 409, got %d", status)
+		}
+	}
+	if created != 1 {
+		t.Errorf("Expected exactly one PR to be created, got %d", created)
+	}
+}
+
+func TestConcurrentPRCreatesRespectMaxOpenReviews(t *testing.T) {
+	server, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	reviewers := []string{"mc-u2-" + suffix, "mc-u3-" + suffix}
+	team := models.Team{
+		TeamName: "max-concurrent-" + suffix,
+		Members: []models.TeamMember{
+			{UserID: "mc-u1-" + suffix, Username: "Author", IsActive: true},
+			{UserID: reviewers[0], Username: "Rev1", IsActive: true, MaxOpenReviews: 2},
+			{UserID: reviewers[1], Username: "Rev2", IsActive: true, MaxOpenReviews: 2},
+		},
+	}
+
+	body, _ := json.Marshal(team)
+	resp, err := http.Post(server.URL+"/team/add", "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("Failed to create team: %v", err)
+	}
+	resp.Body.Close()
+
+	const workers = 8
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+
+			body, _ := json.Marshal(models.CreatePRRequest{
+				PullRequestID:   fmt.Sprintf("pr-max-%d-%s", id, suffix),
+				PullRequestName: "Capped",
+				AuthorID:        "mc-u1-" + suffix,
+			})
+			resp, err := http.Post(server.URL+"/pullRequest/create", "application/json", bytes.NewBuffer(body))
+			if err != nil {
+				t.Errorf("Failed to create PR: %v", err)
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusConflict {
+				t.Errorf("Expected 201 or a retryable 409, got %d", resp.StatusCode)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, reviewer := range reviewers {
+		resp, err := http.Get(fmt.Sprintf("%s/users/getReview?user_id=%s", server.URL, reviewer))
+		if err != nil {
+			t.Fatalf("Failed to get reviews: %v", err)
+		}
+		var reviews models.UserReviewsResponse
+		json.NewDecoder(resp.Body).Decode(&reviews)
+		resp.Body.Close()
+
+		if len(reviews.PullRequests) != 2 {
+			t.Errorf("Expected %s to be filled exactly to max_open_reviews, got %d", reviewer, len(reviews.PullRequests))
+		}
+	}
+}
+
+func TestConcurrentReassignIsSerialized(t *testing.T) {
+	server, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	author := "cr-author-" + suffix
+	members := []models.TeamMember{{UserID: author, Username: "Author", IsActive: true}}
+	for i := 0; i < 8; i++ {
+		members = append(members, models.TeamMember{UserID: fmt.Sprintf("cr-r%d-%s", i, suffix), Username: fmt.Sprintf("Rev%d", i), IsActive: true})
+	}
+
+	post := func(path string, payload interface{}) (int, []byte) {
+		body, _ := json.Marshal(payload)
+		resp, err := http.Post(server.URL+path, "application/json", bytes.NewBuffer(body))
+		if err != nil {
+			t.Errorf("Request to %s failed: %v", path, err)
+			return 0, nil
+		}
+		defer resp.Body.Close()
+
+		var raw bytes.Buffer
+		raw.ReadFrom(resp.Body)
+		return resp.StatusCode, raw.Bytes()
+	}
+	errorCode := func(body []byte) string {
+		var errResp models.ErrorResponse
+		json.Unmarshal(body, &errResp)
+		return errResp.Error.Code
+	}
+
+	if status, _ := post("/team/add", models.Team{TeamName: "cr-" + suffix, Members: members}); status != http.StatusCreated {
+		t.Fatalf("Expected team to be created, got %d", status)
+	}
+
+	const prs = 5
+	const workers = 8
+	for p := 0; p < prs; p++ {
+		prID := fmt.Sprintf("pr-cr%d-%s", p, suffix)
+		status, body := post("/pullRequest/create", models.CreatePRRequest{PullRequestID: prID, PullRequestName: "Raced", AuthorID: author})
+		if status != http.StatusCreated {
+			t.Fatalf("Expected PR to be created, got %d", status)
+		}
+		var created models.CreatePRResponse
+		json.Unmarshal(body, &created)
+		oldReviewer := created.PR.AssignedReviewers[0]
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		reassigned, merged := 0, 0
+
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+
+				if i == workers-1 {
+					status, _ := post("/pullRequest/merge", models.MergePRRequest{PullRequestID: prID})
+					if status != http.StatusOK {
+						t.Errorf("Expected merge to succeed, got %d", status)
+						return
+					}
+					mu.Lock()
+					merged++
+					mu.Unlock()
+					return
+				}
+
+				status, body := post("/pullRequest/reassign", models.ReassignRequest{PullRequestID: prID, OldUserID: oldReviewer})
+				switch {
+				case status == http.StatusOK:
+					mu.Lock()
+					reassigned++
+					mu.Unlock()
+				case status == http.StatusConflict:
+					if code := errorCode(body); code != models.ErrNotAssigned && code != models.ErrPRMerged {
+						t.Errorf("Expected %s or %s, got %s", models.ErrNotAssigned, models.ErrPRMerged, code)
+					}
+				default:
+					t.Errorf("Expected 200 or 409, got %d: %s", status, body)
+				}
+			}(i)
+		}
+
+		wg.Wait()
+
+		if reassigned > 1 || merged != 1 {
+			t.Errorf("Expected at most one reassign and one merge for %s, got %d and %d", prID, reassigned, merged)
+		}
+
+		status, body = post("/pullRequest/merge", models.MergePRRequest{PullRequestID: prID})
+		if status != http.StatusOK {
+			t.Fatalf("Expected idempotent merge, got %d", status)
+		}
+		var final map[string]models.PullRequest
+		json.Unmarshal(body, &final)
+
+		reviewers := final["pr"].AssignedReviewers
+		if len(reviewers) != 2 || reviewers[0] == reviewers[1] {
+			t.Errorf("Expected two distinct reviewers on %s, got %v", prID, reviewers)
+		}
+		if reassigned == 1 && slices.Contains(reviewers, oldReviewer) {
+			t.Errorf("Expected %s to be replaced on %s, got %v", oldReviewer, prID, reviewers)
+		}
+	}
+}
+
+func TestPullRequestHistory(t *testing.T) {
+	server, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	team := models.Team{
+		TeamName: "history-" + suffix,
+		Members: []models.TeamMember{
+			{UserID: "hi-u1-" + suffix, Username: "Author", IsActive: true},
+			{UserID: "hi-u2-" + suffix, Username: "Rev1", IsActive: true},
+			{UserID: "hi-u3-" + suffix, Username: "Rev2", IsActive: true},
+		},
+	}
+
+	body, _ := json.Marshal(team)
+	resp, _ := http.Post(server.URL+"/team/add", "application/json", bytes.NewBuffer(body))
+	resp.Body.Close()
+
+	one := 1
+	prReq := models.CreatePRRequest{
+		PullRequestID:   "pr-history-" + suffix,
+		PullRequestName: "History",
+		AuthorID:        "hi-u1-" + suffix,
+		ReviewersCount:  &one,
+	}
+	body, _ = json.Marshal(prReq)
+	resp, _ = http.Post(server.URL+"/pullRequest/create", "application/json", bytes.NewBuffer(body))
+	var created models.CreatePRResponse
+	json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+
+	if len(created.PR.AssignedReviewers) != 1 {
+		t.Fatalf("Expected one reviewer, got %v", created.PR.AssignedReviewers)
+	}
+	oldReviewer := created.PR.AssignedReviewers[0]
+
+	body, _ = json.Marshal(models.ReassignRequest{PullRequestID: prReq.PullRequestID, OldUserID: oldReviewer})
+	resp, _ = http.Post(server.URL+"/pullRequest/reassign", "application/json", bytes.NewBuffer(body))
+	var reassigned models.ReassignResponse
+	json.NewDecoder(resp.Body).Decode(&reassigned)
+	resp.Body.Close()
+
+	resp, err := http.Get(server.URL + "/pullRequest/history?pull_request_id=" + prReq.PullRequestID)
+	if err != nil {
+		t.Fatalf("Failed to get history: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+
+	var history models.PRHistoryResponse
+	json.NewDecoder(resp.Body).Decode(&history)
+
+	if len(history.Assignments) != 2 {
+		t.Fatalf("Expected two assignments, got %+v", history.Assignments)
+	}
+	first, second := history.Assignments[0], history.Assignments[1]
+	if first.UserID != oldReviewer || first.AssignReason != models.AssignmentInitial || first.UnassignReason != models.AssignmentManualReassign || first.UnassignedAt == nil {
+		t.Errorf("Expected initial assignment ended by manual reassign, got %+v", first)
+	}
+	if second.UserID != reassigned.ReplacedBy || second.AssignReason != models.AssignmentManualReassign || second.UnassignedAt != nil {
+		t.Errorf("Expected open manual reassign assignment for %s, got %+v", reassigned.ReplacedBy, second)
+	}
+
+	resp, _ = http.Get(server.URL + "/pullRequest/history?pull_request_id=missing-" + suffix)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown PR, got %d", resp.StatusCode)
+	}
+
+	resp, _ = http.Get(server.URL + "/pullRequest/history")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 without pull_request_id, got %d", resp.StatusCode)
+	}
+}
+
+func TestConcurrentReadyAndReopenAssignOnce(t *testing.T) {
+	server, _, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	author := "cy-author-" + suffix
+	members := []models.TeamMember{{UserID: author, Username: "Author", IsActive: true}}
+	for i := 0; i < 6; i++ {
+		members = append(members, models.TeamMember{UserID: fmt.Sprintf("cy-r%d-%s", i, suffix), Username: fmt.Sprintf("Rev%d", i), IsActive: true})
+	}
+
+	post := func(path string, payload interface{}) (int, models.CreatePRResponse) {
+		body, _ := json.Marshal(payload)
+		resp, err := http.Post(server.URL+path, "application/json", bytes.NewBuffer(body))
+		if err != nil {
+			t.Errorf("Request to %s failed: %v", path, err)
+			return 0, models.CreatePRResponse{}
+		}
+		defer resp.Body.Close()
+
+		var result models.CreatePRResponse
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, result
+	}
+
+	if status, _ := post("/team/add", models.Team{TeamName: "cy-" + suffix, Members: members}); status != http.StatusCreated {
+		t.Fatalf("Expected team to be created, got %d", status)
+	}
+
+	prID := "pr-cy-" + suffix
+	if status, _ := post("/pullRequest/create", models.CreatePRRequest{PullRequestID: prID, PullRequestName: "Raced", AuthorID: author, IsDraft: true}); status != http.StatusCreated {
+		t.Fatalf("Expected draft PR to be created, got %d", status)
+	}
+
+	race := func(path string) {
+		const workers = 8
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if status, _ := post(path, models.ReadyPRRequest{PullRequestID: prID}); status != http.StatusOK && status != http.StatusConflict {
+					t.Errorf("%s: expected 200 or 409, got %d", path, status)
+				}
+			}()
+		}
+		wg.Wait()
+
+		status, result := post(path, models.ReadyPRRequest{PullRequestID: prID})
+		if status != http.StatusOK || len(result.PR.AssignedReviewers) != models.DefaultReviewersRequired {
+			t.Errorf("%s: expected %d reviewers after the race, got %d %v", path, models.DefaultReviewersRequired, status, result.PR.AssignedReviewers)
+		}
+	}
+
+	race("/pullRequest/ready")
+
+	if status, _ := post("/pullRequest/close", models.ClosePRRequest{PullRequestID: prID}); status != http.StatusOK {
+		t.Fatalf("Expected PR to be closed, got %d", status)
+	}
+	race("/pullRequest/reopen")
+}
@@ -0,0 +1,305 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Chamistery/Test_task/internal/handlers"
+	"github.com/Chamistery/Test_task/internal/models"
+	"github.com/Chamistery/Test_task/internal/storage"
+	"github.com/Chamistery/Test_task/internal/webhooks"
+)
+
+func githubPullRequestPayload(action, repo string, number int, login string, merged bool) map[string]interface{} {
+	return map[string]interface{}{
+		"action": action,
+		"pull_request": map[string]interface{}{
+			"number": number,
+			"title":  "Inbound #" + fmt.Sprint(number),
+			"merged": merged,
+			"user":   map[string]string{"login": login},
+		},
+		"repository": map[string]string{"full_name": repo},
+		"sender":     map[string]string{"login": login},
+	}
+}
+
+func TestGitHubWebhookDrivesPullRequests(t *testing.T) {
+	const secret = "github-secret"
+	store := newTestStorage(t, os.Getenv("TEST_STORAGE_DRIVER"))
+	server, _, cleanup := setupTestServerWithStorage(t, store, handlers.WithGitHubSecret(secret))
+	defer cleanup()
+
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	author, login, repo := "gh-u1-"+suffix, "Octocat-"+suffix, "acme/service-"+suffix
+	post := func(path string, payload interface{}) *http.Response {
+		body, _ := json.Marshal(payload)
+		resp, err := http.Post(server.URL+path, "application/json", bytes.NewBuffer(body))
+		if err != nil {
+			t.Fatalf("Request to %s failed: %v", path, err)
+		}
+		return resp
+	}
+	post("/team/add", models.Team{
+		TeamName: "github-" + suffix,
+		Members: []models.TeamMember{
+			{UserID: author, Username: "gh-author-" + suffix, IsActive: true},
+			{UserID: "gh-u2-" + suffix, Username: "gh-reviewer-" + suffix, IsActive: true},
+		},
+	}).Body.Close()
+
+	deliver := func(event, deliveryID, signature string, payload interface{}) (int, models.InboundWebhookResponse) {
+		body, _ := json.Marshal(payload)
+		req, _ := http.NewRequest("POST", server.URL+"/webhooks/github", bytes.NewReader(body))
+		req.Header.Set("X-GitHub-Event", event)
+		req.Header.Set("X-GitHub-Delivery", deliveryID)
+		if signature == "" {
+			signature = webhooks.Sign(secret, body)
+		}
+		req.Header.Set("X-Hub-Signature-256", signature)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Webhook delivery failed: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var result models.InboundWebhookResponse
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, result
+	}
+
+	opened := githubPullRequestPayload("opened", repo, 7, login, false)
+	if status, _ := deliver("pull_request", "d1-"+suffix, webhooks.Sign("wrong", []byte("{}")), opened); status != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a bad signature, got %d", status)
+	}
+	if status, _ := deliver("pull_request", "d1-"+suffix, "", opened); status != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 for an unlinked author, got %d", status)
+	}
+
+	resp := post("/users/linkAccount", models.LinkAccountRequest{Provider: models.ProviderGitHub, Login: login, UserID: "gh-missing-" + suffix})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 when linking an unknown user, got %d", resp.StatusCode)
+	}
+	post("/users/linkAccount", models.LinkAccountRequest{Provider: models.ProviderGitHub, Login: login, UserID: author}).Body.Close()
+
+	prID := repo + "#7"
+	status, result := deliver("pull_request", "d1-"+suffix, "", opened)
+	if status != http.StatusOK || result.Status != models.InboundApplied || result.PR == nil ||
+		result.PR.PullRequestID != prID || result.PR.AuthorID != author || len(result.PR.AssignedReviewers) != 1 {
+		t.Fatalf("Expected the retried delivery to create the PR, got %d %+v", status, result)
+	}
+
+	if _, result := deliver("pull_request", "d1-"+suffix, "", opened); result.Status != models.InboundDuplicate {
+		t.Errorf("Expected a redelivery to be reported as duplicate, got %+v", result)
+	}
+	if _, result := deliver("ping", "d2-"+suffix, "", map[string]string{"zen": "hi"}); result.Status != models.InboundIgnored {
+		t.Errorf("Expected ping to be ignored, got %+v", result)
+	}
+
+	if _, result := deliver("pull_request", "d3-"+suffix, "", githubPullRequestPayload("closed", repo, 7, login, false)); result.PR == nil || result.PR.Status != "CLOSED" {
+		t.Errorf("Expected the PR to be closed, got %+v", result)
+	}
+	if _, result := deliver("pull_request", "d4-"+suffix, "", githubPullRequestPayload("reopened", repo, 7, login, false)); result.PR == nil || result.PR.Status != "OPEN" {
+		t.Errorf("Expected the PR to be reopened, got %+v", result)
+	}
+	if _, result := deliver("pull_request", "d5-"+suffix, "", githubPullRequestPayload("closed", repo, 7, login, true)); result.PR == nil || result.PR.Status != "MERGED" {
+		t.Errorf("Expected the PR to be merged, got %+v", result)
+	}
+	if _, result := deliver("pull_request", "d6-"+suffix, "", githubPullRequestPayload("closed", repo, 8, login, true)); result.Status != models.InboundIgnored {
+		t.Errorf("Expected a merge of an unknown PR to be ignored, got %+v", result)
+	}
+
+	resp, err := http.Get(server.URL + "/audit?pull_request_id=" + url.QueryEscape(prID))
+	if err != nil {
+		t.Fatalf("Failed to query audit: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var audit models.AuditResponse
+	json.NewDecoder(resp.Body).Decode(&audit)
+	if len(audit.Events) == 0 {
+		t.Fatal("Expected audit events for the mirrored PR")
+	}
+	for _, event := range audit.Events {
+		if event.Actor != "github:"+login {
+			t.Errorf("Expected changes attributed to the GitHub sender, got %+v", event)
+		}
+	}
+}
+
+func gitlabMergeRequestPayload(action, project string, iid int, username string, draft bool) map[string]interface{} {
+	return map[string]interface{}{
+		"object_kind": "merge_request",
+		"user":        map[string]interface{}{"id": 1, "username": username},
+		"project":     map[string]string{"path_with_namespace": project},
+		"object_attributes": map[string]interface{}{
+			"iid":       iid,
+			"author_id": 1,
+			"title":     "Inbound !" + fmt.Sprint(iid),
+			"action":    action,
+			"draft":     draft,
+		},
+	}
+}
+
+func TestGitLabWebhookDrivesMergeRequests(t *testing.T) {
+	const token = "gitlab-token"
+	store := newTestStorage(t, os.Getenv("TEST_STORAGE_DRIVER"))
+	server, _, cleanup := setupTestServerWithStorage(t, store, handlers.WithGitLabToken(token))
+	defer cleanup()
+
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	author, linked, project := "gl-u1-"+suffix, "gl-u2-"+suffix, "group/service-"+suffix
+	body, _ := json.Marshal(models.Team{
+		TeamName: "gitlab-" + suffix,
+		Members: []models.TeamMember{
+			{UserID: author, Username: "gl-author-" + suffix, IsActive: true},
+			{UserID: linked, Username: "gl-linked-" + suffix, IsActive: true},
+			{UserID: "gl-u3-" + suffix, Username: "gl-reviewer-" + suffix, IsActive: true},
+		},
+	})
+	resp, err := http.Post(server.URL+"/team/add", "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("Failed to create team: %v", err)
+	}
+	resp.Body.Close()
+	if err := store.LinkExternalAccount(context.Background(), models.ProviderGitLab, "Jane-"+suffix, linked); err != nil {
+		t.Fatalf("LinkExternalAccount: %v", err)
+	}
+
+	deliver := func(deliveryID, secret string, payload interface{}) (int, models.InboundWebhookResponse) {
+		body, _ := json.Marshal(payload)
+		req, _ := http.NewRequest("POST", server.URL+"/webhooks/gitlab", bytes.NewReader(body))
+		req.Header.Set("X-Gitlab-Event", "Merge Request Hook")
+		req.Header.Set("X-Gitlab-Event-UUID", deliveryID)
+		req.Header.Set("X-Gitlab-Token", secret)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Webhook delivery failed: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var result models.InboundWebhookResponse
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, result
+	}
+
+	draft := gitlabMergeRequestPayload("open", project, 3, author, true)
+	if status, _ := deliver("e1-"+suffix, "wrong", draft); status != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a wrong token, got %d", status)
+	}
+	if status, _ := deliver("", token, draft); status != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a delivery ID, got %d", status)
+	}
+
+	status, result := deliver("e1-"+suffix, token, draft)
+	if status != http.StatusOK || result.PR == nil || result.PR.PullRequestID != project+"!3" || result.PR.AuthorID != author ||
+		!result.PR.IsDraft || len(result.PR.AssignedReviewers) != 0 {
+		t.Fatalf("Expected a draft MR authored by the matching user ID, got %d %+v", status, result)
+	}
+	if _, result := deliver("e1-"+suffix, token, draft); result.Status != models.InboundDuplicate {
+		t.Errorf("Expected a redelivery to be reported as duplicate, got %+v", result)
+	}
+
+	_, result = deliver("e2-"+suffix, token, gitlabMergeRequestPayload("update", project, 3, author, false))
+	if result.PR == nil || result.PR.IsDraft || len(result.PR.AssignedReviewers) == 0 {
+		t.Errorf("Expected leaving draft to assign reviewers, got %+v", result)
+	}
+	if _, result := deliver("e3-"+suffix, token, gitlabMergeRequestPayload("merge", project, 3, author, false)); result.PR == nil || result.PR.Status != "MERGED" {
+		t.Errorf("Expected the MR to be merged, got %+v", result)
+	}
+
+	_, result = deliver("e4-"+suffix, token, gitlabMergeRequestPayload("open", project, 4, "jane-"+suffix, false))
+	if result.PR == nil || result.PR.AuthorID != linked {
+		t.Fatalf("Expected the linked account to author the MR, got %+v", result)
+	}
+	if _, result := deliver("e5-"+suffix, token, gitlabMergeRequestPayload("close", project, 4, "jane-"+suffix, false)); result.PR == nil || result.PR.Status != "CLOSED" {
+		t.Errorf("Expected the MR to be closed, got %+v", result)
+	}
+
+	if status, _ := deliver("e6-"+suffix, token, gitlabMergeRequestPayload("open", project, 5, "stranger-"+suffix, false)); status != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 for an unknown GitLab user, got %d", status)
+	}
+	if _, result := deliver("e7-"+suffix, token, gitlabMergeRequestPayload("approved", project, 4, author, false)); result.Status != models.InboundIgnored {
+		t.Errorf("Expected approvals to be ignored, got %+v", result)
+	}
+
+	// Another user's events carry the author only as a numeric ID.
+	byOther := func(action string, iid int) map[string]interface{} {
+		payload := gitlabMergeRequestPayload(action, project, iid, author, false)
+		payload["user"] = map[string]interface{}{"id": 2, "username": author}
+		return payload
+	}
+	if _, result := deliver("e8-"+suffix, token, byOther("update", 6)); result.Status != models.InboundIgnored {
+		t.Errorf("Expected an unknown MR updated by someone else to be ignored, got %+v", result)
+	}
+	if _, result := deliver("e9-"+suffix, token, byOther("reopen", 4)); result.PR == nil || result.PR.Status != "OPEN" || result.PR.AuthorID != linked {
+		t.Errorf("Expected the MR reopened by someone else to keep its author, got %+v", result)
+	}
+}
+
+// staleStorage misses a pull request once, as a transaction does when another
+// delivery creates it concurrently.
+type staleStorage struct {
+	storage.Storage
+	stale *atomic.Bool
+}
+
+func (s staleStorage) WithinTx(ctx context.Context, fn func(tx storage.Storage) error) error {
+	return s.Storage.WithinTx(ctx, func(tx storage.Storage) error {
+		return fn(staleStorage{Storage: tx, stale: s.stale})
+	})
+}
+
+func (s staleStorage) GetPullRequestForUpdate(ctx context.Context, prID string) (*models.PullRequest, error) {
+	if s.stale.CompareAndSwap(true, false) {
+		return nil, nil
+	}
+	return s.Storage.GetPullRequestForUpdate(ctx, prID)
+}
+
+func TestInboundFirstSightingRacingAnotherDelivery(t *testing.T) {
+	const token = "gitlab-token"
+	store := newTestStorage(t, os.Getenv("TEST_STORAGE_DRIVER"))
+	stale := &atomic.Bool{}
+	server, _, cleanup := setupTestServerWithStorage(t, staleStorage{Storage: store, stale: stale}, handlers.WithGitLabToken(token))
+	defer cleanup()
+
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	author, project := "gl-race-"+suffix, "group/race-"+suffix
+	mustCreateTeam(t, store, &models.Team{
+		TeamName: "gitlab-race-" + suffix,
+		Members:  []models.TeamMember{{UserID: author, Username: "Author", IsActive: true}},
+	})
+	mustCreatePR(t, store, &models.PullRequest{PullRequestID: project + "!1", PullRequestName: "Raced", AuthorID: author})
+	stale.Store(true)
+
+	body, _ := json.Marshal(gitlabMergeRequestPayload("open", project, 1, author, false))
+	req, _ := http.NewRequest("POST", server.URL+"/webhooks/gitlab", bytes.NewReader(body))
+	req.Header.Set("X-Gitlab-Event", "Merge Request Hook")
+	req.Header.Set("X-Gitlab-Event-UUID", "race-"+suffix)
+	req.Header.Set("X-Gitlab-Token", token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Webhook delivery failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var result models.InboundWebhookResponse
+	json.NewDecoder(resp.Body).Decode(&result)
+	if resp.StatusCode != http.StatusOK || result.Status != models.InboundApplied || result.PR == nil || result.PR.PullRequestName != "Raced" {
+		t.Errorf("Expected the delivery to apply to the concurrently created MR, got %d %+v", resp.StatusCode, result)
+	}
+}
@@ -4,44 +4,61 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
 	"time"
 
 	"github.com/Chamistery/Test_task/internal/handlers"
 	"github.com/Chamistery/Test_task/internal/models"
+	"github.com/Chamistery/Test_task/internal/router"
 	"github.com/Chamistery/Test_task/internal/storage"
 )
 
-func setupTestServer(t *testing.T) (*httptest.Server, *handlers.Handlers, func()) {
-	dbConfig := storage.DBConfig{
-		Host:     "localhost",
-		Port:     "5432",
-		User:     "postgres",
-		Password: "postgres",
-		DBName:   "reviewer_service",
-	}
+func newTestStorage(t *testing.T, driver string) storage.Storage {
+	switch driver {
+	case "postgres":
+		dbConfig := storage.DBConfig{
+			Host:     "localhost",
+			Port:     "5432",
+			User:     "postgres",
+			Password: "postgres",
+			DBName:   "reviewer_service",
+		}
 
-	store, err := storage.NewPostgresStorage(dbConfig)
-	if err != nil {
-		t.Fatalf("Failed to create storage: %v", err)
+		store, err := storage.NewPostgresStorage(dbConfig)
+		if err != nil {
+			t.Fatalf("Failed to create storage: %v", err)
+		}
+		return store
+	case "sqlite":
+		store, err := storage.NewSQLiteStorage(storage.SQLiteConfig{Path: ":memory:"})
+		if err != nil {
+			t.Fatalf("Failed to create storage: %v", err)
+		}
+		return store
+	case "memory", "":
+		return storage.NewMemoryStorage()
 	}
 
-	h := handlers.NewHandlers(store)
+	t.Fatalf("Unknown storage driver %q", driver)
+	return nil
+}
+
+func setupTestServer(t *testing.T) (*httptest.Server, *handlers.Handlers, func()) {
+	return setupTestServerWithStorage(t, newTestStorage(t, os.Getenv("TEST_STORAGE_DRIVER")))
+}
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/team/add", h.HandleTeamAdd)
-	mux.HandleFunc("/team/get", h.HandleTeamGet)
-	mux.HandleFunc("/users/setIsActive", h.HandleUserSetIsActive)
-	mux.HandleFunc("/pullRequest/create", h.HandlePullRequestCreate)
-	mux.HandleFunc("/pullRequest/merge", h.HandlePullRequestMerge)
-	mux.HandleFunc("/pullRequest/reassign", h.HandlePullRequestReassign)
-	mux.HandleFunc("/users/getReview", h.HandleUsersGetReview)
-	mux.HandleFunc("/statistics", h.HandleStatistics)
-	mux.HandleFunc("/team/deactivate", h.HandleTeamDeactivate)
+func setupTestServerWithStorage(t *testing.T, store storage.Storage, opts ...handlers.Option) (*httptest.Server, *handlers.Handlers, func()) {
+	h := handlers.NewHandlers(store, opts...)
 
-	server := httptest.NewServer(mux)
+	server := httptest.NewServer(router.New(h, router.Config{
+		RequestTimeout: 10 * time.Second,
+		Logger:         log.New(io.Discard, "", 0),
+	}))
 
 	cleanup := func() {
 		server.Close()
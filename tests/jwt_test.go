@@ -0,0 +1,341 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Chamistery/Test_task/internal/auth"
+	"github.com/Chamistery/Test_task/internal/handlers"
+	"github.com/Chamistery/Test_task/internal/models"
+)
+
+func signJWT(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	t.Helper()
+	encode := func(v interface{}) string {
+		data, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+
+	header := map[string]string{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	signingInput := encode(header) + "." + encode(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	var signature []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:]); err != nil {
+			t.Fatalf("Failed to sign token: %v", err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatalf("Failed to sign token: %v", err)
+		}
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func jwkFor(kid string, key crypto.PublicKey) map[string]string {
+	encode := base64.RawURLEncoding.EncodeToString
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return map[string]string{"kty": "RSA", "kid": kid, "use": "sig", "n": encode(k.N.Bytes()), "e": encode(big.NewInt(int64(k.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		return map[string]string{"kty": "EC", "kid": kid, "crv": "P-256", "x": encode(k.X.FillBytes(make([]byte, 32))), "y": encode(k.Y.FillBytes(make([]byte, 32)))}
+	}
+	return nil
+}
+
+// jwksServer serves a key set that the test can rotate and counts fetches.
+type jwksServer struct {
+	mu      sync.Mutex
+	keys    []map[string]string
+	fetches int
+}
+
+func (s *jwksServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.fetches++
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": s.keys})
+}
+
+func (s *jwksServer) publish(keys ...map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+}
+
+func (s *jwksServer) fetchCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fetches
+}
+
+func TestJWTAuthentication(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate EC key: %v", err)
+	}
+	forgedKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+
+	jwks := &jwksServer{}
+	jwks.publish(jwkFor("rsa-1", rsaKey.Public()))
+	jwksHTTP := httptest.NewServer(jwks)
+	defer jwksHTTP.Close()
+
+	store := newTestStorage(t, os.Getenv("TEST_STORAGE_DRIVER"))
+	authenticator, err := auth.NewJWT(store, auth.JWTConfig{
+		JWKS:       jwksHTTP.URL,
+		Audience:   "reviewer-service",
+		UserClaim:  "employee_id",
+		MinRefresh: time.Nanosecond,
+	})
+	if err != nil {
+		t.Fatalf("NewJWT: %v", err)
+	}
+	server, _, cleanup := setupTestServerWithStorage(t, store, handlers.WithAuthenticator(authenticator))
+	defer cleanup()
+
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	lead, m1, m2, m3, other := "jwt-lead-"+suffix, "jwt-m1-"+suffix, "jwt-m2-"+suffix, "jwt-m3-"+suffix, "jwt-b1-"+suffix
+	mustCreateTeam(t, store, &models.Team{TeamName: "jwt-a-" + suffix, Members: []models.TeamMember{
+		{UserID: lead, Username: "Lead", IsActive: true},
+		{UserID: m1, Username: "M1", IsActive: true},
+		{UserID: m2, Username: "M2", IsActive: true},
+		{UserID: m3, Username: "M3", IsActive: true},
+		{UserID: "jwt-m4-" + suffix, Username: "M4", IsActive: true},
+		{UserID: "jwt-m5-" + suffix, Username: "M5", IsActive: true},
+	}})
+	mustCreateTeam(t, store, &models.Team{TeamName: "jwt-b-" + suffix, Members: []models.TeamMember{
+		{UserID: other, Username: "B1", IsActive: true},
+	}})
+	prID := "pr-jwt-" + suffix
+	mustCreatePR(t, store, &models.PullRequest{PullRequestID: prID, PullRequestName: "JWT", AuthorID: m3, AssignedReviewers: []string{m1, m2}})
+
+	claims := func(userID string, extra map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{"employee_id": userID, "aud": []string{"reviewer-service"}, "exp": time.Now().Add(time.Hour).Unix()}
+		for k, v := range extra {
+			c[k] = v
+		}
+		return c
+	}
+	member := signJWT(t, "RS256", "rsa-1", rsaKey, claims(m1, nil))
+	teamLead := signJWT(t, "RS256", "rsa-1", rsaKey, claims(lead, map[string]interface{}{"role": models.RoleTeamLead}))
+
+	call := func(path, token string, payload interface{}) int {
+		body, _ := json.Marshal(payload)
+		req, _ := http.NewRequest("POST", server.URL+path, bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("POST %s failed: %v", path, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	for name, token := range map[string]string{
+		"expired":        signJWT(t, "RS256", "rsa-1", rsaKey, claims(m1, map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()})),
+		"wrong audience": signJWT(t, "RS256", "rsa-1", rsaKey, claims(m1, map[string]interface{}{"aud": "other"})),
+		"forged":         signJWT(t, "RS256", "rsa-1", forgedKey, claims(m1, nil)),
+		"alg mismatch":   signJWT(t, "ES256", "rsa-1", ecKey, claims(m1, nil)),
+		"unknown user":   signJWT(t, "RS256", "rsa-1", rsaKey, claims("jwt-ghost-"+suffix, nil)),
+		"unknown key":    signJWT(t, "ES256", "ec-9", ecKey, claims(m1, nil)),
+		"malformed":      "not-a-jwt",
+	} {
+		if status := call("/users/setIsActive", token, models.SetIsActiveRequest{UserID: m1, IsActive: true}); status != http.StatusUnauthorized {
+			t.Errorf("%s token: expected 401, got %d", name, status)
+		}
+	}
+
+	if status := call("/users/setIsActive", member, models.SetIsActiveRequest{UserID: m2, IsActive: false}); status != http.StatusForbidden {
+		t.Errorf("Expected a member to be refused changing someone else, got %d", status)
+	}
+	if status := call("/users/setIsActive", member, models.SetIsActiveRequest{UserID: m1, IsActive: true}); status != http.StatusOK {
+		t.Errorf("Expected a member to change themselves, got %d", status)
+	}
+	if status := call("/pullRequest/review", member, models.SubmitReviewRequest{PullRequestID: prID, UserID: m2, State: models.ReviewApproved}); status != http.StatusForbidden {
+		t.Errorf("Expected a member to be refused approving as someone else, got %d", status)
+	}
+	if status := call("/pullRequest/review", member, models.SubmitReviewRequest{PullRequestID: prID, UserID: m1, State: models.ReviewApproved}); status != http.StatusOK {
+		t.Errorf("Expected a member to approve as themselves, got %d", status)
+	}
+	if status := call("/pullRequest/reassign", member, models.ReassignRequest{PullRequestID: prID, OldUserID: m2}); status != http.StatusForbidden {
+		t.Errorf("Expected a member to be refused reassigning someone else, got %d", status)
+	}
+	if status := call("/pullRequest/reassign", member, models.ReassignRequest{PullRequestID: prID, OldUserID: m1}); status != http.StatusOK {
+		t.Errorf("Expected a member to hand off their own review, got %d", status)
+	}
+
+	if status := call("/pullRequest/reassign", teamLead, models.ReassignRequest{PullRequestID: prID, OldUserID: m2}); status != http.StatusOK {
+		t.Errorf("Expected the team lead to reassign a teammate, got %d", status)
+	}
+	if status := call("/users/setIsActive", teamLead, models.SetIsActiveRequest{UserID: other, IsActive: false}); status != http.StatusForbidden {
+		t.Errorf("Expected the team lead to be refused another team's user, got %d", status)
+	}
+	if status := call("/users/setIsActive", teamLead, models.SetIsActiveRequest{UserID: m2, IsActive: false}); status != http.StatusOK {
+		t.Errorf("Expected the team lead to deactivate a teammate, got %d", status)
+	}
+
+	events, err := store.GetAuditEvents(context.Background(), models.AuditFilter{UserID: m2})
+	if err != nil || len(events) == 0 || events[len(events)-1].Actor != "user:"+lead {
+		t.Errorf("Expected the deactivation attributed to the lead, got %+v, %v", events, err)
+	}
+
+	fetches := jwks.fetchCount()
+	if status := call("/users/setIsActive", member, models.SetIsActiveRequest{UserID: m1, IsActive: true}); status != http.StatusOK || jwks.fetchCount() != fetches {
+		t.Errorf("Expected a cached key to need no fetch, got %d after %d fetches", status, jwks.fetchCount()-fetches)
+	}
+
+	jwks.publish(jwkFor("rsa-1", rsaKey.Public()), jwkFor("ec-2", ecKey.Public()))
+	rotated := signJWT(t, "ES256", "ec-2", ecKey, claims(m1, nil))
+	if status := call("/users/setIsActive", rotated, models.SetIsActiveRequest{UserID: m1, IsActive: true}); status != http.StatusOK {
+		t.Errorf("Expected a token signed with a rotated-in key to be accepted, got %d", status)
+	}
+}
+
+func TestJWTServesCachedKeysDuringJWKSOutage(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate EC key: %v", err)
+	}
+
+	var mu sync.Mutex
+	fetches, down := 0, false
+	release := make(chan struct{})
+	jwksHTTP := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetches++
+		failing := down
+		mu.Unlock()
+
+		if failing {
+			<-release
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{jwkFor("ec-1", ecKey.Public())}})
+	}))
+	defer jwksHTTP.Close()
+	fetchCount := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return fetches
+	}
+
+	store := newTestStorage(t, os.Getenv("TEST_STORAGE_DRIVER"))
+	defer store.Close()
+	const minRefresh = 100 * time.Millisecond
+	authenticator, err := auth.NewJWT(store, auth.JWTConfig{JWKS: jwksHTTP.URL, CacheTTL: time.Nanosecond, MinRefresh: minRefresh})
+	if err != nil {
+		t.Fatalf("NewJWT: %v", err)
+	}
+
+	userID := "jwt-outage-" + fmt.Sprint(time.Now().UnixNano())
+	mustCreateTeam(t, store, &models.Team{TeamName: userID, Members: []models.TeamMember{{UserID: userID, Username: "Outage", IsActive: true}}})
+	token := signJWT(t, "ES256", "ec-1", ecKey, map[string]interface{}{"sub": userID, "exp": time.Now().Add(time.Minute).Unix()})
+
+	ctx := context.Background()
+	if _, err := authenticator.Authenticate(ctx, token); err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+
+	time.Sleep(2 * minRefresh)
+	mu.Lock()
+	down = true
+	mu.Unlock()
+
+	stalled := make(chan error, 1)
+	go func() {
+		_, err := authenticator.Authenticate(ctx, token)
+		stalled <- err
+	}()
+	for fetchCount() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The refresh above hangs on the provider; other requests must not wait for it.
+	served := make(chan error, 1)
+	go func() {
+		_, err := authenticator.Authenticate(ctx, token)
+		served <- err
+	}()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Expected the cached key to be used during the refresh, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Request waited for the JWKS refresh in flight")
+	}
+
+	close(release)
+	if err := <-stalled; err != nil {
+		t.Errorf("Expected a failed refresh to fall back to cached keys, got %v", err)
+	}
+	if _, err := authenticator.Authenticate(ctx, token); err != nil || fetchCount() > 3 {
+		t.Errorf("Expected failed refreshes to back off, got %v after %d fetches", err, fetchCount())
+	}
+}
+
+func TestJWTFromJWKSFile(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate EC key: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	data, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{jwkFor("file-1", ecKey.Public())}})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("Failed to write JWKS: %v", err)
+	}
+
+	store := newTestStorage(t, os.Getenv("TEST_STORAGE_DRIVER"))
+	defer store.Close()
+	authenticator, err := auth.NewJWT(store, auth.JWTConfig{JWKS: path})
+	if err != nil {
+		t.Fatalf("NewJWT: %v", err)
+	}
+
+	userID := "jwt-file-" + fmt.Sprint(time.Now().UnixNano())
+	mustCreateTeam(t, store, &models.Team{TeamName: userID, Members: []models.TeamMember{{UserID: userID, Username: "File", IsActive: true}}})
+
+	token := signJWT(t, "ES256", "", ecKey, map[string]interface{}{"sub": userID, "role": models.RoleAdmin, "exp": time.Now().Add(time.Minute).Unix()})
+	principal, err := authenticator.Authenticate(context.Background(), token)
+	if err != nil || principal.UserID != userID || principal.Role != models.RoleAdmin {
+		t.Fatalf("Expected an admin principal for %s, got %+v, %v", userID, principal, err)
+	}
+}
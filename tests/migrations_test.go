@@ -0,0 +1,134 @@
+package tests
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/Chamistery/Test_task/internal/storage"
+	"github.com/Chamistery/Test_task/internal/storage/migrations"
+)
+
+func TestMigrationsUpDownStatus(t *testing.T) {
+	db, err := storage.ConnectSQLite(filepath.Join(t.TempDir(), "migrate.db"))
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	migrator, err := migrations.New(db, "sqlite")
+	if err != nil {
+		t.Fatalf("Failed to load migrations: %v", err)
+	}
+
+	statuses, err := migrator.Status()
+	if err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	if len(statuses) == 0 || statuses[0].Version != 1 || statuses[0].AppliedAt != nil {
+		t.Fatalf("Expected pending baseline migration, got %+v", statuses)
+	}
+
+	applied, err := migrator.Up()
+	if err != nil {
+		t.Fatalf("Up failed: %v", err)
+	}
+	if len(applied) != len(statuses) {
+		t.Errorf("Expected %d migrations applied, got %d", len(statuses), len(applied))
+	}
+
+	applied, err = migrator.Up()
+	if err != nil || len(applied) != 0 {
+		t.Errorf("Expected second Up to be a no-op, got %v, %v", applied, err)
+	}
+
+	statuses, _ = migrator.Status()
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			t.Errorf("Expected migration %04d_%s to be applied", status.Version, status.Name)
+		}
+	}
+
+	if _, err := db.Exec("INSERT INTO teams (team_name) VALUES ('migrated')"); err != nil {
+		t.Fatalf("Expected teams table after Up: %v", err)
+	}
+
+	reverted, err := migrator.Down(len(statuses))
+	if err != nil || len(reverted) != len(statuses) {
+		t.Fatalf("Expected all migrations reverted, got %v, %v", reverted, err)
+	}
+	if reverted[0].Version < reverted[len(reverted)-1].Version {
+		t.Errorf("Expected migrations reverted newest first, got %v", reverted)
+	}
+
+	if _, err := db.Exec("SELECT 1 FROM teams"); err == nil {
+		t.Error("Expected teams table to be dropped after Down")
+	}
+
+	reverted, err = migrator.Down(1)
+	if err != nil || len(reverted) != 0 {
+		t.Errorf("Expected Down on empty schema to be a no-op, got %v, %v", reverted, err)
+	}
+}
+
+func TestMigrationsConcurrentUp(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "concurrent.db")
+
+	const replicas = 4
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	total := 0
+
+	for i := 0; i < replicas; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			db, err := storage.ConnectSQLite(path)
+			if err != nil {
+				t.Errorf("Failed to open database: %v", err)
+				return
+			}
+			defer db.Close()
+
+			migrator, err := migrations.New(db, "sqlite")
+			if err != nil {
+				t.Errorf("Failed to load migrations: %v", err)
+				return
+			}
+
+			applied, err := migrator.Up()
+			if err != nil {
+				t.Errorf("Up failed: %v", err)
+				return
+			}
+
+			mu.Lock()
+			total += len(applied)
+			mu.Unlock()
+		}()
+	}
+
+	wg.Wait()
+
+	db, err := storage.ConnectSQLite(path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	var recorded int
+	if err := db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&recorded); err != nil {
+		t.Fatalf("Failed to count migrations: %v", err)
+	}
+
+	if total != recorded {
+		t.Errorf("Expected each migration applied exactly once across replicas, applied %d, recorded %d", total, recorded)
+	}
+}
+
+func TestMigrationsUnknownDriver(t *testing.T) {
+	if _, err := migrations.New(nil, "oracle"); err == nil {
+		t.Error("Expected unknown driver to be rejected")
+	}
+}
@@ -0,0 +1,265 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Chamistery/Test_task/internal/events"
+	"github.com/Chamistery/Test_task/internal/models"
+	"github.com/Chamistery/Test_task/internal/storage"
+)
+
+type recordingSink struct {
+	name     string
+	failures int
+
+	mu     sync.Mutex
+	events []models.DomainEvent
+}
+
+func (s *recordingSink) Name() string {
+	return s.name
+}
+
+func (s *recordingSink) Deliver(ctx context.Context, event models.DomainEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("temporarily unavailable")
+	}
+	s.events = append(s.events, event)
+	return nil
+}
+
+func (s *recordingSink) types() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var types []string
+	for _, event := range s.events {
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+func TestDispatcherRetriesInOrder(t *testing.T) {
+	store := newTestStorage(t, os.Getenv("TEST_STORAGE_DRIVER"))
+	defer store.Close()
+
+	ctx := context.Background()
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	author, reviewer := "ob-u1-"+suffix, "ob-u2-"+suffix
+	mustCreateTeam(t, store, &models.Team{
+		TeamName: "outbox-" + suffix,
+		Members: []models.TeamMember{
+			{UserID: author, Username: "Author", IsActive: true},
+			{UserID: reviewer, Username: "Reviewer", IsActive: true},
+		},
+	})
+	mustCreatePR(t, store, &models.PullRequest{PullRequestID: "pr-outbox-" + suffix, PullRequestName: "Outbox", AuthorID: author, AssignedReviewers: []string{reviewer}})
+
+	dispatcher := events.NewDispatcher(store, events.DispatcherConfig{BatchSize: 1000, BaseBackoff: time.Nanosecond})
+	if delivered, err := dispatcher.DispatchPending(ctx); err != nil || delivered != 0 {
+		t.Fatalf("Expected events to stay pending without sinks, got %d, %v", delivered, err)
+	}
+
+	steady := &recordingSink{name: "steady"}
+	flaky := &recordingSink{name: "flaky", failures: 1}
+	dispatcher.Register(steady)
+	dispatcher.Register(flaky)
+
+	if _, err := dispatcher.DispatchPending(ctx); err == nil {
+		t.Fatal("Expected the flaky sink to fail the first dispatch")
+	}
+	if len(flaky.types()) != 0 {
+		t.Fatalf("Expected nothing delivered past the failure, got %v", flaky.types())
+	}
+
+	if _, err := dispatcher.DispatchPending(ctx); err != nil {
+		t.Fatalf("DispatchPending: %v", err)
+	}
+
+	expected := []string{models.EventPRCreated, models.EventReviewerAssigned}
+	if got := flaky.types(); fmt.Sprint(got[len(got)-2:]) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v delivered in order after the retry, got %v", expected, got)
+	}
+	if got := steady.types(); len(got) != len(flaky.types())+1 || got[0] != got[1] {
+		t.Errorf("Expected the failed event to be redelivered to every sink, got %v", got)
+	}
+
+	if delivered, err := dispatcher.DispatchPending(ctx); err != nil || delivered != 0 {
+		t.Errorf("Expected delivered events to leave the outbox, got %d, %v", delivered, err)
+	}
+}
+
+func TestDispatcherGivesUpOnPoisonEvents(t *testing.T) {
+	store := newTestStorage(t, os.Getenv("TEST_STORAGE_DRIVER"))
+	defer store.Close()
+
+	ctx := context.Background()
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	author, reviewer := "ob-p1-"+suffix, "ob-p2-"+suffix
+	mustCreateTeam(t, store, &models.Team{
+		TeamName: "poison-" + suffix,
+		Members: []models.TeamMember{
+			{UserID: author, Username: "Author", IsActive: true},
+			{UserID: reviewer, Username: "Reviewer", IsActive: true},
+		},
+	})
+	poisonPR, healthyPR := "pr-poison-"+suffix, "pr-healthy-"+suffix
+	mustCreatePR(t, store, &models.PullRequest{PullRequestID: poisonPR, PullRequestName: "Poison", AuthorID: author, AssignedReviewers: []string{reviewer}})
+	mustCreatePR(t, store, &models.PullRequest{PullRequestID: healthyPR, PullRequestName: "Healthy", AuthorID: author})
+
+	var mu sync.Mutex
+	delivered := map[string][]string{}
+	dispatcher := events.NewDispatcher(store, events.DispatcherConfig{BatchSize: 1000, MaxAttempts: 3, BaseBackoff: time.Nanosecond})
+	dispatcher.Register(events.SinkFunc{SinkName: "picky", Fn: func(ctx context.Context, event models.DomainEvent) error {
+		if event.AggregateID == poisonPR && event.Type == models.EventPRCreated {
+			return errors.New("cannot decode event")
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		delivered[event.AggregateID] = append(delivered[event.AggregateID], event.Type)
+		return nil
+	}})
+
+	for range 3 {
+		if _, err := dispatcher.DispatchPending(ctx); err == nil {
+			t.Fatal("Expected the poison event to fail its dispatch")
+		}
+	}
+	mu.Lock()
+	healthy, poison := delivered[healthyPR], delivered[poisonPR]
+	mu.Unlock()
+	if fmt.Sprint(healthy) != fmt.Sprint([]string{models.EventPRCreated}) || len(poison) != 0 {
+		t.Fatalf("Expected only the other aggregate delivered while the poison event retries, got %v and %v", healthy, poison)
+	}
+
+	pending, err := store.GetPendingEvents(ctx, 1000)
+	if err != nil {
+		t.Fatalf("GetPendingEvents: %v", err)
+	}
+	for _, event := range pending {
+		if event.AggregateID == poisonPR && event.Type == models.EventPRCreated {
+			t.Errorf("Expected the poison event to be given up after 3 attempts, got %+v", event)
+		}
+	}
+
+	if _, err := dispatcher.DispatchPending(ctx); err != nil {
+		t.Fatalf("DispatchPending: %v", err)
+	}
+	mu.Lock()
+	poison = delivered[poisonPR]
+	mu.Unlock()
+	if fmt.Sprint(poison) != fmt.Sprint([]string{models.EventReviewerAssigned}) {
+		t.Errorf("Expected the aggregate to move on past the given-up event, got %v", poison)
+	}
+}
+
+func TestDispatchersShareOutboxWithoutDuplicates(t *testing.T) {
+	store := newTestStorage(t, os.Getenv("TEST_STORAGE_DRIVER"))
+	defer store.Close()
+
+	ctx := context.Background()
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	author, reviewer := "ob-r1-"+suffix, "ob-r2-"+suffix
+	mustCreateTeam(t, store, &models.Team{
+		TeamName: "replicas-" + suffix,
+		Members: []models.TeamMember{
+			{UserID: author, Username: "Author", IsActive: true},
+			{UserID: reviewer, Username: "Reviewer", IsActive: true},
+		},
+	})
+	for i := range 10 {
+		mustCreatePR(t, store, &models.PullRequest{PullRequestID: fmt.Sprintf("pr-replica-%d-%s", i, suffix), PullRequestName: "Replica", AuthorID: author, AssignedReviewers: []string{reviewer}})
+	}
+
+	var mu sync.Mutex
+	deliveries := map[int64]int{}
+	var wg sync.WaitGroup
+	for i := range 4 {
+		dispatcher := events.NewDispatcher(store, events.DispatcherConfig{BatchSize: 3})
+		dispatcher.Register(events.SinkFunc{SinkName: fmt.Sprint("replica-", i), Fn: func(ctx context.Context, event models.DomainEvent) error {
+			mu.Lock()
+			defer mu.Unlock()
+			deliveries[event.ID]++
+			return nil
+		}})
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				delivered, err := dispatcher.DispatchPending(ctx)
+				if err != nil {
+					t.Errorf("DispatchPending: %v", err)
+					return
+				}
+				if delivered == 0 {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if pending, err := store.GetPendingEvents(ctx, 1000); err != nil || len(pending) != 0 {
+		t.Fatalf("Expected the replicas to drain the outbox, got %d pending, %v", len(pending), err)
+	}
+	if len(deliveries) != 20 {
+		t.Errorf("Expected 20 events delivered, got %d", len(deliveries))
+	}
+	for id, count := range deliveries {
+		if count != 1 {
+			t.Errorf("Expected event %d delivered once, got %d", id, count)
+		}
+	}
+}
+
+func TestDispatcherDeliversEventsCommittedBeforeRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.db")
+	store, err := storage.NewSQLiteStorage(storage.SQLiteConfig{Path: path})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	mustCreateTeam(t, store, &models.Team{TeamName: "restart", Members: []models.TeamMember{{UserID: "rs-author", Username: "Author", IsActive: true}}})
+	mustCreatePR(t, store, &models.PullRequest{PullRequestID: "pr-restart", PullRequestName: "Restart", AuthorID: "rs-author"})
+	store.Close()
+
+	store, err = storage.NewSQLiteStorage(storage.SQLiteConfig{Path: path})
+	if err != nil {
+		t.Fatalf("Failed to reopen storage: %v", err)
+	}
+	defer store.Close()
+
+	sink := &recordingSink{name: "after-restart"}
+	dispatcher := events.NewDispatcher(store, events.DispatcherConfig{PollInterval: 10 * time.Millisecond})
+	dispatcher.Register(sink)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		dispatcher.Run(ctx)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(sink.types()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if got := sink.types(); fmt.Sprint(got) != fmt.Sprint([]string{models.EventPRCreated}) {
+		t.Errorf("Expected the event committed before restart to be delivered once, got %v", got)
+	}
+}
@@ -0,0 +1,144 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Chamistery/Test_task/internal/handlers"
+	"github.com/Chamistery/Test_task/internal/models"
+	"github.com/Chamistery/Test_task/internal/router"
+	"github.com/Chamistery/Test_task/internal/storage"
+)
+
+// syncBuffer collects log lines that the server writes after responding.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestRouterMethodsAndMiddleware(t *testing.T) {
+	var logs syncBuffer
+	h := handlers.NewHandlers(storage.NewMemoryStorage())
+	server := httptest.NewServer(router.New(h, router.Config{
+		MaxBodyBytes:   256,
+		AllowedOrigins: router.ParseOrigins("https://app.example.com/, https://admin.example.com"),
+		Logger:         log.New(&logs, "", 0),
+	}))
+	defer server.Close()
+
+	do := func(method, path string, body io.Reader, header map[string]string) (*http.Response, models.ErrorResponse) {
+		req, _ := http.NewRequest(method, server.URL+path, body)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		defer resp.Body.Close()
+
+		var errResp models.ErrorResponse
+		json.NewDecoder(resp.Body).Decode(&errResp)
+		return resp, errResp
+	}
+
+	resp, errResp := do("GET", "/team/add", nil, nil)
+	if resp.StatusCode != http.StatusMethodNotAllowed || errResp.Error.Code != models.ErrMethodNotAllowed || resp.Header.Get("Allow") != "POST" {
+		t.Errorf("Expected 405 JSON allowing POST, got %d %s Allow=%q", resp.StatusCode, errResp.Error.Code, resp.Header.Get("Allow"))
+	}
+
+	resp, errResp = do("POST", "/team/get", nil, nil)
+	if resp.StatusCode != http.StatusMethodNotAllowed || !strings.Contains(resp.Header.Get("Allow"), "GET") {
+		t.Errorf("Expected 405 allowing GET, got %d Allow=%q", resp.StatusCode, resp.Header.Get("Allow"))
+	}
+
+	resp, errResp = do("GET", "/team/nothing", nil, nil)
+	if resp.StatusCode != http.StatusNotFound || errResp.Error.Code != models.ErrNotFound {
+		t.Errorf("Expected 404 JSON, got %d %s", resp.StatusCode, errResp.Error.Code)
+	}
+
+	resp, _ = do("GET", "/statistics", nil, map[string]string{router.RequestIDHeader: "trace-42"})
+	if resp.StatusCode != http.StatusOK || resp.Header.Get(router.RequestIDHeader) != "trace-42" {
+		t.Errorf("Expected the request ID to be echoed, got %d %q", resp.StatusCode, resp.Header.Get(router.RequestIDHeader))
+	}
+
+	resp, _ = do("GET", "/statistics", nil, map[string]string{router.RequestIDHeader: "bad id\twith spaces"})
+	if generated := resp.Header.Get(router.RequestIDHeader); len(generated) != 32 {
+		t.Errorf("Expected an unusable request ID to be replaced, got %q", generated)
+	}
+	// The connection is reused, so the previous request has been logged by now.
+	if !strings.Contains(logs.String(), "GET /statistics 200") || !strings.Contains(logs.String(), "request_id=trace-42") {
+		t.Errorf("Expected the request to be logged with its ID, got %q", logs.String())
+	}
+
+	resp, _ = do("OPTIONS", "/team/add", nil, map[string]string{"Origin": "https://app.example.com", "Access-Control-Request-Method": "POST"})
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		!strings.Contains(resp.Header.Get("Access-Control-Allow-Headers"), "Authorization") {
+		t.Errorf("Expected the preflight to be answered, got %d %v", resp.StatusCode, resp.Header)
+	}
+
+	resp, _ = do("OPTIONS", "/team/add", nil, map[string]string{"Origin": "https://evil.example.com", "Access-Control-Request-Method": "POST"})
+	if resp.Header.Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("Expected no CORS headers for an unknown origin, got %v", resp.Header)
+	}
+
+	resp, _ = do("GET", "/statistics", nil, map[string]string{"Origin": "https://admin.example.com"})
+	if resp.Header.Get("Access-Control-Allow-Origin") != "https://admin.example.com" || resp.Header.Get("Access-Control-Expose-Headers") != router.RequestIDHeader {
+		t.Errorf("Expected CORS headers on a simple request, got %v", resp.Header)
+	}
+
+	team, _ := json.Marshal(models.Team{TeamName: "router-" + fmt.Sprint(time.Now().UnixNano()), Members: []models.TeamMember{
+		{UserID: "router-u1", Username: strings.Repeat("x", 512), IsActive: true},
+	}})
+	resp, errResp = do("POST", "/team/add", bytes.NewReader(team), map[string]string{"Content-Type": "application/json"})
+	if resp.StatusCode != http.StatusRequestEntityTooLarge || errResp.Error.Code != models.ErrTooLarge {
+		t.Errorf("Expected 413 for an oversized body, got %d %s", resp.StatusCode, errResp.Error.Code)
+	}
+}
+
+func TestRouterRecoversFromPanics(t *testing.T) {
+	var logs syncBuffer
+	logger := log.New(&logs, "", 0)
+	server := httptest.NewServer(router.RequestID(router.Recover(logger, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("handler exploded")
+	}))))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/boom")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var errResp models.ErrorResponse
+	json.NewDecoder(resp.Body).Decode(&errResp)
+	if resp.StatusCode != http.StatusInternalServerError || errResp.Error.Code != models.ErrInternal {
+		t.Errorf("Expected a 500 JSON error, got %d %s", resp.StatusCode, errResp.Error.Code)
+	}
+
+	id := resp.Header.Get(router.RequestIDHeader)
+	if !strings.Contains(logs.String(), "handler exploded") || !strings.Contains(logs.String(), "request_id="+id) {
+		t.Errorf("Expected the panic to be logged with request %s, got %q", id, logs.String())
+	}
+}
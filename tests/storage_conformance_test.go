@@ -0,0 +1,1523 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Chamistery/Test_task/internal/models"
+	"github.com/Chamistery/Test_task/internal/service"
+	"github.com/Chamistery/Test_task/internal/storage"
+)
+
+func TestMemoryStorageConformance(t *testing.T) {
+	runStorageConformance(t, func(t *testing.T) storage.Storage {
+		return newTestStorage(t, "memory")
+	})
+}
+
+func TestSQLiteStorageConformance(t *testing.T) {
+	runStorageConformance(t, func(t *testing.T) storage.Storage {
+		return newTestStorage(t, "sqlite")
+	})
+}
+
+func TestPostgresStorageConformance(t *testing.T) {
+	if os.Getenv("TEST_STORAGE_DRIVER") != "postgres" {
+		t.Skip("set TEST_STORAGE_DRIVER=postgres to run against PostgreSQL")
+	}
+
+	runStorageConformance(t, func(t *testing.T) storage.Storage {
+		return newTestStorage(t, "postgres")
+	})
+}
+
+func runStorageConformance(t *testing.T, newStore func(t *testing.T) storage.Storage) {
+	cases := []struct {
+		name string
+		run  func(t *testing.T, store storage.Storage, suffix string)
+	}{
+		{"Teams", conformTeams},
+		{"Users", conformUsers},
+		{"PullRequestLifecycle", conformPullRequestLifecycle},
+		{"CloseReopenReady", conformCloseReopenReady},
+		{"DraftMerge", conformDraftMerge},
+		{"Reviewers", conformReviewers},
+		{"CandidatesAndCapacity", conformCandidatesAndCapacity},
+		{"BulkDeactivate", conformBulkDeactivate},
+		{"DeactivateWithFallback", conformDeactivateWithFallback},
+		{"Statistics", conformStatistics},
+		{"ConcurrentWrites", conformConcurrentWrites},
+		{"CancelledContext", conformCancelledContext},
+		{"UnitOfWork", conformUnitOfWork},
+		{"LockedReassign", conformLockedReassign},
+		{"Audit", conformAudit},
+		{"ReviewerHistory", conformReviewerHistory},
+		{"Outbox", conformOutbox},
+		{"Webhooks", conformWebhooks},
+		{"ExternalAccounts", conformExternalAccounts},
+		{"InboundDeliveries", conformInboundDeliveries},
+		{"APIKeys", conformAPIKeys},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			store := newStore(t)
+			defer store.Close()
+
+			tc.run(t, store, fmt.Sprint(time.Now().UnixNano()))
+		})
+	}
+}
+
+func mustCreateTeam(t *testing.T, store storage.Storage, team *models.Team) {
+	t.Helper()
+	ctx := context.Background()
+	if err := store.CreateTeam(ctx, team); err != nil {
+		t.Fatalf("CreateTeam(%s): %v", team.TeamName, err)
+	}
+}
+
+func mustCreatePR(t *testing.T, store storage.Storage, pr *models.PullRequest) {
+	t.Helper()
+	ctx := context.Background()
+	if err := store.CreatePullRequest(ctx, pr); err != nil {
+		t.Fatalf("CreatePullRequest(%s): %v", pr.PullRequestID, err)
+	}
+}
+
+func mustGetPR(t *testing.T, store storage.Storage, prID string) *models.PullRequest {
+	t.Helper()
+	ctx := context.Background()
+	pr, err := store.GetPullRequest(ctx, prID)
+	if err != nil {
+		t.Fatalf("GetPullRequest(%s): %v", prID, err)
+	}
+	if pr == nil {
+		t.Fatalf("GetPullRequest(%s): not found", prID)
+	}
+	return pr
+}
+
+func conformTeams(t *testing.T, store storage.Storage, suffix string) {
+	ctx := context.Background()
+	backup := &models.Team{TeamName: "cf-backup-" + suffix}
+	mustCreateTeam(t, store, backup)
+
+	team := &models.Team{
+		TeamName:          "cf-team-" + suffix,
+		ReviewersRequired: 3,
+		FallbackTeams:     []string{backup.TeamName},
+		Members: []models.TeamMember{
+			{UserID: "cf-b-" + suffix, Username: "Bob", IsActive: true, MaxOpenReviews: 2},
+			{UserID: "cf-a-" + suffix, Username: "Alice", IsActive: false},
+		},
+	}
+	mustCreateTeam(t, store, team)
+
+	if err := store.CreateTeam(ctx, &models.Team{TeamName: team.TeamName}); err == nil {
+		t.Error("Expected duplicate team creation to fail")
+	}
+
+	got, err := store.GetTeam(ctx, team.TeamName)
+	if err != nil || got == nil {
+		t.Fatalf("GetTeam: %v, %v", got, err)
+	}
+	if got.AssignmentStrategy != models.StrategyRandom || got.ReviewersRequired != 3 || got.ApprovalsRequired != 0 {
+		t.Errorf("Unexpected team settings %+v", got)
+	}
+	if len(got.FallbackTeams) != 1 || got.FallbackTeams[0] != backup.TeamName {
+		t.Errorf("Expected fallback teams [%s], got %v", backup.TeamName, got.FallbackTeams)
+	}
+	if len(got.Members) != 2 || got.Members[0].UserID != "cf-a-"+suffix || got.Members[1].MaxOpenReviews != 2 {
+		t.Errorf("Expected members sorted by user_id with limits, got %+v", got.Members)
+	}
+
+	if missing, err := store.GetTeam(ctx, "cf-missing-"+suffix); missing != nil || err != nil {
+		t.Errorf("Expected missing team to be (nil, nil), got (%v, %v)", missing, err)
+	}
+	if exists, _ := store.TeamExists(ctx, team.TeamName); !exists {
+		t.Error("Expected TeamExists to report created team")
+	}
+	if settings, err := store.GetTeamSettings(ctx, "cf-missing-"+suffix); settings != nil || err != nil {
+		t.Errorf("Expected missing settings to be (nil, nil), got (%v, %v)", settings, err)
+	}
+
+	err = store.UpdateTeamSettings(ctx, &models.TeamSettings{
+		TeamName:           team.TeamName,
+		AssignmentStrategy: models.StrategyLeastLoaded,
+		ReviewersRequired:  1,
+		ApprovalsRequired:  1,
+		FallbackTeams:      []string{},
+	})
+	if err != nil {
+		t.Fatalf("UpdateTeamSettings: %v", err)
+	}
+
+	settings, err := store.GetTeamSettings(ctx, team.TeamName)
+	if err != nil || settings == nil {
+		t.Fatalf("GetTeamSettings: %v, %v", settings, err)
+	}
+	if settings.AssignmentStrategy != models.StrategyLeastLoaded || settings.ReviewersRequired != 1 ||
+		settings.ApprovalsRequired != 1 || len(settings.FallbackTeams) != 0 {
+		t.Errorf("Unexpected settings after update %+v", settings)
+	}
+
+	err = store.UpdateTeamSettings(ctx, &models.TeamSettings{TeamName: "cf-missing-" + suffix, ReviewersRequired: 1})
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected sql.ErrNoRows updating missing team, got %v", err)
+	}
+}
+
+func conformUsers(t *testing.T, store storage.Storage, suffix string) {
+	ctx := context.Background()
+	first := &models.Team{
+		TeamName: "cf-users-1-" + suffix,
+		Members:  []models.TeamMember{{UserID: "cf-user-" + suffix, Username: "Carol", IsActive: true}},
+	}
+	second := &models.Team{TeamName: "cf-users-2-" + suffix}
+	mustCreateTeam(t, store, first)
+	mustCreateTeam(t, store, second)
+
+	userID := "cf-user-" + suffix
+	if err := store.UpsertUser(ctx, &models.TeamMember{UserID: userID, Username: "Caroline", IsActive: true}, second.TeamName); err != nil {
+		t.Fatalf("UpsertUser: %v", err)
+	}
+
+	user, err := store.GetUser(ctx, userID)
+	if err != nil || user == nil {
+		t.Fatalf("GetUser: %v, %v", user, err)
+	}
+	if user.Username != "Caroline" || user.TeamName != second.TeamName {
+		t.Errorf("Expected user moved to %s as Caroline, got %+v", second.TeamName, user)
+	}
+
+	user.IsActive = false
+	user.MaxOpenReviews = 4
+	if err := store.UpdateUser(ctx, user); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	if err := store.SetUserIsActive(ctx, userID, true); err != nil {
+		t.Fatalf("SetUserIsActive: %v", err)
+	}
+
+	user, _ = store.GetUser(ctx, userID)
+	if !user.IsActive || user.MaxOpenReviews != 4 {
+		t.Errorf("Expected active user with limit 4, got %+v", user)
+	}
+
+	if teamName, _ := store.GetUserTeam(ctx, userID); teamName != second.TeamName {
+		t.Errorf("Expected team %s, got %s", second.TeamName, teamName)
+	}
+
+	missingID := "cf-nobody-" + suffix
+	if missing, err := store.GetUser(ctx, missingID); missing != nil || err != nil {
+		t.Errorf("Expected missing user to be (nil, nil), got (%v, %v)", missing, err)
+	}
+	if teamName, err := store.GetUserTeam(ctx, missingID); teamName != "" || err != nil {
+		t.Errorf("Expected missing user team to be empty, got (%q, %v)", teamName, err)
+	}
+	if err := store.SetUserIsActive(ctx, missingID, false); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected sql.ErrNoRows from SetUserIsActive, got %v", err)
+	}
+	if err := store.UpdateUser(ctx, &models.User{UserID: missingID}); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected sql.ErrNoRows from UpdateUser, got %v", err)
+	}
+}
+
+func conformPullRequestLifecycle(t *testing.T, store storage.Storage, suffix string) {
+	ctx := context.Background()
+	author, rev1, rev2 := "cf-pl-a-"+suffix, "cf-pl-r1-"+suffix, "cf-pl-r2-"+suffix
+	mustCreateTeam(t, store, &models.Team{
+		TeamName:          "cf-pl-" + suffix,
+		ApprovalsRequired: 1,
+		Members: []models.TeamMember{
+			{UserID: author, Username: "Author", IsActive: true},
+			{UserID: rev1, Username: "Rev1", IsActive: true},
+			{UserID: rev2, Username: "Rev2", IsActive: true},
+		},
+	})
+
+	pr := &models.PullRequest{
+		PullRequestID:     "cf-pl-pr-" + suffix,
+		PullRequestName:   "Lifecycle",
+		AuthorID:          author,
+		AssignedReviewers: []string{rev2, rev1},
+		ReviewersRequired: 2,
+	}
+	mustCreatePR(t, store, pr)
+
+	if err := store.CreatePullRequest(ctx, &models.PullRequest{PullRequestID: pr.PullRequestID, PullRequestName: "Dup", AuthorID: author}); err == nil {
+		t.Error("Expected duplicate pull request creation to fail")
+	}
+	if exists, _ := store.PRExists(ctx, pr.PullRequestID); !exists {
+		t.Error("Expected PRExists to report created pull request")
+	}
+
+	got := mustGetPR(t, store, pr.PullRequestID)
+	if got.Status != "OPEN" || got.CreatedAt == nil || got.ReviewersRequired != 2 {
+		t.Errorf("Unexpected created pull request %+v", got)
+	}
+	if len(got.AssignedReviewers) != 2 || got.AssignedReviewers[0] != rev1 || got.AssignedReviewers[1] != rev2 {
+		t.Errorf("Expected reviewers sorted by user_id, got %v", got.AssignedReviewers)
+	}
+	for _, review := range got.Reviews {
+		if review.State != models.ReviewPending || review.SubmittedAt != nil {
+			t.Errorf("Expected pending review, got %+v", review)
+		}
+	}
+
+	if missing, err := store.GetPullRequest(ctx, "cf-missing-"+suffix); missing != nil || err != nil {
+		t.Errorf("Expected missing pull request to be (nil, nil), got (%v, %v)", missing, err)
+	}
+
+	if _, err := store.MergePullRequest(ctx, pr.PullRequestID, false); !errors.Is(err, storage.ErrNotApproved) {
+		t.Errorf("Expected ErrNotApproved without approvals, got %v", err)
+	}
+
+	if err := store.SubmitReview(ctx, pr.PullRequestID, rev1, models.ReviewApproved); err != nil {
+		t.Fatalf("SubmitReview: %v", err)
+	}
+	if err := store.SubmitReview(ctx, pr.PullRequestID, rev2, models.ReviewChangesRequested); err != nil {
+		t.Fatalf("SubmitReview: %v", err)
+	}
+	if err := store.SubmitReview(ctx, pr.PullRequestID, author, models.ReviewApproved); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected sql.ErrNoRows reviewing as non-reviewer, got %v", err)
+	}
+
+	if _, err := store.MergePullRequest(ctx, pr.PullRequestID, false); !errors.Is(err, storage.ErrNotApproved) {
+		t.Errorf("Expected ErrNotApproved with changes requested, got %v", err)
+	}
+
+	if err := store.SubmitReview(ctx, pr.PullRequestID, rev2, models.ReviewCommented); err != nil {
+		t.Fatalf("SubmitReview: %v", err)
+	}
+
+	merged, err := store.MergePullRequest(ctx, pr.PullRequestID, false)
+	if err != nil || merged == nil {
+		t.Fatalf("MergePullRequest: %v, %v", merged, err)
+	}
+	if merged.Status != "MERGED" || merged.MergedAt == nil || merged.ForceMerged {
+		t.Errorf("Unexpected merged pull request %+v", merged)
+	}
+
+	again, err := store.MergePullRequest(ctx, pr.PullRequestID, true)
+	if err != nil || again.ForceMerged || !again.MergedAt.Equal(*merged.MergedAt) {
+		t.Errorf("Expected repeated merge to be a no-op, got %+v, %v", again, err)
+	}
+
+	if _, err := store.ClosePullRequest(ctx, pr.PullRequestID); !errors.Is(err, storage.ErrPRMerged) {
+		t.Errorf("Expected ErrPRMerged closing merged pull request, got %v", err)
+	}
+
+	forced := &models.PullRequest{PullRequestID: "cf-pl-force-" + suffix, PullRequestName: "Force", AuthorID: author, AssignedReviewers: []string{rev1}}
+	mustCreatePR(t, store, forced)
+
+	merged, err = store.MergePullRequest(ctx, forced.PullRequestID, true)
+	if err != nil || !merged.ForceMerged {
+		t.Errorf("Expected forced merge to bypass approvals, got %+v, %v", merged, err)
+	}
+
+	if missing, err := store.MergePullRequest(ctx, "cf-missing-"+suffix, false); missing != nil || err != nil {
+		t.Errorf("Expected merging missing pull request to be (nil, nil), got (%v, %v)", missing, err)
+	}
+}
+
+func conformCloseReopenReady(t *testing.T, store storage.Storage, suffix string) {
+	ctx := context.Background()
+	author := "cf-cr-a-" + suffix
+	mustCreateTeam(t, store, &models.Team{
+		TeamName: "cf-cr-" + suffix,
+		Members:  []models.TeamMember{{UserID: author, Username: "Author", IsActive: true}},
+	})
+
+	pr := &models.PullRequest{PullRequestID: "cf-cr-pr-" + suffix, PullRequestName: "Draft", AuthorID: author, IsDraft: true}
+	mustCreatePR(t, store, pr)
+
+	if got := mustGetPR(t, store, pr.PullRequestID); !got.IsDraft {
+		t.Error("Expected pull request to be a draft")
+	}
+
+	ready, err := store.MarkPullRequestReady(ctx, pr.PullRequestID)
+	if err != nil || ready.IsDraft {
+		t.Errorf("Expected draft to be marked ready, got %+v, %v", ready, err)
+	}
+
+	closed, err := store.ClosePullRequest(ctx, pr.PullRequestID)
+	if err != nil || closed.Status != "CLOSED" || closed.ClosedAt == nil {
+		t.Fatalf("Expected closed pull request, got %+v, %v", closed, err)
+	}
+
+	if _, err := store.MarkPullRequestReady(ctx, pr.PullRequestID); !errors.Is(err, storage.ErrPRClosed) {
+		t.Errorf("Expected ErrPRClosed marking closed pull request ready, got %v", err)
+	}
+	if _, err := store.MergePullRequest(ctx, pr.PullRequestID, true); !errors.Is(err, storage.ErrPRClosed) {
+		t.Errorf("Expected ErrPRClosed merging closed pull request, got %v", err)
+	}
+
+	again, err := store.ClosePullRequest(ctx, pr.PullRequestID)
+	if err != nil || !again.ClosedAt.Equal(*closed.ClosedAt) {
+		t.Errorf("Expected repeated close to be a no-op, got %+v, %v", again, err)
+	}
+
+	reopened, err := store.ReopenPullRequest(ctx, pr.PullRequestID)
+	if err != nil || reopened.Status != "OPEN" || reopened.ClosedAt != nil {
+		t.Errorf("Expected reopened pull request, got %+v, %v", reopened, err)
+	}
+
+	for name, call := range map[string]func(context.Context, string) (*models.PullRequest, error){
+		"close":  store.ClosePullRequest,
+		"reopen": store.ReopenPullRequest,
+		"ready":  store.MarkPullRequestReady,
+	} {
+		if missing, err := call(ctx, "cf-missing-"+suffix); missing != nil || err != nil {
+			t.Errorf("Expected %s on missing pull request to be (nil, nil), got (%v, %v)", name, missing, err)
+		}
+	}
+}
+
+func conformDraftMerge(t *testing.T, store storage.Storage, suffix string) {
+	ctx := context.Background()
+	author := "cf-dm-a-" + suffix
+	mustCreateTeam(t, store, &models.Team{
+		TeamName: "cf-dm-" + suffix,
+		Members:  []models.TeamMember{{UserID: author, Username: "Author", IsActive: true}},
+	})
+
+	draft := &models.PullRequest{PullRequestID: "cf-dm-pr1-" + suffix, PullRequestName: "Draft", AuthorID: author, IsDraft: true}
+	mustCreatePR(t, store, draft)
+
+	if _, err := store.MergePullRequest(ctx, draft.PullRequestID, false); !errors.Is(err, storage.ErrPRDraft) {
+		t.Errorf("Expected ErrPRDraft merging a draft, got %v", err)
+	}
+	if got := mustGetPR(t, store, draft.PullRequestID); got.Status != "OPEN" || !got.IsDraft {
+		t.Errorf("Expected the refused draft to stay open, got %+v", got)
+	}
+
+	forced, err := store.MergePullRequest(ctx, draft.PullRequestID, true)
+	if err != nil || forced.Status != "MERGED" || !forced.ForceMerged {
+		t.Errorf("Expected a forced merge of a draft to succeed, got %+v, %v", forced, err)
+	}
+
+	ready := &models.PullRequest{PullRequestID: "cf-dm-pr2-" + suffix, PullRequestName: "Ready", AuthorID: author, IsDraft: true}
+	mustCreatePR(t, store, ready)
+	if _, err := store.MarkPullRequestReady(ctx, ready.PullRequestID); err != nil {
+		t.Fatalf("MarkPullRequestReady: %v", err)
+	}
+
+	merged, err := store.MergePullRequest(ctx, ready.PullRequestID, false)
+	if err != nil || merged.Status != "MERGED" || merged.ForceMerged {
+		t.Errorf("Expected a PR marked ready to merge, got %+v, %v", merged, err)
+	}
+}
+
+func conformReviewers(t *testing.T, store storage.Storage, suffix string) {
+	ctx := context.Background()
+	author, rev1, rev2, rev3 := "cf-rv-a-"+suffix, "cf-rv-r1-"+suffix, "cf-rv-r2-"+suffix, "cf-rv-r3-"+suffix
+	mustCreateTeam(t, store, &models.Team{
+		TeamName: "cf-rv-" + suffix,
+		Members: []models.TeamMember{
+			{UserID: author, Username: "Author", IsActive: true},
+			{UserID: rev1, Username: "Rev1", IsActive: true},
+			{UserID: rev2, Username: "Rev2", IsActive: true},
+			{UserID: rev3, Username: "Rev3", IsActive: true},
+		},
+	})
+
+	pr := &models.PullRequest{PullRequestID: "cf-rv-pr-" + suffix, PullRequestName: "Reviewers", AuthorID: author, AssignedReviewers: []string{rev1}}
+	mustCreatePR(t, store, pr)
+
+	if err := store.SubmitReview(ctx, pr.PullRequestID, rev1, models.ReviewApproved); err != nil {
+		t.Fatalf("SubmitReview: %v", err)
+	}
+
+	if err := store.ReassignReviewer(ctx, pr.PullRequestID, rev1, rev2); err != nil {
+		t.Fatalf("ReassignReviewer: %v", err)
+	}
+
+	if assigned, _ := store.IsReviewerAssigned(ctx, pr.PullRequestID, rev1); assigned {
+		t.Error("Expected old reviewer to be unassigned")
+	}
+	got := mustGetPR(t, store, pr.PullRequestID)
+	if len(got.Reviews) != 1 || got.Reviews[0].UserID != rev2 || got.Reviews[0].State != models.ReviewPending {
+		t.Errorf("Expected pending review for new reviewer, got %+v", got.Reviews)
+	}
+
+	if err := store.ReplaceReviewers(ctx, pr.PullRequestID, nil, []string{rev2}, models.AssignmentCapacity); err == nil {
+		t.Error("Expected adding an already assigned reviewer to fail")
+	}
+	if got := mustGetPR(t, store, pr.PullRequestID); len(got.AssignedReviewers) != 1 {
+		t.Errorf("Expected failed replacement to leave reviewers untouched, got %v", got.AssignedReviewers)
+	}
+
+	if err := store.ReplaceReviewers(ctx, pr.PullRequestID, []string{rev2}, []string{rev1, rev3}, models.AssignmentCapacity); err != nil {
+		t.Fatalf("ReplaceReviewers: %v", err)
+	}
+
+	got = mustGetPR(t, store, pr.PullRequestID)
+	if len(got.AssignedReviewers) != 2 || got.AssignedReviewers[0] != rev1 || got.AssignedReviewers[1] != rev3 {
+		t.Errorf("Expected reviewers [%s %s], got %v", rev1, rev3, got.AssignedReviewers)
+	}
+
+	if err := store.SubmitReview(ctx, pr.PullRequestID, rev3, models.ReviewCommented); err != nil {
+		t.Fatalf("SubmitReview: %v", err)
+	}
+
+	prs, err := store.GetPRsByReviewer(ctx, rev3)
+	if err != nil || len(prs) != 1 {
+		t.Fatalf("GetPRsByReviewer: %v, %v", prs, err)
+	}
+	if prs[0].ReviewState != models.ReviewCommented || prs[0].NeedsAction {
+		t.Errorf("Expected commented review not needing action, got %+v", prs[0])
+	}
+
+	prs, _ = store.GetPRsByReviewer(ctx, rev1)
+	if len(prs) != 1 || !prs[0].NeedsAction {
+		t.Errorf("Expected pending review to need action, got %+v", prs)
+	}
+
+	if prs, err := store.GetPRsByReviewer(ctx, "cf-nobody-"+suffix); err != nil || prs == nil || len(prs) != 0 {
+		t.Errorf("Expected empty non-nil list for unknown reviewer, got %v, %v", prs, err)
+	}
+}
+
+func conformCandidatesAndCapacity(t *testing.T, store storage.Storage, suffix string) {
+	ctx := context.Background()
+	teamName := "cf-cc-" + suffix
+	author, busy, free, idle := "cf-cc-a-"+suffix, "cf-cc-busy-"+suffix, "cf-cc-free-"+suffix, "cf-cc-idle-"+suffix
+	mustCreateTeam(t, store, &models.Team{
+		TeamName: teamName,
+		Members: []models.TeamMember{
+			{UserID: author, Username: "Author", IsActive: true},
+			{UserID: busy, Username: "Busy", IsActive: true, MaxOpenReviews: 1},
+			{UserID: free, Username: "Free", IsActive: true, MaxOpenReviews: 2},
+			{UserID: idle, Username: "Idle", IsActive: false},
+		},
+	})
+
+	open := &models.PullRequest{PullRequestID: "cf-cc-open-" + suffix, PullRequestName: "Open", AuthorID: author, AssignedReviewers: []string{busy, free}}
+	mustCreatePR(t, store, open)
+
+	merged := &models.PullRequest{PullRequestID: "cf-cc-merged-" + suffix, PullRequestName: "Merged", AuthorID: author, AssignedReviewers: []string{free}}
+	mustCreatePR(t, store, merged)
+	if _, err := store.MergePullRequest(ctx, merged.PullRequestID, true); err != nil {
+		t.Fatalf("MergePullRequest: %v", err)
+	}
+
+	candidates, err := store.GetActiveCandidates(ctx, teamName, []string{author})
+	if err != nil {
+		t.Fatalf("GetActiveCandidates: %v", err)
+	}
+	if len(candidates) != 1 || candidates[0] != free {
+		t.Errorf("Expected only %s to have capacity, got %v", free, candidates)
+	}
+
+	saturated, err := store.CountSaturatedCandidates(ctx, teamName, []string{author})
+	if err != nil || saturated != 1 {
+		t.Errorf("Expected 1 saturated candidate, got %d, %v", saturated, err)
+	}
+
+	counts, err := store.GetOpenReviewCounts(ctx, []string{busy, free, idle})
+	if err != nil {
+		t.Fatalf("GetOpenReviewCounts: %v", err)
+	}
+	if counts[busy] != 1 || counts[free] != 1 || counts[idle] != 0 || len(counts) != 3 {
+		t.Errorf("Expected open counts {busy:1 free:1 idle:0}, got %v", counts)
+	}
+
+	if candidates, err := store.GetActiveCandidates(ctx, "cf-missing-"+suffix, nil); err != nil || len(candidates) != 0 {
+		t.Errorf("Expected no candidates for missing team, got %v, %v", candidates, err)
+	}
+}
+
+func conformBulkDeactivate(t *testing.T, store storage.Storage, suffix string) {
+	ctx := context.Background()
+	devTeam, qaTeam := "cf-bd-dev-"+suffix, "cf-bd-qa-"+suffix
+	author, peer1, peer2 := "cf-bd-a-"+suffix, "cf-bd-p1-"+suffix, "cf-bd-p2-"+suffix
+	qa1, qa2 := "cf-bd-q1-"+suffix, "cf-bd-q2-"+suffix
+
+	mustCreateTeam(t, store, &models.Team{
+		TeamName: devTeam,
+		Members: []models.TeamMember{
+			{UserID: author, Username: "Author", IsActive: true},
+			{UserID: peer1, Username: "Peer1", IsActive: true},
+			{UserID: peer2, Username: "Peer2", IsActive: true},
+		},
+	})
+	mustCreateTeam(t, store, &models.Team{
+		TeamName: qaTeam,
+		Members: []models.TeamMember{
+			{UserID: qa1, Username: "QA1", IsActive: true},
+			{UserID: qa2, Username: "QA2", IsActive: true},
+		},
+	})
+
+	replaced := &models.PullRequest{PullRequestID: "cf-bd-pr1-" + suffix, PullRequestName: "Replaced", AuthorID: author, AssignedReviewers: []string{peer1, qa1}}
+	kept := &models.PullRequest{PullRequestID: "cf-bd-pr2-" + suffix, PullRequestName: "Kept", AuthorID: author, AssignedReviewers: []string{peer1, peer2, qa1}}
+	merged := &models.PullRequest{PullRequestID: "cf-bd-pr3-" + suffix, PullRequestName: "Merged", AuthorID: author, AssignedReviewers: []string{qa2}}
+	for _, pr := range []*models.PullRequest{replaced, kept, merged} {
+		mustCreatePR(t, store, pr)
+	}
+	if _, err := store.MergePullRequest(ctx, merged.PullRequestID, true); err != nil {
+		t.Fatalf("MergePullRequest: %v", err)
+	}
+
+	type pick struct {
+		prID       string
+		count      int
+		candidates []string
+	}
+	var picks []pick
+	deactivated, reassigned, err := store.BulkDeactivateTeamMembers(ctx, qaTeam, func(ctx context.Context, tx storage.Storage, pr *models.PullRequest, count int) ([]string, error) {
+		candidates, err := tx.GetActiveCandidates(ctx, devTeam, append(append([]string{}, pr.AssignedReviewers...), pr.AuthorID))
+		picks = append(picks, pick{pr.PullRequestID, count, candidates})
+		if err != nil || len(candidates) == 0 {
+			return nil, err
+		}
+		return candidates[:1], nil
+	})
+	if err != nil {
+		t.Fatalf("BulkDeactivateTeamMembers: %v", err)
+	}
+
+	if deactivated != 2 || reassigned != 2 {
+		t.Errorf("Expected 2 deactivated and 2 reassigned, got %d and %d", deactivated, reassigned)
+	}
+
+	if len(picks) != 1 {
+		t.Fatalf("Expected one pick for the PR below its target, got %+v", picks)
+	}
+	if picks[0].prID != replaced.PullRequestID || picks[0].count != 1 || len(picks[0].candidates) != 1 || picks[0].candidates[0] != peer2 {
+		t.Errorf("Expected to pick 1 of [%s] for %s, got %+v", peer2, replaced.PullRequestID, picks[0])
+	}
+
+	if got := mustGetPR(t, store, replaced.PullRequestID); len(got.AssignedReviewers) != 2 || got.AssignedReviewers[0] != peer1 || got.AssignedReviewers[1] != peer2 {
+		t.Errorf("Expected deactivated reviewer replaced by %s, got %v", peer2, got.AssignedReviewers)
+	}
+	if got := mustGetPR(t, store, kept.PullRequestID); len(got.AssignedReviewers) != 2 {
+		t.Errorf("Expected surplus deactivated reviewer dropped, got %v", got.AssignedReviewers)
+	}
+	if got := mustGetPR(t, store, merged.PullRequestID); len(got.AssignedReviewers) != 1 || got.AssignedReviewers[0] != qa2 {
+		t.Errorf("Expected merged pull request untouched, got %v", got.AssignedReviewers)
+	}
+
+	for _, userID := range []string{qa1, qa2} {
+		if user, _ := store.GetUser(ctx, userID); user == nil || user.IsActive {
+			t.Errorf("Expected %s to be deactivated, got %+v", userID, user)
+		}
+	}
+
+	deactivated, reassigned, err = store.BulkDeactivateTeamMembers(ctx, qaTeam, func(context.Context, storage.Storage, *models.PullRequest, int) ([]string, error) {
+		t.Error("Expected no pick when nobody is active")
+		return nil, nil
+	})
+	if err != nil || deactivated != 0 || reassigned != 0 {
+		t.Errorf("Expected repeated deactivation to be a no-op, got %d, %d, %v", deactivated, reassigned, err)
+	}
+}
+
+// Team deactivation refills reviews from the author's fallback teams once the
+// author's own team has nobody left, like new assignments do.
+func conformDeactivateWithFallback(t *testing.T, store storage.Storage, suffix string) {
+	ctx := context.Background()
+	backupTeam, devTeam, qaTeam := "cf-df-backup-"+suffix, "cf-df-dev-"+suffix, "cf-df-qa-"+suffix
+	author, peer, backup, qa := "cf-df-a-"+suffix, "cf-df-p-"+suffix, "cf-df-b-"+suffix, "cf-df-q-"+suffix
+
+	mustCreateTeam(t, store, &models.Team{TeamName: backupTeam, Members: []models.TeamMember{{UserID: backup, Username: "Backup", IsActive: true}}})
+	mustCreateTeam(t, store, &models.Team{
+		TeamName:      devTeam,
+		FallbackTeams: []string{backupTeam},
+		Members: []models.TeamMember{
+			{UserID: author, Username: "Author", IsActive: true},
+			{UserID: peer, Username: "Peer", IsActive: true},
+		},
+	})
+	mustCreateTeam(t, store, &models.Team{TeamName: qaTeam, Members: []models.TeamMember{{UserID: qa, Username: "QA", IsActive: true}}})
+
+	prID := "cf-df-pr-" + suffix
+	mustCreatePR(t, store, &models.PullRequest{PullRequestID: prID, PullRequestName: "Fallback", AuthorID: author, AssignedReviewers: []string{peer, qa}})
+
+	deactivated, reassigned, err := service.NewReviewerService(store).DeactivateTeam(ctx, qaTeam)
+	if err != nil || deactivated != 1 || reassigned != 1 {
+		t.Fatalf("Expected 1 deactivated and 1 reassigned, got %d, %d, %v", deactivated, reassigned, err)
+	}
+
+	got := mustGetPR(t, store, prID)
+	if len(got.AssignedReviewers) != 2 || !slices.Contains(got.AssignedReviewers, peer) || !slices.Contains(got.AssignedReviewers, backup) {
+		t.Errorf("Expected %s replaced by fallback reviewer %s, got %v", qa, backup, got.AssignedReviewers)
+	}
+}
+
+func conformStatistics(t *testing.T, store storage.Storage, suffix string) {
+	ctx := context.Background()
+	before, err := store.GetStatistics(ctx)
+	if err != nil {
+		t.Fatalf("GetStatistics: %v", err)
+	}
+
+	author, reviewer := "cf-st-a-"+suffix, "cf-st-r-"+suffix
+	authorName, reviewerName := "StatsAuthor"+suffix, "StatsReviewer"+suffix
+	mustCreateTeam(t, store, &models.Team{
+		TeamName: "cf-st-" + suffix,
+		Members: []models.TeamMember{
+			{UserID: author, Username: authorName, IsActive: true},
+			{UserID: reviewer, Username: reviewerName, IsActive: true},
+		},
+	})
+
+	prs := []*models.PullRequest{
+		{PullRequestID: "cf-st-open-" + suffix, PullRequestName: "Open", AuthorID: author, AssignedReviewers: []string{reviewer}},
+		{PullRequestID: "cf-st-draft-" + suffix, PullRequestName: "Draft", AuthorID: author, IsDraft: true},
+		{PullRequestID: "cf-st-merged-" + suffix, PullRequestName: "Merged", AuthorID: author, AssignedReviewers: []string{reviewer}},
+		{PullRequestID: "cf-st-closed-" + suffix, PullRequestName: "Closed", AuthorID: author},
+	}
+	for _, pr := range prs {
+		mustCreatePR(t, store, pr)
+	}
+	if _, err := store.MergePullRequest(ctx, prs[2].PullRequestID, true); err != nil {
+		t.Fatalf("MergePullRequest: %v", err)
+	}
+	if _, err := store.ClosePullRequest(ctx, prs[3].PullRequestID); err != nil {
+		t.Fatalf("ClosePullRequest: %v", err)
+	}
+
+	after, err := store.GetStatistics(ctx)
+	if err != nil {
+		t.Fatalf("GetStatistics: %v", err)
+	}
+
+	if after.TotalPRs-before.TotalPRs != 4 || after.OpenPRs-before.OpenPRs != 1 || after.DraftPRs-before.DraftPRs != 1 ||
+		after.MergedPRs-before.MergedPRs != 1 || after.ClosedPRs-before.ClosedPRs != 1 {
+		t.Errorf("Unexpected status counts before %+v after %+v", before, after)
+	}
+	if after.ReviewerAssignments[reviewerName] != 2 || after.PRsByAuthor[authorName] != 4 {
+		t.Errorf("Expected 2 assignments and 4 authored PRs, got %v and %v", after.ReviewerAssignments, after.PRsByAuthor)
+	}
+	if after.AverageReviewersPerPR <= 0 {
+		t.Errorf("Expected positive average reviewers per PR, got %f", after.AverageReviewersPerPR)
+	}
+}
+
+func conformConcurrentWrites(t *testing.T, store storage.Storage, suffix string) {
+	ctx := context.Background()
+	author := "cf-cw-a-" + suffix
+	members := []models.TeamMember{{UserID: author, Username: "Author", IsActive: true}}
+	for i := 0; i < 5; i++ {
+		members = append(members, models.TeamMember{UserID: fmt.Sprintf("cf-cw-r%d-%s", i, suffix), Username: fmt.Sprintf("Rev%d", i), IsActive: true})
+	}
+	teamName := "cf-cw-" + suffix
+	mustCreateTeam(t, store, &models.Team{TeamName: teamName, Members: members})
+
+	const workers = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*2)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			reviewer := members[1+i%5].UserID
+			prID := fmt.Sprintf("cf-cw-pr%d-%s", i, suffix)
+			if err := store.CreatePullRequest(ctx, &models.PullRequest{PullRequestID: prID, PullRequestName: "Concurrent", AuthorID: author, AssignedReviewers: []string{reviewer}}); err != nil {
+				errs <- err
+				return
+			}
+			if err := store.SubmitReview(ctx, prID, reviewer, models.ReviewApproved); err != nil {
+				errs <- err
+			}
+			if _, err := store.GetActiveCandidates(ctx, teamName, []string{author}); err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("Concurrent write failed: %v", err)
+	}
+
+	counts, err := store.GetOpenReviewCounts(ctx, []string{members[1].UserID})
+	if err != nil || counts[members[1].UserID] != workers/5 {
+		t.Errorf("Expected %d open reviews for %s, got %v, %v", workers/5, members[1].UserID, counts, err)
+	}
+}
+
+func conformCancelledContext(t *testing.T, store storage.Storage, suffix string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	teamName := "cf-cancel-" + suffix
+	err := store.CreateTeam(ctx, &models.Team{TeamName: teamName})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from CreateTeam, got %v", err)
+	}
+
+	if _, err := store.GetTeam(ctx, teamName); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from GetTeam, got %v", err)
+	}
+
+	if exists, err := store.TeamExists(context.Background(), teamName); err != nil || exists {
+		t.Errorf("Expected cancelled CreateTeam to leave no team behind, got %v, %v", exists, err)
+	}
+}
+
+func conformUnitOfWork(t *testing.T, store storage.Storage, suffix string) {
+	ctx := context.Background()
+	author := "cf-uow-a-" + suffix
+	teamName := "cf-uow-" + suffix
+	mustCreateTeam(t, store, &models.Team{TeamName: teamName, Members: []models.TeamMember{{UserID: author, Username: "Author", IsActive: true}}})
+
+	if err := store.CreateTeam(ctx, &models.Team{TeamName: teamName}); !errors.Is(err, storage.ErrTeamExists) {
+		t.Errorf("Expected ErrTeamExists for duplicate team, got %v", err)
+	}
+
+	committed := "cf-uow-ok-" + suffix
+	err := store.WithinTx(ctx, func(tx storage.Storage) error {
+		if err := tx.CreatePullRequest(ctx, &models.PullRequest{PullRequestID: committed, PullRequestName: "Committed", AuthorID: author}); err != nil {
+			return err
+		}
+		pr, err := tx.GetPullRequest(ctx, committed)
+		if err != nil || pr == nil {
+			return fmt.Errorf("expected PR to be visible inside the transaction, got %v, %v", pr, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WithinTx: %v", err)
+	}
+	mustGetPR(t, store, committed)
+
+	if err := store.CreatePullRequest(ctx, &models.PullRequest{PullRequestID: committed, PullRequestName: "Duplicate", AuthorID: author}); !errors.Is(err, storage.ErrPRExists) {
+		t.Errorf("Expected ErrPRExists for duplicate PR, got %v", err)
+	}
+
+	rolledBack := "cf-uow-rb-" + suffix
+	errRollback := errors.New("rollback")
+	err = store.WithinTx(ctx, func(tx storage.Storage) error {
+		if err := tx.CreatePullRequest(ctx, &models.PullRequest{PullRequestID: rolledBack, PullRequestName: "Rolled back", AuthorID: author}); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Errorf("Expected WithinTx to return the callback error, got %v", err)
+	}
+	if exists, err := store.PRExists(ctx, rolledBack); err != nil || exists {
+		t.Errorf("Expected failed unit of work to be rolled back, got %v, %v", exists, err)
+	}
+
+	const workers = 10
+	raced := "cf-uow-race-" + suffix
+	var wg sync.WaitGroup
+	results := make(chan error, workers)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results <- store.WithinTx(ctx, func(tx storage.Storage) error {
+				exists, err := tx.PRExists(ctx, raced)
+				if err != nil {
+					return err
+				}
+				if exists {
+					return storage.ErrPRExists
+				}
+				return tx.CreatePullRequest(ctx, &models.PullRequest{PullRequestID: raced, PullRequestName: "Raced", AuthorID: author})
+			})
+		}()
+	}
+
+	wg.Wait()
+	close(results)
+
+	created := 0
+	for err := range results {
+		switch {
+		case err == nil:
+			created++
+		case !errors.Is(err, storage.ErrPRExists):
+			t.Errorf("Expected ErrPRExists for losing creators, got %v", err)
+		}
+	}
+	if created != 1 {
+		t.Errorf("Expected exactly one concurrent create to succeed, got %d", created)
+	}
+}
+
+func conformLockedReassign(t *testing.T, store storage.Storage, suffix string) {
+	ctx := context.Background()
+	author := "cf-lr-a-" + suffix
+	members := []models.TeamMember{{UserID: author, Username: "Author", IsActive: true}}
+	for i := 0; i < 4; i++ {
+		members = append(members, models.TeamMember{UserID: fmt.Sprintf("cf-lr-r%d-%s", i, suffix), Username: fmt.Sprintf("Rev%d", i), IsActive: true})
+	}
+	mustCreateTeam(t, store, &models.Team{TeamName: "cf-lr-" + suffix, Members: members})
+
+	if pr, err := store.GetPullRequestForUpdate(ctx, "cf-lr-missing-"+suffix); pr != nil || err != nil {
+		t.Errorf("Expected missing PR to be (nil, nil), got (%v, %v)", pr, err)
+	}
+
+	prID := "cf-lr-pr-" + suffix
+	mustCreatePR(t, store, &models.PullRequest{PullRequestID: prID, PullRequestName: "Locked", AuthorID: author, AssignedReviewers: []string{members[1].UserID}})
+
+	err := store.WithinTx(ctx, func(tx storage.Storage) error {
+		pr, err := tx.GetPullRequestForUpdate(ctx, prID)
+		if err != nil || pr == nil || len(pr.AssignedReviewers) != 1 {
+			return fmt.Errorf("expected locked PR with one reviewer, got %+v, %v", pr, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WithinTx: %v", err)
+	}
+
+	if err := store.ReassignReviewer(ctx, prID, members[2].UserID, members[3].UserID); !errors.Is(err, storage.ErrNotAssigned) {
+		t.Errorf("Expected ErrNotAssigned for unassigned reviewer, got %v", err)
+	}
+
+	const workers = 3
+	var wg sync.WaitGroup
+	results := make(chan error, workers)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results <- store.WithinTx(ctx, func(tx storage.Storage) error {
+				if _, err := tx.GetPullRequestForUpdate(ctx, prID); err != nil {
+					return err
+				}
+				return tx.ReassignReviewer(ctx, prID, members[1].UserID, members[2+i%3].UserID)
+			})
+		}(i)
+	}
+
+	wg.Wait()
+	close(results)
+
+	reassigned := 0
+	for err := range results {
+		switch {
+		case err == nil:
+			reassigned++
+		case !errors.Is(err, storage.ErrNotAssigned):
+			t.Errorf("Expected ErrNotAssigned for losing reassigns, got %v", err)
+		}
+	}
+	if reassigned != 1 {
+		t.Errorf("Expected exactly one reassign to win, got %d", reassigned)
+	}
+	if pr := mustGetPR(t, store, prID); len(pr.AssignedReviewers) != 1 || pr.AssignedReviewers[0] == members[1].UserID {
+		t.Errorf("Expected a single replacement reviewer, got %v", pr.AssignedReviewers)
+	}
+}
+
+func conformAudit(t *testing.T, store storage.Storage, suffix string) {
+	ctx := storage.WithAuditActor(context.Background(), "cf-auditor")
+	author := "cf-au-a-" + suffix
+	reviewers := []string{"cf-au-r1-" + suffix, "cf-au-r2-" + suffix, "cf-au-r3-" + suffix}
+	teamName := "cf-au-" + suffix
+	start := time.Now().Add(-time.Second)
+
+	team := &models.Team{TeamName: teamName, Members: []models.TeamMember{{UserID: author, Username: "Author", IsActive: true}}}
+	for _, reviewer := range reviewers {
+		team.Members = append(team.Members, models.TeamMember{UserID: reviewer, Username: reviewer, IsActive: true})
+	}
+	if err := store.CreateTeam(ctx, team); err != nil {
+		t.Fatalf("CreateTeam: %v", err)
+	}
+
+	prID := "cf-au-pr-" + suffix
+	if err := store.CreatePullRequest(ctx, &models.PullRequest{PullRequestID: prID, PullRequestName: "Audited", AuthorID: author, AssignedReviewers: reviewers[:1]}); err != nil {
+		t.Fatalf("CreatePullRequest: %v", err)
+	}
+	if err := store.ReassignReviewer(storage.WithAuditReason(ctx, "on vacation"), prID, reviewers[0], reviewers[1]); err != nil {
+		t.Fatalf("ReassignReviewer: %v", err)
+	}
+	if err := store.SetUserIsActive(ctx, reviewers[2], false); err != nil {
+		t.Fatalf("SetUserIsActive: %v", err)
+	}
+	if err := store.SetUserIsActive(ctx, reviewers[2], false); err != nil {
+		t.Fatalf("SetUserIsActive: %v", err)
+	}
+	if err := store.SubmitReview(ctx, prID, reviewers[1], models.ReviewApproved); err != nil {
+		t.Fatalf("SubmitReview: %v", err)
+	}
+	if _, err := store.MergePullRequest(ctx, prID, true); err != nil {
+		t.Fatalf("MergePullRequest: %v", err)
+	}
+
+	draftID := "cf-au-draft-" + suffix
+	if err := store.CreatePullRequest(ctx, &models.PullRequest{PullRequestID: draftID, PullRequestName: "Draft", AuthorID: author, IsDraft: true}); err != nil {
+		t.Fatalf("CreatePullRequest: %v", err)
+	}
+	for range 2 {
+		if _, err := store.ClosePullRequest(ctx, draftID); err != nil {
+			t.Fatalf("ClosePullRequest: %v", err)
+		}
+	}
+	if _, err := store.ReopenPullRequest(ctx, draftID); err != nil {
+		t.Fatalf("ReopenPullRequest: %v", err)
+	}
+	for range 2 {
+		if _, err := store.MarkPullRequestReady(ctx, draftID); err != nil {
+			t.Fatalf("MarkPullRequestReady: %v", err)
+		}
+	}
+	settings := &models.TeamSettings{TeamName: teamName, AssignmentStrategy: models.StrategyRandom, ReviewersRequired: 1, ApprovalsRequired: 1, FallbackTeams: []string{}}
+	if err := store.UpdateTeamSettings(ctx, settings); err != nil {
+		t.Fatalf("UpdateTeamSettings: %v", err)
+	}
+
+	errRollback := errors.New("rollback")
+	err := store.WithinTx(ctx, func(tx storage.Storage) error {
+		if err := tx.SetUserIsActive(ctx, author, false); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("Expected rollback error, got %v", err)
+	}
+
+	events, err := store.GetAuditEvents(ctx, models.AuditFilter{TeamName: teamName})
+	if err != nil {
+		t.Fatalf("GetAuditEvents: %v", err)
+	}
+
+	var types []string
+	for _, event := range events {
+		types = append(types, event.Type)
+		if event.Actor != "cf-auditor" || event.TeamName != teamName {
+			t.Errorf("Expected actor and team on every event, got %+v", event)
+		}
+	}
+	expected := []string{
+		models.AuditTeamCreated,
+		models.AuditPRCreated,
+		models.AuditReviewerReassigned,
+		models.AuditUserActivityChanged,
+		models.AuditReviewSubmitted,
+		models.AuditPRMerged,
+		models.AuditPRCreated,
+		models.AuditPRClosed,
+		models.AuditPRReopened,
+		models.AuditPRReady,
+		models.AuditTeamSettingsUpdated,
+	}
+	if fmt.Sprint(types) != fmt.Sprint(expected) {
+		t.Fatalf("Expected events %v, got %v", expected, types)
+	}
+
+	reassigned := events[2]
+	if reassigned.Reason != "on vacation" || reassigned.PullRequestID != prID {
+		t.Errorf("Expected reassign reason and PR to be recorded, got %+v", reassigned)
+	}
+	if string(reassigned.Before) != `{"assigned_reviewers":["`+reviewers[0]+`"]}` || string(reassigned.After) != `{"assigned_reviewers":["`+reviewers[1]+`"]}` {
+		t.Errorf("Expected before/after reviewer sets, got %s -> %s", reassigned.Before, reassigned.After)
+	}
+	if fmt.Sprint(reassigned.UserIDs) != fmt.Sprint(reviewers[:2]) {
+		t.Errorf("Expected reassign to involve %v, got %v", reviewers[:2], reassigned.UserIDs)
+	}
+
+	reviewed := events[4]
+	if string(reviewed.Before) != `{"review_state":"PENDING"}` || string(reviewed.After) != `{"review_state":"APPROVED"}` ||
+		fmt.Sprint(reviewed.UserIDs) != fmt.Sprint(reviewers[1:2]) {
+		t.Errorf("Expected the review decision to be recorded, got %+v", reviewed)
+	}
+
+	closed, ready := events[7], events[9]
+	if closed.PullRequestID != draftID || string(closed.Before) != `{"status":"OPEN"}` || string(closed.After) != `{"status":"CLOSED"}` {
+		t.Errorf("Expected the status change to be recorded, got %+v", closed)
+	}
+	if ready.PullRequestID != draftID || string(ready.After) != `{"is_draft":false}` {
+		t.Errorf("Expected the draft flag change to be recorded, got %+v", ready)
+	}
+
+	var updated models.TeamSettings
+	if err := json.Unmarshal(events[10].After, &updated); err != nil || updated.AssignmentStrategy != models.StrategyRandom || updated.ApprovalsRequired != 1 {
+		t.Errorf("Expected the new team settings to be recorded, got %s, %v", events[10].After, err)
+	}
+
+	byUser, err := store.GetAuditEvents(ctx, models.AuditFilter{UserID: reviewers[0]})
+	if err != nil || len(byUser) != 3 {
+		t.Errorf("Expected team, create and reassign events for %s, got %d, %v", reviewers[0], len(byUser), err)
+	}
+
+	byPR, err := store.GetAuditEvents(ctx, models.AuditFilter{PullRequestID: prID, AfterID: events[1].ID, Limit: 1})
+	if err != nil || len(byPR) != 1 || byPR[0].Type != models.AuditReviewerReassigned {
+		t.Errorf("Expected paging to return the reassign event, got %+v, %v", byPR, err)
+	}
+
+	future := time.Now().Add(time.Hour)
+	if later, err := store.GetAuditEvents(ctx, models.AuditFilter{TeamName: teamName, Since: &future}); err != nil || len(later) != 0 {
+		t.Errorf("Expected no events after %v, got %d, %v", future, len(later), err)
+	}
+	if earlier, err := store.GetAuditEvents(ctx, models.AuditFilter{TeamName: teamName, Until: &start}); err != nil || len(earlier) != 0 {
+		t.Errorf("Expected no events before %v, got %d, %v", start, len(earlier), err)
+	}
+}
+
+func conformReviewerHistory(t *testing.T, store storage.Storage, suffix string) {
+	ctx := context.Background()
+	author := "cf-rh-a-" + suffix
+	rev1 := "cf-rh-r1-" + suffix
+	rev2 := "cf-rh-r2-" + suffix
+	rev3 := "cf-rh-r3-" + suffix
+
+	mustCreateTeam(t, store, &models.Team{
+		TeamName: "cf-rh-" + suffix,
+		Members: []models.TeamMember{
+			{UserID: author, Username: "Author", IsActive: true},
+			{UserID: rev1, Username: rev1, IsActive: true},
+			{UserID: rev2, Username: rev2, IsActive: true},
+			{UserID: rev3, Username: rev3, IsActive: true},
+		},
+	})
+
+	prID := "cf-rh-pr-" + suffix
+	mustCreatePR(t, store, &models.PullRequest{PullRequestID: prID, PullRequestName: "History", AuthorID: author, AssignedReviewers: []string{rev1}})
+
+	if err := store.ReassignReviewer(ctx, prID, rev1, rev2); err != nil {
+		t.Fatalf("ReassignReviewer: %v", err)
+	}
+	if err := store.ReplaceReviewers(ctx, prID, []string{rev2}, []string{rev3}, models.AssignmentDeactivation); err != nil {
+		t.Fatalf("ReplaceReviewers: %v", err)
+	}
+	if err := store.ReplaceReviewers(ctx, prID, nil, []string{rev1}, models.AssignmentCapacity); err != nil {
+		t.Fatalf("ReplaceReviewers: %v", err)
+	}
+
+	history, err := store.GetReviewerHistory(ctx, prID)
+	if err != nil {
+		t.Fatalf("GetReviewerHistory: %v", err)
+	}
+
+	expected := []struct {
+		userID, assignReason, unassignReason string
+	}{
+		{rev1, models.AssignmentInitial, models.AssignmentManualReassign},
+		{rev2, models.AssignmentManualReassign, models.AssignmentDeactivation},
+		{rev3, models.AssignmentDeactivation, ""},
+		{rev1, models.AssignmentCapacity, ""},
+	}
+	if len(history) != len(expected) {
+		t.Fatalf("Expected %d assignments, got %+v", len(expected), history)
+	}
+	for i, want := range expected {
+		got := history[i]
+		if got.UserID != want.userID || got.AssignReason != want.assignReason || got.UnassignReason != want.unassignReason {
+			t.Errorf("Assignment %d: expected %+v, got %+v", i, want, got)
+		}
+		if (got.UnassignedAt != nil) != (want.unassignReason != "") {
+			t.Errorf("Assignment %d: unexpected unassigned_at %v", i, got.UnassignedAt)
+		}
+		if got.UnassignedAt != nil && got.UnassignedAt.Before(got.AssignedAt) {
+			t.Errorf("Assignment %d: unassigned before assigned", i)
+		}
+	}
+
+	stats, err := store.GetStatistics(ctx)
+	if err != nil {
+		t.Fatalf("GetStatistics: %v", err)
+	}
+	if stats.ReviewerAssignments[rev1] != 2 || stats.ReviewerAssignments[rev2] != 1 {
+		t.Errorf("Expected statistics to count past assignments, got %v", stats.ReviewerAssignments)
+	}
+
+	if missing, err := store.GetReviewerHistory(ctx, "cf-rh-missing-"+suffix); err != nil || len(missing) != 0 {
+		t.Errorf("Expected empty history for unknown PR, got %v, %v", missing, err)
+	}
+}
+
+func conformOutbox(t *testing.T, store storage.Storage, suffix string) {
+	ctx := context.Background()
+	devTeam, qaTeam := "cf-ob-dev-"+suffix, "cf-ob-qa-"+suffix
+	author, dev1, dev2, qa1 := "cf-ob-a-"+suffix, "cf-ob-d1-"+suffix, "cf-ob-d2-"+suffix, "cf-ob-q1-"+suffix
+
+	mustCreateTeam(t, store, &models.Team{
+		TeamName: devTeam,
+		Members: []models.TeamMember{
+			{UserID: author, Username: "Author", IsActive: true},
+			{UserID: dev1, Username: "Dev1", IsActive: true},
+			{UserID: dev2, Username: "Dev2", IsActive: true},
+		},
+	})
+	mustCreateTeam(t, store, &models.Team{TeamName: qaTeam, Members: []models.TeamMember{{UserID: qa1, Username: "QA1", IsActive: true}}})
+
+	prID := "cf-ob-pr-" + suffix
+	mustCreatePR(t, store, &models.PullRequest{PullRequestID: prID, PullRequestName: "Outbox", AuthorID: author, AssignedReviewers: []string{dev1}})
+
+	if err := store.ReassignReviewer(ctx, prID, dev1, qa1); err != nil {
+		t.Fatalf("ReassignReviewer: %v", err)
+	}
+	if err := store.ReplaceReviewers(ctx, prID, nil, []string{dev1}, models.AssignmentCapacity); err != nil {
+		t.Fatalf("ReplaceReviewers: %v", err)
+	}
+	if _, _, err := service.NewReviewerService(store).DeactivateTeam(ctx, qaTeam); err != nil {
+		t.Fatalf("BulkDeactivateTeamMembers: %v", err)
+	}
+
+	errRollback := errors.New("rollback")
+	err := store.WithinTx(ctx, func(tx storage.Storage) error {
+		if _, err := tx.MergePullRequest(ctx, prID, true); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("Expected rollback error, got %v", err)
+	}
+
+	if err := store.SubmitReview(ctx, prID, dev1, models.ReviewApproved); err != nil {
+		t.Fatalf("SubmitReview: %v", err)
+	}
+	if _, err := store.MergePullRequest(ctx, prID, true); err != nil {
+		t.Fatalf("MergePullRequest: %v", err)
+	}
+	for range 2 {
+		if err := store.SetUserIsActive(ctx, dev2, false); err != nil {
+			t.Fatalf("SetUserIsActive: %v", err)
+		}
+	}
+
+	pending := func() []models.DomainEvent {
+		events, err := store.GetPendingEvents(ctx, 1000)
+		if err != nil {
+			t.Fatalf("GetPendingEvents: %v", err)
+		}
+
+		var own []models.DomainEvent
+		for _, event := range events {
+			if strings.HasSuffix(event.AggregateID, suffix) {
+				own = append(own, event)
+			}
+		}
+		return own
+	}
+
+	events := pending()
+	var types []string
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	expected := []string{
+		models.EventPRCreated,
+		models.EventReviewerAssigned,
+		models.EventReviewerReplaced,
+		models.EventReviewerAssigned,
+		models.EventUserDeactivated,
+		models.EventReviewerReplaced,
+		models.EventTeamDeactivated,
+		models.EventReviewSubmitted,
+		models.EventPRMerged,
+		models.EventUserDeactivated,
+	}
+	if fmt.Sprint(types) != fmt.Sprint(expected) {
+		t.Fatalf("Expected events %v, got %v", expected, types)
+	}
+
+	var replaced models.ReviewerReplacedPayload
+	if err := json.Unmarshal(events[5].Payload, &replaced); err != nil {
+		t.Fatalf("Failed to decode payload %s: %v", events[5].Payload, err)
+	}
+	if replaced.PullRequestID != prID || fmt.Sprint(replaced.RemovedUserIDs) != fmt.Sprint([]string{qa1}) ||
+		fmt.Sprint(replaced.AddedUserIDs) != fmt.Sprint([]string{dev2}) || replaced.Reason != models.AssignmentDeactivation {
+		t.Errorf("Unexpected replacement payload %+v", replaced)
+	}
+
+	claim := func(now time.Time) []int64 {
+		events, err := store.ClaimDueEvents(ctx, now, time.Minute, 1000)
+		if err != nil {
+			t.Fatalf("ClaimDueEvents: %v", err)
+		}
+
+		var ids []int64
+		for _, event := range events {
+			if strings.HasSuffix(event.AggregateID, suffix) {
+				ids = append(ids, event.ID)
+			}
+		}
+		return ids
+	}
+	now := time.Now()
+	if ready := claim(now); len(ready) != len(expected) {
+		t.Errorf("Expected every pending event to be claimed, got %v", ready)
+	}
+	if ready := claim(now); len(ready) != 0 {
+		t.Errorf("Expected claimed events to be held until the lease expires, got %v", ready)
+	}
+
+	retryAt := now.Add(time.Hour)
+	if err := store.MarkEventFailed(ctx, events[0].ID, "sink unavailable", &retryAt); err != nil {
+		t.Fatalf("MarkEventFailed: %v", err)
+	}
+	if retried := pending(); len(retried) != len(expected) || retried[0].Attempts != 1 {
+		t.Errorf("Expected failed event to stay pending with one attempt, got %+v", retried[0])
+	}
+	// Only the deactivated users and the team are other aggregates than the PR.
+	if ready := claim(now.Add(2 * time.Minute)); fmt.Sprint(ready) != fmt.Sprint([]int64{events[4].ID, events[6].ID, events[9].ID}) {
+		t.Errorf("Expected the PR's events to wait for the retry, got %v", ready)
+	}
+	if ready := claim(retryAt); len(ready) != len(expected) || ready[0] != events[0].ID {
+		t.Errorf("Expected the failed event to be due at its retry time, got %v", ready)
+	}
+
+	if err := store.MarkEventDelivered(ctx, events[0].ID); err != nil {
+		t.Fatalf("MarkEventDelivered: %v", err)
+	}
+	if remaining := pending(); len(remaining) != len(expected)-1 || remaining[0].ID != events[1].ID {
+		t.Errorf("Expected delivered event to leave the outbox, got %+v", remaining)
+	}
+}
+
+func conformWebhooks(t *testing.T, store storage.Storage, suffix string) {
+	ctx := context.Background()
+
+	filtered := &models.WebhookSubscription{URL: "http://filtered.example/" + suffix, Secret: "s1", Events: []string{models.EventPRCreated, models.EventPRMerged}}
+	catchAll := &models.WebhookSubscription{URL: "http://all.example/" + suffix, Secret: "s2"}
+	for _, subscription := range []*models.WebhookSubscription{filtered, catchAll} {
+		if err := store.CreateWebhookSubscription(ctx, subscription); err != nil {
+			t.Fatalf("CreateWebhookSubscription: %v", err)
+		}
+	}
+	if filtered.ID == 0 || catchAll.ID == filtered.ID || filtered.CreatedAt.IsZero() {
+		t.Fatalf("Expected distinct IDs and creation time, got %+v and %+v", filtered, catchAll)
+	}
+
+	created := models.DomainEvent{ID: 101, Type: models.EventPRCreated, AggregateID: "cf-wh-pr-" + suffix, Payload: []byte(`{"pull_request_id":"x"}`), OccurredAt: time.Now().UTC()}
+	assigned := models.DomainEvent{ID: 102, Type: models.EventReviewerAssigned, AggregateID: "cf-wh-pr-" + suffix, Payload: []byte(`{}`), OccurredAt: time.Now().UTC()}
+
+	for i, want := range []int{2, 0} {
+		if enqueued, err := store.EnqueueWebhookDeliveries(ctx, created); err != nil || enqueued != want {
+			t.Errorf("Enqueue #%d: expected %d deliveries, got %d, %v", i+1, want, enqueued, err)
+		}
+	}
+	if enqueued, err := store.EnqueueWebhookDeliveries(ctx, assigned); err != nil || enqueued != 1 {
+		t.Errorf("Expected the filter to skip %s, got %d, %v", assigned.Type, enqueued, err)
+	}
+
+	claim := func(now time.Time) []models.WebhookDelivery {
+		deliveries, err := store.ClaimDueWebhookDeliveries(ctx, now, time.Minute, models.MaxAuditLimit)
+		if err != nil {
+			t.Fatalf("ClaimDueWebhookDeliveries: %v", err)
+		}
+
+		var own []models.WebhookDelivery
+		for _, delivery := range deliveries {
+			if delivery.SubscriptionID == filtered.ID || delivery.SubscriptionID == catchAll.ID {
+				own = append(own, delivery)
+			}
+		}
+		return own
+	}
+
+	now := time.Now()
+	due := claim(now)
+	if len(due) != 3 {
+		t.Fatalf("Expected 3 due deliveries, got %+v", due)
+	}
+	if again := claim(now); len(again) != 0 {
+		t.Errorf("Expected claimed deliveries to be held until the lease expires, got %+v", again)
+	}
+	first := due[0]
+	if first.SubscriptionID != filtered.ID || first.URL != filtered.URL || first.Secret != "s1" || first.EventType != models.EventPRCreated {
+		t.Errorf("Expected first delivery to carry its subscription endpoint, got %+v", first)
+	}
+
+	var body models.DomainEvent
+	if err := json.Unmarshal(first.Payload, &body); err != nil || body.ID != created.ID || string(body.Payload) != string(created.Payload) {
+		t.Errorf("Expected the payload to be the serialized event, got %s, %v", first.Payload, err)
+	}
+
+	retryAt := time.Now().Add(time.Hour)
+	if err := store.RecordWebhookAttempt(ctx, first.ID, models.WebhookAttempt{AttemptedAt: time.Now(), StatusCode: 503, Error: "unavailable"}, models.WebhookPending, &retryAt); err != nil {
+		t.Fatalf("RecordWebhookAttempt: %v", err)
+	}
+	if err := store.RecordWebhookAttempt(ctx, due[1].ID, models.WebhookAttempt{AttemptedAt: time.Now(), StatusCode: 204}, models.WebhookDelivered, nil); err != nil {
+		t.Fatalf("RecordWebhookAttempt: %v", err)
+	}
+
+	if remaining := claim(now.Add(2 * time.Minute)); len(remaining) != 1 || remaining[0].ID != due[2].ID {
+		t.Errorf("Expected only the untouched delivery to be due once its lease expires, got %+v", remaining)
+	}
+
+	retried, err := store.GetWebhookDelivery(ctx, first.ID)
+	if err != nil || retried == nil {
+		t.Fatalf("GetWebhookDelivery: %v, %v", retried, err)
+	}
+	if retried.Status != models.WebhookPending || retried.AttemptCount != 1 || len(retried.Attempts) != 1 || retried.Attempts[0].StatusCode != 503 ||
+		retried.NextAttemptAt == nil || retried.NextAttemptAt.Sub(retryAt).Abs() > time.Second {
+		t.Errorf("Expected a recorded failure scheduled for retry, got %+v", retried)
+	}
+
+	if err := store.ScheduleWebhookDelivery(ctx, first.ID, time.Now()); err != nil {
+		t.Fatalf("ScheduleWebhookDelivery: %v", err)
+	}
+	if rescheduled := claim(now.Add(4 * time.Minute)); len(rescheduled) != 2 {
+		t.Errorf("Expected the rescheduled delivery to be due again, got %+v", rescheduled)
+	}
+
+	deliveries, err := store.GetWebhookDeliveries(ctx, catchAll.ID, 0)
+	if err != nil || len(deliveries) != 2 || deliveries[0].EventID != assigned.ID {
+		t.Fatalf("Expected newest deliveries first for the catch-all subscription, got %+v, %v", deliveries, err)
+	}
+	if delivered := deliveries[1]; delivered.Status != models.WebhookDelivered || delivered.DeliveredAt == nil || len(delivered.Attempts) != 1 {
+		t.Errorf("Expected the delivered attempt to be recorded, got %+v", delivered)
+	}
+
+	if missing, err := store.GetWebhookDelivery(ctx, 999999); err != nil || missing != nil {
+		t.Errorf("Expected nil for an unknown delivery, got %+v, %v", missing, err)
+	}
+	if err := store.ScheduleWebhookDelivery(ctx, 999999, time.Now()); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected sql.ErrNoRows for an unknown delivery, got %v", err)
+	}
+
+	if err := store.DeleteWebhookSubscription(ctx, filtered.ID); err != nil {
+		t.Fatalf("DeleteWebhookSubscription: %v", err)
+	}
+	if err := store.DeleteWebhookSubscription(ctx, filtered.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected sql.ErrNoRows for a deleted subscription, got %v", err)
+	}
+	if gone, err := store.GetWebhookDelivery(ctx, first.ID); err != nil || gone != nil {
+		t.Errorf("Expected deliveries to go with their subscription, got %+v, %v", gone, err)
+	}
+	subscriptions, err := store.GetWebhookSubscriptions(ctx)
+	if err != nil {
+		t.Fatalf("GetWebhookSubscriptions: %v", err)
+	}
+	for _, subscription := range subscriptions {
+		if subscription.ID == filtered.ID {
+			t.Errorf("Expected deleted subscription to be gone, got %+v", subscription)
+		}
+	}
+	if len(subscriptions) == 0 {
+		t.Fatal("Expected the catch-all subscription to remain")
+	}
+	if last := subscriptions[len(subscriptions)-1]; last.ID != catchAll.ID || len(last.Events) != 0 {
+		t.Errorf("Expected the catch-all subscription to remain without a filter, got %+v", last)
+	}
+}
+
+func conformExternalAccounts(t *testing.T, store storage.Storage, suffix string) {
+	ctx := context.Background()
+	first, second := "cf-ext-u1-"+suffix, "cf-ext-u2-"+suffix
+	mustCreateTeam(t, store, &models.Team{
+		TeamName: "cf-ext-" + suffix,
+		Members: []models.TeamMember{
+			{UserID: first, Username: "First", IsActive: true},
+			{UserID: second, Username: "Second", IsActive: true},
+		},
+	})
+
+	login := "Octo-" + suffix
+	if err := store.LinkExternalAccount(ctx, models.ProviderGitHub, login, first); err != nil {
+		t.Fatalf("LinkExternalAccount: %v", err)
+	}
+	if userID, err := store.GetExternalAccountUser(ctx, models.ProviderGitHub, strings.ToLower(login)); err != nil || userID != first {
+		t.Errorf("Expected logins to match case-insensitively, got %q, %v", userID, err)
+	}
+
+	if err := store.LinkExternalAccount(ctx, models.ProviderGitHub, login, second); err != nil {
+		t.Fatalf("LinkExternalAccount relink: %v", err)
+	}
+	if userID, err := store.GetExternalAccountUser(ctx, models.ProviderGitHub, login); err != nil || userID != second {
+		t.Errorf("Expected relinking to replace the user, got %q, %v", userID, err)
+	}
+
+	if userID, err := store.GetExternalAccountUser(ctx, "gitlab", login); err != nil || userID != "" {
+		t.Errorf("Expected accounts to be scoped by provider, got %q, %v", userID, err)
+	}
+	if err := store.LinkExternalAccount(ctx, models.ProviderGitHub, "ghost-"+suffix, "cf-ext-missing-"+suffix); err == nil {
+		t.Error("Expected linking to an unknown user to fail")
+	}
+}
+
+func conformInboundDeliveries(t *testing.T, store storage.Storage, suffix string) {
+	ctx := context.Background()
+	deliveryID := "cf-delivery-" + suffix
+
+	rolledBack := errors.New("rolled back")
+	err := store.WithinTx(ctx, func(tx storage.Storage) error {
+		if first, err := tx.RecordInboundDelivery(ctx, models.ProviderGitHub, deliveryID); err != nil || !first {
+			t.Errorf("Expected a new delivery inside the transaction, got %v, %v", first, err)
+		}
+		return rolledBack
+	})
+	if !errors.Is(err, rolledBack) {
+		t.Fatalf("Expected WithinTx to return the callback error, got %v", err)
+	}
+
+	for i, want := range []bool{true, false} {
+		if first, err := store.RecordInboundDelivery(ctx, models.ProviderGitHub, deliveryID); err != nil || first != want {
+			t.Errorf("Record #%d: expected first=%v after the rollback, got %v, %v", i+1, want, first, err)
+		}
+	}
+	if first, err := store.RecordInboundDelivery(ctx, "gitlab", deliveryID); err != nil || !first {
+		t.Errorf("Expected delivery IDs to be scoped by source, got %v, %v", first, err)
+	}
+}
+
+func conformAPIKeys(t *testing.T, store storage.Storage, suffix string) {
+	ctx := context.Background()
+	teamName := "cf-keys-" + suffix
+	mustCreateTeam(t, store, &models.Team{TeamName: teamName, Members: []models.TeamMember{}})
+
+	admin := &models.APIKey{Name: "cf-admin-" + suffix, Role: models.RoleAdmin}
+	lead := &models.APIKey{Name: "cf-lead-" + suffix, Role: models.RoleTeamLead, TeamName: teamName}
+	for _, key := range []*models.APIKey{admin, lead} {
+		if err := store.CreateAPIKey(ctx, key, "hash-"+key.Name); err != nil {
+			t.Fatalf("CreateAPIKey(%s): %v", key.Name, err)
+		}
+	}
+	if admin.ID == 0 || lead.ID == admin.ID || admin.CreatedAt.IsZero() {
+		t.Fatalf("Expected distinct IDs and creation time, got %+v and %+v", admin, lead)
+	}
+	if err := store.CreateAPIKey(ctx, &models.APIKey{Name: "dup", Role: models.RoleBot}, "hash-"+admin.Name); err == nil {
+		t.Error("Expected a duplicate key hash to be rejected")
+	}
+
+	found, err := store.GetAPIKeyByHash(ctx, "hash-"+lead.Name)
+	if err != nil || found == nil || found.ID != lead.ID || found.Role != models.RoleTeamLead || found.TeamName != teamName || found.RevokedAt != nil {
+		t.Fatalf("Expected the lead key by hash, got %+v, %v", found, err)
+	}
+	if missing, err := store.GetAPIKeyByHash(ctx, "hash-missing-"+suffix); err != nil || missing != nil {
+		t.Errorf("Expected nil for an unknown hash, got %+v, %v", missing, err)
+	}
+
+	if err := store.RevokeAPIKey(ctx, lead.ID); err != nil {
+		t.Fatalf("RevokeAPIKey: %v", err)
+	}
+	if err := store.RevokeAPIKey(ctx, lead.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected sql.ErrNoRows for an already revoked key, got %v", err)
+	}
+	if revoked, err := store.GetAPIKeyByHash(ctx, "hash-"+lead.Name); err != nil || revoked == nil || revoked.RevokedAt == nil {
+		t.Errorf("Expected the revoked key to carry its revocation time, got %+v, %v", revoked, err)
+	}
+
+	keys, err := store.GetAPIKeys(ctx)
+	if err != nil {
+		t.Fatalf("GetAPIKeys: %v", err)
+	}
+	var own []models.APIKey
+	for _, key := range keys {
+		if key.ID == admin.ID || key.ID == lead.ID {
+			own = append(own, key)
+		}
+	}
+	if len(own) != 2 || own[0].ID != admin.ID || own[0].RevokedAt != nil || own[1].RevokedAt == nil {
+		t.Errorf("Expected both keys in creation order, got %+v", own)
+	}
+}
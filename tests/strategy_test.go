@@ -0,0 +1,89 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/Chamistery/Test_task/internal/models"
+	"github.com/Chamistery/Test_task/internal/service"
+)
+
+func TestStrategiesRespectCount(t *testing.T) {
+	candidates := []string{"s-u1", "s-u2", "s-u3"}
+	loads := map[string]int{"s-u1": 0, "s-u2": 0, "s-u3": 0}
+
+	for _, name := range []string{
+		models.StrategyRandom,
+		models.StrategyRoundRobin,
+		models.StrategyLeastLoaded,
+		models.StrategyWeighted,
+	} {
+		strategy := service.NewStrategy(name)
+		if strategy == nil {
+			t.Fatalf("Expected strategy %s to exist", name)
+		}
+
+		selected := strategy.Select("strategy-team", candidates, loads, 2)
+		if len(selected) != 2 {
+			t.Errorf("%s: expected 2 reviewers, got %d", name, len(selected))
+		}
+		if len(selected) == 2 && selected[0] == selected[1] {
+			t.Errorf("%s: reviewer %s selected twice", name, selected[0])
+		}
+
+		selected = strategy.Select("strategy-team", candidates[:1], loads, 2)
+		if len(selected) != 1 {
+			t.Errorf("%s: expected 1 reviewer when one candidate, got %d", name, len(selected))
+		}
+	}
+
+	if service.NewStrategy("unknown") != nil {
+		t.Error("Expected unknown strategy to be rejected")
+	}
+}
+
+func TestRoundRobinStrategyRotates(t *testing.T) {
+	strategy := service.NewRoundRobinStrategy()
+	candidates := []string{"rr-u1", "rr-u2", "rr-u3"}
+
+	var picked []string
+	for i := 0; i < 4; i++ {
+		picked = append(picked, strategy.Select("rr-team", candidates, nil, 1)...)
+	}
+
+	expected := []string{"rr-u1", "rr-u2", "rr-u3", "rr-u1"}
+	for i := range expected {
+		if picked[i] != expected[i] {
+			t.Fatalf("Expected rotation %v, got %v", expected, picked)
+		}
+	}
+}
+
+func TestLeastLoadedStrategyPrefersIdleReviewers(t *testing.T) {
+	strategy := service.NewStrategy(models.StrategyLeastLoaded)
+	candidates := []string{"ll-u1", "ll-u2", "ll-u3"}
+	loads := map[string]int{"ll-u1": 4, "ll-u2": 0, "ll-u3": 1}
+
+	selected := strategy.Select("ll-team", candidates, loads, 2)
+	if len(selected) != 2 || selected[0] != "ll-u2" || selected[1] != "ll-u3" {
+		t.Errorf("Expected [ll-u2 ll-u3], got %v", selected)
+	}
+}
+
+func TestLeastLoadedStrategyBreaksTiesRandomly(t *testing.T) {
+	strategy := service.NewStrategy(models.StrategyLeastLoaded)
+	candidates := []string{"tie-u1", "tie-u2", "tie-u3", "tie-busy"}
+	loads := map[string]int{"tie-u1": 1, "tie-u2": 1, "tie-u3": 1, "tie-busy": 3}
+
+	seen := make(map[string]bool)
+	for i := 0; i < 200; i++ {
+		selected := strategy.Select("tie-team", candidates, loads, 1)
+		if selected[0] == "tie-busy" {
+			t.Fatal("Expected most loaded reviewer never to be picked")
+		}
+		seen[selected[0]] = true
+	}
+
+	if len(seen) != 3 {
+		t.Errorf("Expected ties to be broken randomly across 3 reviewers, saw %v", seen)
+	}
+}
@@ -0,0 +1,176 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Chamistery/Test_task/internal/events"
+	"github.com/Chamistery/Test_task/internal/models"
+	"github.com/Chamistery/Test_task/internal/webhooks"
+)
+
+func TestOutgoingWebhooks(t *testing.T) {
+	store := newTestStorage(t, os.Getenv("TEST_STORAGE_DRIVER"))
+	server, _, cleanup := setupTestServerWithStorage(t, store)
+	defer cleanup()
+
+	const secret = "webhook-secret"
+	var mu sync.Mutex
+	var received []string
+	failNext := true
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !webhooks.Verify(secret, body, r.Header.Get(webhooks.SignatureHeader)) || r.Header.Get(webhooks.DeliveryHeader) == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var event models.DomainEvent
+		json.Unmarshal(body, &event)
+
+		mu.Lock()
+		defer mu.Unlock()
+		if failNext {
+			failNext = false
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if event.Type != r.Header.Get(webhooks.EventHeader) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received = append(received, event.Type)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	post := func(path string, payload interface{}) *http.Response {
+		body, _ := json.Marshal(payload)
+		resp, err := http.Post(server.URL+path, "application/json", bytes.NewBuffer(body))
+		if err != nil {
+			t.Fatalf("Request to %s failed: %v", path, err)
+		}
+		return resp
+	}
+
+	for _, invalid := range []models.WebhookSubscription{
+		{URL: "ftp://example.com", Secret: secret},
+		{URL: receiver.URL, Secret: ""},
+		{URL: receiver.URL, Secret: secret, Events: []string{"PRExploded"}},
+	} {
+		resp := post("/webhook/add", invalid)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %+v, got %d", invalid, resp.StatusCode)
+		}
+	}
+
+	resp := post("/webhook/add", models.WebhookSubscription{
+		URL:    receiver.URL,
+		Secret: secret,
+		Events: []string{models.EventPRCreated, models.EventReviewerReplaced, models.EventPRMerged},
+	})
+	var subscription models.WebhookSubscription
+	json.NewDecoder(resp.Body).Decode(&subscription)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || subscription.ID == 0 || subscription.Secret != "" {
+		t.Fatalf("Expected subscription without its secret, got %d %+v", resp.StatusCode, subscription)
+	}
+
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	author := "wh-u1-" + suffix
+	post("/team/add", models.Team{
+		TeamName: "webhooks-" + suffix,
+		Members: []models.TeamMember{
+			{UserID: author, Username: "Author", IsActive: true},
+			{UserID: "wh-u2-" + suffix, Username: "Rev1", IsActive: true},
+			{UserID: "wh-u3-" + suffix, Username: "Rev2", IsActive: true},
+		},
+	}).Body.Close()
+
+	one := 1
+	prID := "pr-webhooks-" + suffix
+	resp = post("/pullRequest/create", models.CreatePRRequest{PullRequestID: prID, PullRequestName: "Hooks", AuthorID: author, ReviewersCount: &one})
+	var created models.CreatePRResponse
+	json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+
+	post("/pullRequest/reassign", models.ReassignRequest{PullRequestID: prID, OldUserID: created.PR.AssignedReviewers[0]}).Body.Close()
+	post("/pullRequest/merge", models.MergePRRequest{PullRequestID: prID, Force: true}).Body.Close()
+
+	ctx := context.Background()
+	dispatcher := events.NewDispatcher(store, events.DispatcherConfig{BatchSize: 1000})
+	dispatcher.Register(webhooks.NewSink(store))
+	if _, err := dispatcher.DispatchPending(ctx); err != nil {
+		t.Fatalf("DispatchPending: %v", err)
+	}
+
+	deliverer := webhooks.NewDeliverer(store, webhooks.Config{BaseBackoff: time.Hour})
+	if attempted, err := deliverer.DeliverDue(ctx); err != nil || attempted != 3 {
+		t.Fatalf("Expected 3 attempts, got %d, %v", attempted, err)
+	}
+	if attempted, err := deliverer.DeliverDue(ctx); err != nil || attempted != 0 {
+		t.Fatalf("Expected the failed delivery to back off, got %d attempts, %v", attempted, err)
+	}
+
+	deliveries := func() []models.WebhookDelivery {
+		resp, err := http.Get(fmt.Sprintf("%s/webhook/deliveries?subscription_id=%d", server.URL, subscription.ID))
+		if err != nil {
+			t.Fatalf("Failed to list deliveries: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var result models.WebhookDeliveriesResponse
+		json.NewDecoder(resp.Body).Decode(&result)
+		return result.Deliveries
+	}
+
+	listed := deliveries()
+	if len(listed) != 3 {
+		t.Fatalf("Expected 3 deliveries, got %+v", listed)
+	}
+	failed := listed[len(listed)-1]
+	if failed.EventType != models.EventPRCreated || failed.Status != models.WebhookPending || len(failed.Attempts) != 1 ||
+		failed.Attempts[0].StatusCode != http.StatusServiceUnavailable || failed.NextAttemptAt == nil || time.Until(*failed.NextAttemptAt) < 50*time.Minute {
+		t.Fatalf("Expected the first delivery to wait an hour after a 503, got %+v", failed)
+	}
+
+	resp = post("/webhook/redeliver", models.RedeliverWebhookRequest{DeliveryID: failed.ID})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("Expected 202 for redelivery, got %d", resp.StatusCode)
+	}
+	if attempted, err := deliverer.DeliverDue(ctx); err != nil || attempted != 1 {
+		t.Fatalf("Expected the redelivery to be attempted, got %d, %v", attempted, err)
+	}
+
+	for _, delivery := range deliveries() {
+		if delivery.Status != models.WebhookDelivered {
+			t.Errorf("Expected every delivery to succeed, got %+v", delivery)
+		}
+	}
+
+	mu.Lock()
+	slices.Sort(received)
+	got := fmt.Sprint(received)
+	mu.Unlock()
+	if expected := fmt.Sprint([]string{models.EventPRCreated, models.EventPRMerged, models.EventReviewerReplaced}); got != expected {
+		t.Errorf("Expected receiver to get %s once each, got %s", expected, got)
+	}
+
+	resp = post("/webhook/redeliver", models.RedeliverWebhookRequest{DeliveryID: 999999})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown delivery, got %d", resp.StatusCode)
+	}
+}